@@ -1,12 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"futsal-bot/internal/bot"
 	"futsal-bot/internal/database"
 	"futsal-bot/internal/handlers"
+	"futsal-bot/internal/messenger"
+	"futsal-bot/internal/server"
+	"futsal-bot/internal/worker"
 	"futsal-bot/pkg/logger"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -28,22 +41,91 @@ func main() {
 		_, _ = os.Stderr.WriteString("failed to init logger: " + err.Error() + "\n")
 		os.Exit(1)
 	}
-	defer func() { _ = zapLogger.Sync() }()
 	zap.ReplaceGlobals(zapLogger)
 
+	// SIGTERM (docker stop) and Ctrl+C start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = run(ctx)
+	stop()
+
+	if err != nil {
+		zap.L().Error("Bot stopped with an error", zap.Error(err))
+	} else {
+		zap.L().Info("Bot stopped")
+	}
+	_ = zapLogger.Sync()
+
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// run starts the bot and serves updates until ctx is cancelled, then shuts
+// down. It returns an error when the bot could not start, failed while
+// running, or did not finish the updates in flight in time.
+func run(ctx context.Context) error {
 	botToken := os.Getenv("BOT_TOKEN")
 	if botToken == "" {
-		zap.L().Fatal("BOT_TOKEN is required")
+		return errors.New("BOT_TOKEN is required")
 	}
 
-	defaultAdminIDStr := os.Getenv("DEFAULT_ADMIN_ID")
-	if defaultAdminIDStr == "" {
-		zap.L().Fatal("DEFAULT_ADMIN_ID is required")
+	// DEFAULT_ADMIN_ID is the single superadmin of older configs
+	superadminIDs, err := parseIDs(os.Getenv("SUPERADMIN_IDS") + "," + os.Getenv("DEFAULT_ADMIN_ID"))
+	if err != nil {
+		return fmt.Errorf("invalid SUPERADMIN_IDS: %w", err)
+	}
+	if len(superadminIDs) == 0 {
+		return errors.New("SUPERADMIN_IDS is required")
+	}
+
+	revertWindow, err := time.ParseDuration(getEnv("ATTENDANCE_REVERT_WINDOW", "1h"))
+	if err != nil {
+		return fmt.Errorf("invalid ATTENDANCE_REVERT_WINDOW: %w", err)
 	}
 
-	defaultAdminID, err := strconv.ParseInt(defaultAdminIDStr, 10, 64)
+	stateTTL, err := time.ParseDuration(getEnv("STATE_TTL", "24h"))
 	if err != nil {
-		zap.L().Fatal("Invalid DEFAULT_ADMIN_ID", zap.Error(err))
+		return fmt.Errorf("invalid STATE_TTL: %w", err)
+	}
+
+	inviteTTL, err := time.ParseDuration(getEnv("INVITE_TTL", "168h"))
+	if err != nil {
+		return fmt.Errorf("invalid INVITE_TTL: %w", err)
+	}
+
+	shutdownTimeout, err := time.ParseDuration(getEnv("SHUTDOWN_TIMEOUT", "20s"))
+	if err != nil {
+		return fmt.Errorf("invalid SHUTDOWN_TIMEOUT: %w", err)
+	}
+
+	workers, err := strconv.Atoi(getEnv("UPDATE_WORKERS", "16"))
+	if err != nil || workers < 1 {
+		return fmt.Errorf("invalid UPDATE_WORKERS %q", os.Getenv("UPDATE_WORKERS"))
+	}
+
+	queueSize, err := strconv.Atoi(getEnv("UPDATE_QUEUE_SIZE", "100"))
+	if err != nil || queueSize < 1 {
+		return fmt.Errorf("invalid UPDATE_QUEUE_SIZE %q", os.Getenv("UPDATE_QUEUE_SIZE"))
+	}
+
+	var webhookURL *url.URL
+	webhookSecret := os.Getenv("WEBHOOK_SECRET")
+	runMode := getEnv("RUN_MODE", "polling")
+	switch runMode {
+	case "polling":
+	case "webhook":
+		webhookURL, err = url.Parse(os.Getenv("WEBHOOK_URL"))
+		if err != nil || webhookURL.Scheme != "https" || webhookURL.Host == "" {
+			return errors.New("WEBHOOK_URL must be an https URL in webhook mode")
+		}
+		if webhookURL.Path == "" || webhookURL.Path == "/" {
+			webhookURL.Path = "/webhook"
+		}
+		if webhookSecret == "" {
+			return errors.New("WEBHOOK_SECRET is required in webhook mode")
+		}
+	default:
+		return fmt.Errorf("invalid RUN_MODE %q", runMode)
 	}
 
 	dbConfig := database.Config{
@@ -57,47 +139,185 @@ func main() {
 
 	db, err := database.New(dbConfig)
 	if err != nil {
-		zap.L().Fatal("Failed to connect to database", zap.Error(err))
+		return fmt.Errorf("failed to connect to database: %w", err)
 	}
-	defer db.Close()
+	defer func() {
+		if err := db.Close(); err != nil {
+			zap.L().Error("Failed to close database", zap.Error(err))
+		}
+	}()
 
 	zap.L().Info("Running database migrations...")
 	if err := db.RunMigrations(); err != nil {
-		zap.L().Fatal("Failed to run migrations", zap.Error(err))
+		return fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	var states bot.StateStore = db
+	switch storeName := getEnv("STATE_STORE", "postgres"); storeName {
+	case "postgres":
+	case "memory":
+		states = bot.NewMemoryStateStore()
+	default:
+		return fmt.Errorf("invalid STATE_STORE %q", storeName)
+	}
+
+	inviteSecret := os.Getenv("INVITE_SECRET")
+	if inviteSecret == "" {
+		zap.L().Warn("INVITE_SECRET is not set; invite links are disabled")
 	}
 
-	b, err := bot.New(botToken, db, defaultAdminID)
+	m, err := messenger.New(messenger.Config{
+		Platform: messenger.Platform(getEnv("PLATFORM", string(messenger.Bale))),
+		Token:    botToken,
+		Endpoint: os.Getenv("API_ENDPOINT"),
+	})
 	if err != nil {
-		zap.L().Fatal("Failed to create bot", zap.Error(err))
-	}
-
-	zap.L().Info("Bot started successfully")
-
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
-	updates := b.API.GetUpdatesChan(u)
-
-	for update := range updates {
-		if update.Message != nil {
-			if update.Message.Chat.IsPrivate() {
-				if update.Message.IsCommand() {
-					switch update.Message.Command() {
-					case "start":
-						handlers.HandleStart(b, update.Message)
-					default:
-						b.SendMessage(update.Message.Chat.ID,
-							"دستور نامعتبر. از /start استفاده کنید.", nil)
-					}
-				} else {
-					handlers.HandleMessage(b, update.Message)
-				}
-			} else {
-				handlers.HandleGroupMessage(b, update.Message)
+		return fmt.Errorf("failed to connect to messenger: %w", err)
+	}
+
+	b := bot.New(m, db, bot.Config{
+		SuperadminIDs: superadminIDs,
+		RevertWindow:  revertWindow,
+		States:        states,
+		StateTTL:      stateTTL,
+		InviteSecret:  []byte(inviteSecret),
+		InviteTTL:     inviteTTL,
+	})
+
+	// Drop abandoned flows now and then; GetState also ignores them on read
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			b.PruneStates()
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Health checks are served in both modes; updates only in webhook mode
+	srvConfig := server.Config{
+		Addr:  ":" + getEnv("APP_PORT", "8080"),
+		Ready: db.PingContext,
+	}
+	if webhookURL != nil {
+		if m.Capabilities().WebhookSecret {
+			srvConfig.SecretToken = webhookSecret
+		} else {
+			// Without the secret token header only the secret path proves
+			// an update came from the platform
+			webhookURL.Path = path.Join(webhookURL.Path, webhookSecret)
+		}
+		srvConfig.WebhookPath = webhookURL.Path
+	}
+	srv := server.New(srvConfig)
+	srvErr := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			srvErr <- err
+		}
+	}()
+
+	var updates tgbotapi.UpdatesChannel
+	if webhookURL != nil {
+		if err := m.SetWebhook(webhookURL.String(), webhookSecret); err != nil {
+			return fmt.Errorf("failed to set webhook: %w", err)
+		}
+		updates = srv.Updates()
+	} else {
+		// A webhook left over from webhook mode blocks getUpdates
+		if err := m.DeleteWebhook(); err != nil {
+			return fmt.Errorf("failed to delete webhook: %w", err)
+		}
+		updates = m.Updates(60)
+	}
+
+	zap.L().Info("Bot started successfully", zap.String("run_mode", runMode), zap.Int("workers", workers))
+
+	// Chats are handled concurrently, each chat's updates in order
+	pool := worker.NewPool(workers, queueSize, func(update tgbotapi.Update) {
+		handlers.HandleUpdate(b, update)
+	})
+
+	var runErr error
+serve:
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				runErr = errors.New("update channel closed")
+				break serve
 			}
-		} else if update.CallbackQuery != nil {
-			handlers.HandleCallbackQuery(b, update.CallbackQuery)
+			if err := pool.Submit(ctx, update); err != nil {
+				zap.L().Error("Dropped update", zap.Error(err), zap.Int("update_id", update.UpdateID))
+			}
+		case err := <-srvErr:
+			runErr = fmt.Errorf("HTTP server failed: %w", err)
+			break serve
+		case <-ctx.Done():
+			break serve
+		}
+	}
+
+	zap.L().Info("Shutting down", zap.Duration("timeout", shutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Stop taking updates first, then finish the ones already taken
+	m.StopUpdates()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		runErr = errors.Join(runErr, fmt.Errorf("failed to stop HTTP server: %w", err))
+	}
+	drainUpdates(shutdownCtx, updates, pool)
+
+	if err := pool.Shutdown(shutdownCtx); err != nil {
+		runErr = errors.Join(runErr, fmt.Errorf("failed to finish updates in flight: %w", err))
+	}
+
+	return runErr
+}
+
+// drainUpdates hands the pool the updates that were received, and in
+// webhook mode already acknowledged, but not yet queued.
+func drainUpdates(ctx context.Context, updates tgbotapi.UpdatesChannel, pool *worker.Pool) {
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			if err := pool.Submit(ctx, update); err != nil {
+				zap.L().Error("Dropped update", zap.Error(err), zap.Int("update_id", update.UpdateID))
+			}
+		default:
+			return
+		}
+	}
+}
+
+// parseIDs parses a comma-separated list of Telegram IDs, skipping blanks
+// and duplicates.
+func parseIDs(list string) ([]int64, error) {
+	seen := map[int64]bool{}
+	var ids []int64
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
 		}
 	}
+	return ids, nil
 }
 
 func getEnv(key, def string) string {
@@ -3,108 +3,270 @@ package bot
 import (
 	"fmt"
 	"futsal-bot/internal/database"
+	"futsal-bot/internal/invite"
+	"futsal-bot/internal/messenger"
 	"futsal-bot/internal/models"
-	"sync"
+	"strconv"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"go.uber.org/zap"
 )
 
 type Bot struct {
-	API            *tgbotapi.BotAPI
-	DB             *database.DB
-	DefaultAdminID int64
-	States         map[int64]*models.UserState
-	StatesMutex    sync.RWMutex
+	Messenger     messenger.Messenger
+	DB            *database.DB
+	SuperadminIDs []int64
+	RevertWindow  time.Duration
+	States        StateStore
+	StateTTL      time.Duration
+	InviteSecret  []byte
+	InviteTTL     time.Duration
+
+	chatMembers chatMemberCache
+}
+
+// Config holds the settings of a Bot beyond its API token and database.
+type Config struct {
+	// SuperadminIDs are the Telegram IDs that own every group.
+	SuperadminIDs []int64
+	// RevertWindow is how long after taking attendance it may be reverted.
+	RevertWindow time.Duration
+	// States keeps in-progress flows; StateTTL is how long they stay valid idle.
+	States   StateStore
+	StateTTL time.Duration
+	// InviteSecret signs invite links; invites are disabled when it is empty.
+	InviteSecret []byte
+	InviteTTL    time.Duration
+}
+
+// New creates a bot that talks to users through m.
+func New(m messenger.Messenger, db *database.DB, cfg Config) *Bot {
+	zap.L().Info("Authorized on account",
+		zap.String("platform", string(m.Platform())), zap.String("username", m.Self().UserName))
+
+	return &Bot{
+		Messenger:     m,
+		DB:            db,
+		SuperadminIDs: cfg.SuperadminIDs,
+		RevertWindow:  cfg.RevertWindow,
+		States:        cfg.States,
+		StateTTL:      cfg.StateTTL,
+		InviteSecret:  cfg.InviteSecret,
+		InviteTTL:     cfg.InviteTTL,
+	}
 }
 
-func New(token string, db *database.DB, defaultAdminID int64) (*Bot, error) {
-	// api, err := tgbotapi.NewBotAPI(token)
-	api, err := tgbotapi.NewBotAPIWithAPIEndpoint(token, "https://tapi.bale.ai/bot%s/%s")
+// SetState saves the step a flow of a user in a chat is waiting on, together
+// with the data collected so far. The flow is named by its data.
+func (b *Bot) SetState(userID, chatID int64, state string, data models.FlowData) {
+	err := b.States.SaveUserState(&models.UserState{
+		UserID:      userID,
+		ChatID:      chatID,
+		Flow:        data.FlowName(),
+		State:       state,
+		Data:        data,
+		LastUpdated: time.Now(),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create bot: %w", err)
+		zap.L().Error("Error saving state", zap.Error(err), zap.Int64("user_id", userID), zap.String("state", state))
 	}
+}
 
-	zap.L().Info("Authorized on account", zap.String("username", api.Self.UserName))
+// GetState returns one flow of a user in a chat, or nil when it is not in
+// progress or has been idle for longer than StateTTL.
+func (b *Bot) GetState(userID, chatID int64, flow string) *models.UserState {
+	state, err := b.States.GetUserState(userID, chatID, flow)
+	if err != nil {
+		zap.L().Error("Error loading state", zap.Error(err), zap.Int64("user_id", userID), zap.String("flow", flow))
+		return nil
+	}
 
-	return &Bot{
-		API:            api,
-		DB:             db,
-		DefaultAdminID: defaultAdminID,
-		States:         make(map[int64]*models.UserState),
-	}, nil
+	return b.liveState(state)
 }
 
-func (b *Bot) SetState(userID int64, state string, data map[string]interface{}) {
-	b.StatesMutex.Lock()
-	defer b.StatesMutex.Unlock()
+// ActiveState returns the flow of a user in a chat that was touched last.
+// Free-text messages are routed to it.
+func (b *Bot) ActiveState(userID, chatID int64) *models.UserState {
+	state, err := b.States.GetLatestUserState(userID, chatID)
+	if err != nil {
+		zap.L().Error("Error loading state", zap.Error(err), zap.Int64("user_id", userID))
+		return nil
+	}
+
+	return b.liveState(state)
+}
 
-	b.States[userID] = &models.UserState{
-		UserID:   userID,
-		State:    state,
-		TempData: data,
+func (b *Bot) liveState(state *models.UserState) *models.UserState {
+	if state != nil && b.StateTTL > 0 && time.Since(state.LastUpdated) > b.StateTTL {
+		b.ClearState(state.UserID, state.ChatID, state.Flow)
+		return nil
 	}
+	return state
 }
 
-func (b *Bot) GetState(userID int64) *models.UserState {
-	b.StatesMutex.RLock()
-	defer b.StatesMutex.RUnlock()
+func (b *Bot) ClearState(userID, chatID int64, flow string) {
+	if err := b.States.DeleteUserState(userID, chatID, flow); err != nil {
+		zap.L().Error("Error clearing state", zap.Error(err), zap.Int64("user_id", userID), zap.String("flow", flow))
+	}
+}
 
-	return b.States[userID]
+// ClearStates aborts every flow of a user in a chat and returns how many
+// were in progress.
+func (b *Bot) ClearStates(userID, chatID int64) int64 {
+	removed, err := b.States.DeleteUserStates(userID, chatID)
+	if err != nil {
+		zap.L().Error("Error clearing states", zap.Error(err), zap.Int64("user_id", userID))
+	}
+	return removed
 }
 
-func (b *Bot) ClearState(userID int64) {
-	b.StatesMutex.Lock()
-	defer b.StatesMutex.Unlock()
+// PruneStates drops every state idle for longer than StateTTL.
+func (b *Bot) PruneStates() {
+	if b.StateTTL <= 0 {
+		return
+	}
 
-	delete(b.States, userID)
+	removed, err := b.States.DeleteStaleUserStates(time.Now().Add(-b.StateTTL))
+	if err != nil {
+		zap.L().Error("Error pruning states", zap.Error(err))
+		return
+	}
+	if removed > 0 {
+		zap.L().Info("Pruned stale states", zap.Int64("count", removed))
+	}
 }
 
-func (b *Bot) IsDefaultAdmin(userID int64) bool {
-	return userID == b.DefaultAdminID
+// InviteLink returns a signed deep link that opens registration for a group,
+// and when it expires. ok is false when invites are disabled.
+func (b *Bot) InviteLink(groupID int64) (link string, expiresAt time.Time, ok bool) {
+	if len(b.InviteSecret) == 0 {
+		return "", time.Time{}, false
+	}
+
+	expiresAt = time.Now().Add(b.InviteTTL)
+	token := invite.Sign(b.InviteSecret, groupID, expiresAt)
+	return b.Messenger.DeepLink(token), expiresAt, true
 }
 
-func (b *Bot) SendMessage(chatID int64, text string, replyMarkup interface{}) error {
-	msg := tgbotapi.NewMessage(chatID, text)
-	if replyMarkup != nil {
-		msg.ReplyMarkup = replyMarkup
+// VerifyInvite returns the group an invite token from a deep link is for.
+func (b *Bot) VerifyInvite(token string) (int64, error) {
+	if len(b.InviteSecret) == 0 {
+		return 0, invite.ErrInvalid
 	}
+	return invite.Verify(b.InviteSecret, token, time.Now())
+}
 
-	_, err := b.API.Send(msg)
-	return err
+func (b *Bot) IsSuperadmin(userID int64) bool {
+	for _, id := range b.SuperadminIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
 }
 
-func (b *Bot) SendMessageWithMarkdown(chatID int64, text string, replyMarkup interface{}) error {
-	msg := tgbotapi.NewMessage(chatID, text)
-	msg.ParseMode = "Markdown"
-	if replyMarkup != nil {
-		msg.ReplyMarkup = replyMarkup
+// Authorize returns what a Telegram user may do in a group. Superadmins own
+// every group; users without an active membership may do nothing.
+func (b *Bot) Authorize(telegramID, groupID int64) (*models.Access, error) {
+	user, err := b.DB.GetUserByTelegramID(telegramID)
+	if err != nil {
+		return nil, err
+	}
+
+	access := &models.Access{User: user, GroupID: groupID, Permission: models.PermissionOwner}
+	if !b.IsSuperadmin(telegramID) {
+		access.Permission, err = b.DB.GetPermission(user.ID, groupID)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	_, err := b.API.Send(msg)
+	return access, nil
+}
+
+func (b *Bot) SendMessage(chatID int64, text string, replyMarkup interface{}) error {
+	_, err := b.SendMessageWithID(chatID, text, replyMarkup)
+	return err
+}
+
+// SendMessageWithID sends a message and returns its ID so it can be edited later.
+func (b *Bot) SendMessageWithID(chatID int64, text string, replyMarkup interface{}) (int, error) {
+	return b.Messenger.SendText(chatID, text, messenger.SendOptions{ReplyMarkup: replyMarkup})
+}
+
+// SendMessageWithMarkdown sends a Markdown message, as plain text on
+// platforms without Markdown.
+func (b *Bot) SendMessageWithMarkdown(chatID int64, text string, replyMarkup interface{}) error {
+	_, err := b.Messenger.SendText(chatID, text, messenger.SendOptions{Markdown: true, ReplyMarkup: replyMarkup})
 	return err
 }
 
+// SendPhoto sends a photo already stored on the platform's servers.
+func (b *Bot) SendPhoto(chatID int64, fileID string, caption string) error {
+	return b.Messenger.SendPhoto(chatID, fileID, caption)
+}
+
 func (b *Bot) EditMessage(chatID int64, messageID int, text string, replyMarkup interface{}) error {
-	msg := tgbotapi.NewEditMessageText(chatID, messageID, text)
-	if replyMarkup != nil {
-		if markup, ok := replyMarkup.(*tgbotapi.InlineKeyboardMarkup); ok {
-			msg.ReplyMarkup = markup
-		}
+	markup, _ := replyMarkup.(*tgbotapi.InlineKeyboardMarkup)
+	return b.Messenger.EditText(chatID, messageID, text, markup)
+}
+
+// IsChatMember asks the platform whether a user is currently in a chat.
+func (b *Bot) IsChatMember(chatID, userID int64) (bool, error) {
+	member, err := b.Messenger.GetChatMember(chatID, userID)
+	if err != nil {
+		return false, err
 	}
 
-	_, err := b.API.Send(msg)
-	return err
+	inChat := false
+	switch member.Status {
+	case "creator", "administrator", "member":
+		inChat = true
+	case "restricted":
+		inChat = member.IsMember
+	}
+
+	b.chatMembers.set(chatID, userID, inChat)
+	return inChat, nil
+}
+
+// IsChatAdmin asks the platform whether a user created or administers a
+// chat.
+func (b *Bot) IsChatAdmin(chatID, userID int64) (bool, error) {
+	member, err := b.Messenger.GetChatMember(chatID, userID)
+	if err != nil {
+		return false, err
+	}
+	return member.Status == "creator" || member.Status == "administrator", nil
+}
+
+// WasChatMember is IsChatMember that may answer from a lookup made within
+// the last minute.
+func (b *Bot) WasChatMember(chatID, userID int64) (bool, error) {
+	if member, ok := b.chatMembers.get(chatID, userID); ok {
+		return member, nil
+	}
+	return b.IsChatMember(chatID, userID)
+}
+
+// ForgetChatMember drops the cached lookup of a user who joined or left a
+// chat.
+func (b *Bot) ForgetChatMember(chatID, userID int64) {
+	b.chatMembers.forget(chatID, userID)
 }
 
 func (b *Bot) AnswerCallbackQuery(callbackID string, text string) error {
-	callback := tgbotapi.NewCallback(callbackID, text)
-	_, err := b.API.Request(callback)
-	return err
+	return b.Messenger.AnswerCallback(callbackID, text)
+}
+
+// IsSelf reports whether a user is the bot itself.
+func (b *Bot) IsSelf(userID int64) bool {
+	return b.Messenger.Self().ID == userID
 }
 
 // Keyboard builders
-func (b *Bot) MainMenuKeyboard(userID, groupID int64, isAdmin bool) tgbotapi.InlineKeyboardMarkup {
+func (b *Bot) MainMenuKeyboard(userID, groupID int64, permission models.Permission) tgbotapi.InlineKeyboardMarkup {
 	var rows [][]tgbotapi.InlineKeyboardButton
 
 	// Check if user is registered in this group
@@ -115,6 +277,11 @@ func (b *Bot) MainMenuKeyboard(userID, groupID int64, isAdmin bool) tgbotapi.Inl
 		rows = append(rows, []tgbotapi.InlineKeyboardButton{
 			tgbotapi.NewInlineKeyboardButtonData("📝 ثبت نام", fmt.Sprintf("register:%d", groupID)),
 		})
+	} else if ug.Status == models.MembershipPending {
+		// Waiting for approval - only the profile can be fixed meanwhile
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("⏳ در انتظار تایید ادمین - ویرایش مشخصات", fmt.Sprintf("edit:%d", groupID)),
+		})
 	} else {
 		// Registered - show edit and invoice buttons
 		rows = append(rows, []tgbotapi.InlineKeyboardButton{
@@ -123,55 +290,142 @@ func (b *Bot) MainMenuKeyboard(userID, groupID int64, isAdmin bool) tgbotapi.Inl
 		rows = append(rows, []tgbotapi.InlineKeyboardButton{
 			tgbotapi.NewInlineKeyboardButtonData("💰 صورتحساب", fmt.Sprintf("invoice:%d", groupID)),
 		})
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("🧾 پرداخت کردم", fmt.Sprintf("claim:%d", groupID)),
+		})
 	}
 
-	if isAdmin {
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("🔄 تغییر گروه", "groups"),
+	})
+
+	if permission.Can(models.CapManageRates) {
 		rows = append(rows, []tgbotapi.InlineKeyboardButton{
 			tgbotapi.NewInlineKeyboardButtonData("💵 تعیین نرخ", fmt.Sprintf("set_rates:%d", groupID)),
 		})
+	}
+	if permission.Can(models.CapManagePayments) {
 		rows = append(rows, []tgbotapi.InlineKeyboardButton{
 			tgbotapi.NewInlineKeyboardButtonData("✅ تسویه حساب کاربر", fmt.Sprintf("settle:%d", groupID)),
 		})
 	}
+	if permission.Can(models.CapManageSessions) {
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("📅 جلسات", fmt.Sprintf("sessions:%d", groupID)),
+		})
+	}
+	if permission.Can(models.CapManageMembers) {
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("📨 دعوت و درخواست‌های عضویت", fmt.Sprintf("members:%d", groupID)),
+		})
+	}
+	if permission.Can(models.CapManagePermissions) {
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("👑 سطح دسترسی اعضا", fmt.Sprintf("perms:%d", groupID)),
+		})
+	}
 
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
-func (b *Bot) RoleSelectionKeyboard(groupID int64, isAdmin bool) tgbotapi.InlineKeyboardMarkup {
+// GroupPickerKeyboard lists the groups a user can open in the private menu.
+func (b *Bot) GroupPickerKeyboard(groups []models.Group, currentGroupID int64) tgbotapi.InlineKeyboardMarkup {
 	var rows [][]tgbotapi.InlineKeyboardButton
 
+	for _, g := range groups {
+		title := g.Title
+		if g.ID == currentGroupID {
+			title = "📍 " + title
+		}
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(title, fmt.Sprintf("group:%d", g.ID)),
+		})
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// RoleSelectionKeyboard offers the pricing tiers of a group to a registering
+// member.
+func (b *Bot) RoleSelectionKeyboard(groupID int64, tiers []models.PricingTier) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	for _, t := range tiers {
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("👤 "+t.Name, fmt.Sprintf("role:%d:%d", t.ID, groupID)),
+		})
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// RateSettingKeyboard lists the pricing tiers of a group with their rates,
+// or their weights when the group splits venue costs, and switches between
+// the two pricing modes.
+func (b *Bot) RateSettingKeyboard(groupID int64, mode models.PricingMode, tiers []models.PricingTier) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	for _, t := range tiers {
+		label := fmt.Sprintf("%s - %.0f تومان", t.Name, t.RatePerSession)
+		if mode == models.PricingSplit {
+			label = fmt.Sprintf("%s - ضریب %s", t.Name, strconv.FormatFloat(t.Weight, 'f', -1, 64))
+		}
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("tier:%d", t.ID)),
+		})
+	}
+
+	toggle := tgbotapi.NewInlineKeyboardButtonData("🔁 تغییر به تقسیم هزینه زمین", fmt.Sprintf("pricing_mode:%d:%s", groupID, models.PricingSplit))
+	if mode == models.PricingSplit {
+		toggle = tgbotapi.NewInlineKeyboardButtonData("🔁 تغییر به نرخ ثابت", fmt.Sprintf("pricing_mode:%d:%s", groupID, models.PricingFixed))
+	}
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{toggle})
+
 	rows = append(rows, []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData("🎓 دانشجو", fmt.Sprintf("role:student:%d", groupID)),
-	})
-	rows = append(rows, []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData("👤 بزرگسال", fmt.Sprintf("role:adult:%d", groupID)),
+		tgbotapi.NewInlineKeyboardButtonData("➕ نقش جدید", fmt.Sprintf("tier_new:%d", groupID)),
 	})
 	rows = append(rows, []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData("👦 نیمه بزرگسال", fmt.Sprintf("role:half_adult:%d", groupID)),
+		tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", fmt.Sprintf("back:%d", groupID)),
 	})
 
-	if isAdmin {
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// AttendanceChecklistKeyboard lists the members of a group as toggle buttons.
+// The selection lives in the callback data of the buttons themselves, so the
+// checklist needs no server-side state and survives restarts. A sessionID of
+// zero takes attendance without a scheduled session. nonce identifies the
+// checklist so that it is recorded only once.
+func (b *Bot) AttendanceChecklistKeyboard(groupID, sessionID int64, nonce string, members []models.UserGroup, selected map[int64]bool) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	for _, ug := range members {
+		mark, flag := "⬜️", 0
+		if selected[ug.UserID] {
+			mark, flag = "✅", 1
+		}
 		rows = append(rows, []tgbotapi.InlineKeyboardButton{
-			tgbotapi.NewInlineKeyboardButtonData("👑 ادمین", fmt.Sprintf("role:admin:%d", groupID)),
+			tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("%s %s", mark, ug.Name),
+				fmt.Sprintf("att_toggle:%d:%d:%d", groupID, ug.UserID, flag),
+			),
 		})
 	}
 
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("✔️ ثبت (%d نفر)", len(selected)), fmt.Sprintf("att_confirm:%d:%d:%s", groupID, sessionID, nonce)),
+		tgbotapi.NewInlineKeyboardButtonData("❌ انصراف", fmt.Sprintf("att_cancel:%d", groupID)),
+	})
+
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
-func (b *Bot) RateSettingKeyboard(groupID int64) tgbotapi.InlineKeyboardMarkup {
+func (b *Bot) RSVPKeyboard(sessionID int64) tgbotapi.InlineKeyboardMarkup {
 	return tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("🎓 دانشجو", fmt.Sprintf("setrate:student:%d", groupID)),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("👤 بزرگسال", fmt.Sprintf("setrate:adult:%d", groupID)),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("👦 نیمه بزرگسال", fmt.Sprintf("setrate:half_adult:%d", groupID)),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", fmt.Sprintf("back:%d", groupID)),
+			tgbotapi.NewInlineKeyboardButtonData("✅ می‌آیم", fmt.Sprintf("rsvp:%d:in", sessionID)),
+			tgbotapi.NewInlineKeyboardButtonData("❔ شاید", fmt.Sprintf("rsvp:%d:maybe", sessionID)),
+			tgbotapi.NewInlineKeyboardButtonData("❌ نمی‌آیم", fmt.Sprintf("rsvp:%d:out", sessionID)),
 		),
 	)
 }
@@ -0,0 +1,64 @@
+package bot
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// chatMemberTTL is how long a chat membership lookup is reused, so that
+	// browsing the menu doesn't ask the platform about every group each time.
+	chatMemberTTL = time.Minute
+	// chatMemberPruneSize is how many cached lookups trigger dropping the
+	// expired ones.
+	chatMemberPruneSize = 10000
+)
+
+type chatMemberKey struct {
+	chatID, userID int64
+}
+
+type chatMemberEntry struct {
+	member    bool
+	checkedAt time.Time
+}
+
+// chatMemberCache remembers recent chat membership lookups.
+type chatMemberCache struct {
+	mu      sync.Mutex
+	entries map[chatMemberKey]chatMemberEntry
+}
+
+func (c *chatMemberCache) get(chatID, userID int64) (member, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[chatMemberKey{chatID, userID}]
+	if !ok || time.Since(entry.checkedAt) > chatMemberTTL {
+		return false, false
+	}
+	return entry.member, true
+}
+
+func (c *chatMemberCache) set(chatID, userID int64, member bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[chatMemberKey]chatMemberEntry)
+	}
+	if len(c.entries) >= chatMemberPruneSize {
+		for key, entry := range c.entries {
+			if time.Since(entry.checkedAt) > chatMemberTTL {
+				delete(c.entries, key)
+			}
+		}
+	}
+	c.entries[chatMemberKey{chatID, userID}] = chatMemberEntry{member: member, checkedAt: time.Now()}
+}
+
+func (c *chatMemberCache) forget(chatID, userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, chatMemberKey{chatID, userID})
+}
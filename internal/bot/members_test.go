@@ -0,0 +1,33 @@
+package bot
+
+import (
+	"testing"
+
+	"futsal-bot/internal/messenger/messengertest"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestWasChatMemberReusesRecentLookups(t *testing.T) {
+	fake := messengertest.New(tgbotapi.User{ID: 1, IsBot: true, UserName: "bot"})
+	b := New(fake, nil, Config{})
+
+	fake.SetChatMember(-100, 7, "member")
+	if member, err := b.WasChatMember(-100, 7); err != nil || !member {
+		t.Fatalf("WasChatMember = %v, %v, want member", member, err)
+	}
+
+	fake.SetChatMember(-100, 7, "left")
+	if member, _ := b.WasChatMember(-100, 7); !member {
+		t.Error("WasChatMember asked the platform again instead of reusing the lookup")
+	}
+	if member, _ := b.IsChatMember(-100, 7); member {
+		t.Error("IsChatMember answered from the cache")
+	}
+
+	fake.SetChatMember(-100, 7, "member")
+	b.ForgetChatMember(-100, 7)
+	if member, _ := b.WasChatMember(-100, 7); !member {
+		t.Error("WasChatMember kept a forgotten lookup")
+	}
+}
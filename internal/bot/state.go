@@ -0,0 +1,105 @@
+package bot
+
+import (
+	"sync"
+	"time"
+
+	"futsal-bot/internal/models"
+)
+
+// StateStore keeps the in-progress flows of users between messages, keyed by
+// user, chat and flow name. Getters return nil, without an error, when there
+// is no matching state. *database.DB implements it on top of PostgreSQL so
+// flows survive restarts.
+type StateStore interface {
+	GetUserState(telegramID, chatID int64, flow string) (*models.UserState, error)
+	GetLatestUserState(telegramID, chatID int64) (*models.UserState, error)
+	SaveUserState(state *models.UserState) error
+	DeleteUserState(telegramID, chatID int64, flow string) error
+	DeleteUserStates(telegramID, chatID int64) (int64, error)
+	DeleteStaleUserStates(before time.Time) (int64, error)
+}
+
+type stateKey struct {
+	userID int64
+	chatID int64
+	flow   string
+}
+
+// MemoryStateStore is a StateStore that lives in process memory. States are
+// lost on restart.
+type MemoryStateStore struct {
+	mu     sync.RWMutex
+	states map[stateKey]*models.UserState
+}
+
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{states: make(map[stateKey]*models.UserState)}
+}
+
+func (s *MemoryStateStore) GetUserState(telegramID, chatID int64, flow string) (*models.UserState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.states[stateKey{telegramID, chatID, flow}], nil
+}
+
+func (s *MemoryStateStore) GetLatestUserState(telegramID, chatID int64) (*models.UserState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var latest *models.UserState
+	for key, state := range s.states {
+		if key.userID != telegramID || key.chatID != chatID {
+			continue
+		}
+		if latest == nil || state.LastUpdated.After(latest.LastUpdated) {
+			latest = state
+		}
+	}
+	return latest, nil
+}
+
+func (s *MemoryStateStore) SaveUserState(state *models.UserState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.states[stateKey{state.UserID, state.ChatID, state.Flow}] = state
+	return nil
+}
+
+func (s *MemoryStateStore) DeleteUserState(telegramID, chatID int64, flow string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.states, stateKey{telegramID, chatID, flow})
+	return nil
+}
+
+func (s *MemoryStateStore) DeleteUserStates(telegramID, chatID int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed int64
+	for key := range s.states {
+		if key.userID == telegramID && key.chatID == chatID {
+			delete(s.states, key)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (s *MemoryStateStore) DeleteStaleUserStates(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed int64
+	for key, state := range s.states {
+		if state.LastUpdated.Before(before) {
+			delete(s.states, key)
+			removed++
+		}
+	}
+	return removed, nil
+}
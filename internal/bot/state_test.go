@@ -0,0 +1,47 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"futsal-bot/internal/models"
+)
+
+func TestMemoryStateStoreScopesStates(t *testing.T) {
+	s := NewMemoryStateStore()
+	now := time.Now()
+
+	save := func(userID, chatID int64, flow, state string, at time.Time) {
+		t.Helper()
+		err := s.SaveUserState(&models.UserState{UserID: userID, ChatID: chatID, Flow: flow, State: state, LastUpdated: at})
+		if err != nil {
+			t.Fatalf("SaveUserState: %v", err)
+		}
+	}
+
+	// One user, the same flow in two chats, and another flow
+	save(7, 7, "rate", "awaiting_rate", now.Add(-time.Minute))
+	save(7, -100, "rate", "awaiting_rate_date", now)
+	save(7, 7, "payment", "awaiting_amount", now)
+
+	if st, _ := s.GetUserState(7, 7, "rate"); st == nil || st.State != "awaiting_rate" {
+		t.Errorf("private rate flow = %+v", st)
+	}
+	if st, _ := s.GetUserState(8, 7, "rate"); st != nil {
+		t.Errorf("another user sees %+v", st)
+	}
+	if st, _ := s.GetLatestUserState(7, 7); st == nil || st.Flow != "payment" {
+		t.Errorf("latest private flow = %+v, want payment", st)
+	}
+
+	if n, _ := s.DeleteUserStates(7, 7); n != 2 {
+		t.Errorf("DeleteUserStates removed %d, want the 2 private flows", n)
+	}
+	if st, _ := s.GetUserState(7, -100, "rate"); st == nil {
+		t.Error("clearing the private chat dropped the group chat flow")
+	}
+
+	if n, _ := s.DeleteStaleUserStates(now.Add(time.Second)); n != 1 {
+		t.Errorf("DeleteStaleUserStates removed %d, want 1", n)
+	}
+}
@@ -24,12 +24,15 @@ type Config struct {
 }
 
 func New(cfg Config) (*DB, error) {
-	connStr := fmt.Sprintf(
+	return Open(fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
 		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode,
-	)
+	))
+}
 
-	db, err := sql.Open("postgres", connStr)
+// Open connects to the database at a lib/pq connection string or URL.
+func Open(dsn string) (*DB, error) {
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -44,10 +47,6 @@ func New(cfg Config) (*DB, error) {
 }
 
 func (db *DB) RunMigrations() error {
-	if err := goose.SetDialect("postgres"); err != nil {
-		return fmt.Errorf("failed to set goose dialect: %w", err)
-	}
-
 	// Try different possible migration directory paths
 	migrationsDir := "migrations"
 	if _, err := os.Stat(migrationsDir); os.IsNotExist(err) {
@@ -59,7 +58,16 @@ func (db *DB) RunMigrations() error {
 
 	zap.L().Info("Using migrations directory", zap.String("dir", migrationsDir))
 
-	if err := goose.Up(db.DB, migrationsDir); err != nil {
+	return db.Migrate(migrationsDir)
+}
+
+// Migrate applies the migrations in dir that haven't run yet.
+func (db *DB) Migrate(dir string) error {
+	if err := goose.SetDialect("postgres"); err != nil {
+		return fmt.Errorf("failed to set goose dialect: %w", err)
+	}
+
+	if err := goose.Up(db.DB, dir); err != nil {
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
 
@@ -2,11 +2,22 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"futsal-bot/internal/models"
+
+	"github.com/lib/pq"
 )
 
+var ErrAlreadyReverted = errors.New("attendance record already reverted")
+
+// ErrAttendanceRecorded is returned when attendance is recorded again for a
+// session or checklist that already has a standing record.
+var ErrAttendanceRecorded = errors.New("attendance already recorded")
+
 // User operations
 func (db *DB) GetOrCreateUser(telegramID int64, username, firstName, lastName string, isBot bool) (*models.User, error) {
 	var user models.User
@@ -19,10 +30,10 @@ func (db *DB) GetOrCreateUser(telegramID int64, username, firstName, lastName st
 		    first_name = EXCLUDED.first_name,
 		    last_name = EXCLUDED.last_name,
 		    updated_at = CURRENT_TIMESTAMP
-		RETURNING id, telegram_id, username, first_name, last_name, is_bot, created_at, updated_at
+		RETURNING id, telegram_id, username, first_name, last_name, is_bot, current_group_id, created_at, updated_at
 	`, telegramID, username, firstName, lastName, isBot).Scan(
 		&user.ID, &user.TelegramID, &user.Username, &user.FirstName,
-		&user.LastName, &user.IsBot, &user.CreatedAt, &user.UpdatedAt,
+		&user.LastName, &user.IsBot, &user.CurrentGroupID, &user.CreatedAt, &user.UpdatedAt,
 	)
 
 	if err != nil {
@@ -32,16 +43,35 @@ func (db *DB) GetOrCreateUser(telegramID int64, username, firstName, lastName st
 	return &user, nil
 }
 
+func (db *DB) GetUser(userID int64) (*models.User, error) {
+	var user models.User
+
+	err := db.QueryRow(`
+		SELECT id, telegram_id, username, first_name, last_name, is_bot, current_group_id, created_at, updated_at
+		FROM users
+		WHERE id = $1
+	`, userID).Scan(
+		&user.ID, &user.TelegramID, &user.Username, &user.FirstName,
+		&user.LastName, &user.IsBot, &user.CurrentGroupID, &user.CreatedAt, &user.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
 func (db *DB) GetUserByTelegramID(telegramID int64) (*models.User, error) {
 	var user models.User
 
 	err := db.QueryRow(`
-		SELECT id, telegram_id, username, first_name, last_name, is_bot, created_at, updated_at
+		SELECT id, telegram_id, username, first_name, last_name, is_bot, current_group_id, created_at, updated_at
 		FROM users
 		WHERE telegram_id = $1
 	`, telegramID).Scan(
 		&user.ID, &user.TelegramID, &user.Username, &user.FirstName,
-		&user.LastName, &user.IsBot, &user.CreatedAt, &user.UpdatedAt,
+		&user.LastName, &user.IsBot, &user.CurrentGroupID, &user.CreatedAt, &user.UpdatedAt,
 	)
 
 	if err != nil {
@@ -55,12 +85,12 @@ func (db *DB) GetUserByUserName(userName string) (*models.User, error) {
 	var user models.User
 
 	err := db.QueryRow(`
-		SELECT id, telegram_id, username, first_name, last_name, is_bot, created_at, updated_at
+		SELECT id, telegram_id, username, first_name, last_name, is_bot, current_group_id, created_at, updated_at
 		FROM users
 		WHERE username = $1
 	`, userName).Scan(
 		&user.ID, &user.TelegramID, &user.Username, &user.FirstName,
-		&user.LastName, &user.IsBot, &user.CreatedAt, &user.UpdatedAt,
+		&user.LastName, &user.IsBot, &user.CurrentGroupID, &user.CreatedAt, &user.UpdatedAt,
 	)
 
 	if err != nil {
@@ -70,20 +100,41 @@ func (db *DB) GetUserByUserName(userName string) (*models.User, error) {
 	return &user, nil
 }
 
+// SetCurrentGroup remembers the group a user last picked in the private menu.
+func (db *DB) SetCurrentGroup(userID, groupID int64) error {
+	_, err := db.Exec(`
+		UPDATE users SET current_group_id = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2
+	`, groupID, userID)
+
+	if err != nil {
+		return fmt.Errorf("failed to set current group: %w", err)
+	}
+
+	return nil
+}
+
 // Group operations
+
+// GetOrCreateGroup registers a group chat, or refreshes its title and type.
+// A group without pricing tiers gets the default ones.
 func (db *DB) GetOrCreateGroup(telegramChatID int64, title, chatType string) (*models.Group, error) {
-	var group models.Group
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-	err := db.QueryRow(`
+	var group models.Group
+	err = tx.QueryRow(`
 		INSERT INTO groups (telegram_chat_id, title, type)
 		VALUES ($1, $2, $3)
 		ON CONFLICT (telegram_chat_id) DO UPDATE
 		SET title = EXCLUDED.title,
 		    type = EXCLUDED.type,
 		    updated_at = CURRENT_TIMESTAMP
-		RETURNING id, telegram_chat_id, title, type, created_at, updated_at
+		RETURNING id, telegram_chat_id, title, type, pricing_mode, created_at, updated_at
 	`, telegramChatID, title, chatType).Scan(
-		&group.ID, &group.TelegramChatID, &group.Title, &group.Type,
+		&group.ID, &group.TelegramChatID, &group.Title, &group.Type, &group.PricingMode,
 		&group.CreatedAt, &group.UpdatedAt,
 	)
 
@@ -91,6 +142,45 @@ func (db *DB) GetOrCreateGroup(telegramChatID int64, title, chatType string) (*m
 		return nil, fmt.Errorf("failed to get or create group: %w", err)
 	}
 
+	names := make([]string, len(models.DefaultPricingTiers))
+	weights := make([]float64, len(models.DefaultPricingTiers))
+	for i, t := range models.DefaultPricingTiers {
+		names[i], weights[i] = t.Name, t.Weight
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO pricing_tiers (group_id, name, weight, position)
+		SELECT $1, t.name, t.weight, t.position
+		FROM UNNEST($2::text[], $3::numeric[]) WITH ORDINALITY AS t(name, weight, position)
+		WHERE NOT EXISTS (SELECT 1 FROM pricing_tiers WHERE group_id = $1)
+	`, group.ID, pq.Array(names), pq.Array(weights))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create default pricing tiers: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit group: %w", err)
+	}
+
+	return &group, nil
+}
+
+func (db *DB) GetGroup(groupID int64) (*models.Group, error) {
+	var group models.Group
+
+	err := db.QueryRow(`
+		SELECT id, telegram_chat_id, title, type, pricing_mode, created_at, updated_at
+		FROM groups
+		WHERE id = $1
+	`, groupID).Scan(
+		&group.ID, &group.TelegramChatID, &group.Title, &group.Type, &group.PricingMode,
+		&group.CreatedAt, &group.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
 	return &group, nil
 }
 
@@ -98,11 +188,11 @@ func (db *DB) GetGroupByTelegramChatID(telegramChatID int64) (*models.Group, err
 	var group models.Group
 
 	err := db.QueryRow(`
-		SELECT id, telegram_chat_id, title, type, created_at, updated_at
+		SELECT id, telegram_chat_id, title, type, pricing_mode, created_at, updated_at
 		FROM groups
 		WHERE telegram_chat_id = $1
 	`, telegramChatID).Scan(
-		&group.ID, &group.TelegramChatID, &group.Title, &group.Type,
+		&group.ID, &group.TelegramChatID, &group.Title, &group.Type, &group.PricingMode,
 		&group.CreatedAt, &group.UpdatedAt,
 	)
 
@@ -113,30 +203,122 @@ func (db *DB) GetGroupByTelegramChatID(telegramChatID int64) (*models.Group, err
 	return &group, nil
 }
 
+// SetPricingMode switches how a group charges for sessions. Charges already
+// posted are not touched.
+func (db *DB) SetPricingMode(groupID int64, mode models.PricingMode, actorID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var old models.PricingMode
+	err = tx.QueryRow(`
+		SELECT pricing_mode FROM groups WHERE id = $1 FOR UPDATE
+	`, groupID).Scan(&old)
+	if err != nil {
+		return fmt.Errorf("failed to get pricing mode: %w", err)
+	}
+	if old == mode {
+		return nil
+	}
+
+	_, err = tx.Exec(`
+		UPDATE groups
+		SET pricing_mode = $1,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`, mode, groupID)
+	if err != nil {
+		return fmt.Errorf("failed to set pricing mode: %w", err)
+	}
+
+	err = recordAuditTx(tx, auditRecord{
+		GroupID: groupID, ActorID: actorID, Action: models.AuditPricingMode,
+		Before: fields{"pricing_mode": old}, After: fields{"pricing_mode": mode},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit pricing mode: %w", err)
+	}
+
+	return nil
+}
+
 // UserGroup operations
-func (db *DB) CreateOrUpdateUserGroup(userID, groupID int64, role models.UserRole, name string) error {
-	_, err := db.Exec(`
-		INSERT INTO user_groups (user_id, group_id, role, name)
-		VALUES ($1, $2, $3, $4)
+// CreateOrUpdateUserGroup registers a user in a group as a plain member with
+// the given status, or updates the name and pricing tier of an existing
+// membership. Permissions are never touched here. The status of an existing
+// membership is left alone, since it only changes through review, unless it
+// was archived: registering again counts as a new registration.
+func (db *DB) CreateOrUpdateUserGroup(userID, groupID int64, tierID *int64, name string, status models.MembershipStatus) (*models.UserGroup, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var old models.UserGroup
+	err = tx.QueryRow(`
+		SELECT tier_id, name, status FROM user_groups
+		WHERE user_id = $1 AND group_id = $2
+		FOR UPDATE
+	`, userID, groupID).Scan(&old.TierID, &old.Name, &old.Status)
+	existed := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get user group: %w", err)
+	}
+
+	var ug models.UserGroup
+	err = tx.QueryRow(`
+		INSERT INTO user_groups (user_id, group_id, tier_id, name, status)
+		VALUES ($1, $2, $3, $4, $5)
 		ON CONFLICT (user_id, group_id) DO UPDATE
-		SET role = EXCLUDED.role,
+		SET tier_id = EXCLUDED.tier_id,
 		    name = EXCLUDED.name,
+		    status = CASE WHEN user_groups.status = 'archived' THEN EXCLUDED.status ELSE user_groups.status END,
 		    updated_at = CURRENT_TIMESTAMP
-	`, userID, groupID, role, name)
+		RETURNING id, user_id, group_id, permission, tier_id, name, status, created_at, updated_at
+	`, userID, groupID, tierID, name, status).Scan(
+		&ug.ID, &ug.UserID, &ug.GroupID, &ug.Permission, &ug.TierID, &ug.Name,
+		&ug.Status, &ug.CreatedAt, &ug.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save user group: %w", err)
+	}
 
-	return err
+	audit := auditRecord{
+		GroupID: groupID, ActorID: userID, Action: models.AuditMemberRegister, UserID: userID,
+		After: fields{"name": ug.Name, "tier_id": ug.TierID, "status": ug.Status},
+	}
+	if existed && old.Status != models.MembershipArchived {
+		audit.Action = models.AuditMemberUpdate
+		audit.Before = fields{"name": old.Name, "tier_id": old.TierID, "status": old.Status}
+	}
+	if err := recordAuditTx(tx, audit); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit user group: %w", err)
+	}
+
+	return &ug, nil
 }
 
 func (db *DB) GetUserGroup(userID, groupID int64) (*models.UserGroup, error) {
 	var ug models.UserGroup
 
 	err := db.QueryRow(`
-		SELECT id, user_id, group_id, role, name, sessions_owed, created_at, updated_at
+		SELECT id, user_id, group_id, permission, tier_id, name, status, created_at, updated_at
 		FROM user_groups
 		WHERE user_id = $1 AND group_id = $2
 	`, userID, groupID).Scan(
-		&ug.ID, &ug.UserID, &ug.GroupID, &ug.Role, &ug.Name,
-		&ug.SessionsOwed, &ug.CreatedAt, &ug.UpdatedAt,
+		&ug.ID, &ug.UserID, &ug.GroupID, &ug.Permission, &ug.TierID, &ug.Name,
+		&ug.Status, &ug.CreatedAt, &ug.UpdatedAt,
 	)
 
 	if err != nil {
@@ -148,9 +330,9 @@ func (db *DB) GetUserGroup(userID, groupID int64) (*models.UserGroup, error) {
 
 func (db *DB) GetUserGroupsByGroupID(groupID int64) ([]models.UserGroup, error) {
 	rows, err := db.Query(`
-		SELECT id, user_id, group_id, role, name, sessions_owed, created_at, updated_at
+		SELECT id, user_id, group_id, permission, tier_id, name, status, created_at, updated_at
 		FROM user_groups
-		WHERE group_id = $1
+		WHERE group_id = $1 AND status = 'active'
 		ORDER BY name
 	`, groupID)
 
@@ -163,8 +345,8 @@ func (db *DB) GetUserGroupsByGroupID(groupID int64) ([]models.UserGroup, error)
 	for rows.Next() {
 		var ug models.UserGroup
 		err := rows.Scan(
-			&ug.ID, &ug.UserID, &ug.GroupID, &ug.Role, &ug.Name,
-			&ug.SessionsOwed, &ug.CreatedAt, &ug.UpdatedAt,
+			&ug.ID, &ug.UserID, &ug.GroupID, &ug.Permission, &ug.TierID, &ug.Name,
+			&ug.Status, &ug.CreatedAt, &ug.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -175,18 +357,259 @@ func (db *DB) GetUserGroupsByGroupID(groupID int64) ([]models.UserGroup, error)
 	return userGroups, nil
 }
 
+// GetGroupMemberships returns every membership of a group whatever its
+// status, ordered by name. Use GetUserGroupsByGroupID for active members.
+func (db *DB) GetGroupMemberships(groupID int64) ([]models.UserGroup, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, group_id, permission, tier_id, name, status, created_at, updated_at
+		FROM user_groups
+		WHERE group_id = $1
+		ORDER BY name
+	`, groupID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userGroups []models.UserGroup
+	for rows.Next() {
+		var ug models.UserGroup
+		err := rows.Scan(
+			&ug.ID, &ug.UserID, &ug.GroupID, &ug.Permission, &ug.TierID, &ug.Name,
+			&ug.Status, &ug.CreatedAt, &ug.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		userGroups = append(userGroups, ug)
+	}
+
+	return userGroups, rows.Err()
+}
+
+// ArchiveUserGroup archives the membership of a user who left the group
+// chat. Their ledger history stays but their permission drops to member, so
+// coming back doesn't restore any powers. A registration still waiting for
+// approval is dropped instead, unless it is a returning member with ledger
+// history. It reports whether anything changed.
+func (db *DB) ArchiveUserGroup(userID, groupID, actorID int64) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var status models.MembershipStatus
+	var permission models.Permission
+	var history bool
+	err = tx.QueryRow(`
+		SELECT status, permission, `+memberHasHistory+` FROM user_groups
+		WHERE user_id = $1 AND group_id = $2 AND status IN ('active', 'pending')
+		FOR UPDATE
+	`, userID, groupID).Scan(&status, &permission, &history)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get user group: %w", err)
+	}
+
+	audit := auditRecord{
+		GroupID: groupID, ActorID: actorID, Action: models.AuditMemberArchive, UserID: userID,
+		Before: fields{"status": status, "permission": permission},
+	}
+	if status == models.MembershipActive || history {
+		_, err = tx.Exec(`
+			UPDATE user_groups
+			SET status = 'archived',
+			    permission = 'member',
+			    updated_at = CURRENT_TIMESTAMP
+			WHERE user_id = $1 AND group_id = $2
+		`, userID, groupID)
+		if err != nil {
+			return false, fmt.Errorf("failed to archive user group: %w", err)
+		}
+		audit.After = fields{"status": models.MembershipArchived, "permission": models.PermissionMember}
+	} else {
+		_, err = tx.Exec(`
+			DELETE FROM user_groups
+			WHERE user_id = $1 AND group_id = $2
+		`, userID, groupID)
+		if err != nil {
+			return false, fmt.Errorf("failed to drop pending user group: %w", err)
+		}
+	}
+
+	if err := recordAuditTx(tx, audit); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit user group: %w", err)
+	}
+
+	return true, nil
+}
+
+// ReopenUserGroup puts the archived membership of a user who came back to
+// the group chat up for approval again. It returns the membership, or nil
+// when there was no archived one.
+func (db *DB) ReopenUserGroup(userID, groupID, actorID int64) (*models.UserGroup, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var ug models.UserGroup
+	err = tx.QueryRow(`
+		UPDATE user_groups SET status = 'pending', updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND group_id = $2 AND status = 'archived'
+		RETURNING id, user_id, group_id, permission, tier_id, name, status, created_at, updated_at
+	`, userID, groupID).Scan(
+		&ug.ID, &ug.UserID, &ug.GroupID, &ug.Permission, &ug.TierID, &ug.Name,
+		&ug.Status, &ug.CreatedAt, &ug.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to reopen user group: %w", err)
+	}
+
+	err = recordAuditTx(tx, auditRecord{
+		GroupID: groupID, ActorID: actorID, Action: models.AuditMemberRestore, UserID: userID,
+		Before: fields{"status": models.MembershipArchived}, After: fields{"status": models.MembershipPending},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit user group: %w", err)
+	}
+
+	return &ug, nil
+}
+
+// GetPendingUserGroups returns the registrations of a group waiting for
+// admin approval, oldest first.
+func (db *DB) GetPendingUserGroups(groupID int64) ([]models.UserGroup, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, group_id, permission, tier_id, name, status, created_at, updated_at
+		FROM user_groups
+		WHERE group_id = $1 AND status = 'pending'
+		ORDER BY created_at
+	`, groupID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userGroups []models.UserGroup
+	for rows.Next() {
+		var ug models.UserGroup
+		err := rows.Scan(
+			&ug.ID, &ug.UserID, &ug.GroupID, &ug.Permission, &ug.TierID, &ug.Name,
+			&ug.Status, &ug.CreatedAt, &ug.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		userGroups = append(userGroups, ug)
+	}
+
+	return userGroups, rows.Err()
+}
+
+// memberHasHistory reports whether user $1 has ever been charged or paid in
+// group $2. Such a membership is archived rather than deleted so the debt
+// stays visible.
+const memberHasHistory = `EXISTS (
+	SELECT 1 FROM ledger_postings lp
+	JOIN ledger_transactions lt ON lt.id = lp.transaction_id
+	WHERE lt.group_id = $2 AND lp.user_id = $1
+)`
+
+// ReviewUserGroup approves a pending registration or, when rejected, removes
+// it so the user may register again. A rejected returning member with ledger
+// history goes back to archived instead. It returns sql.ErrNoRows when the
+// registration is no longer pending.
+func (db *DB) ReviewUserGroup(userID, groupID int64, approve bool, reviewerID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM user_groups
+		WHERE user_id = $1 AND group_id = $2 AND status = 'pending'
+	`
+	audit := auditRecord{
+		GroupID: groupID, ActorID: reviewerID, Action: models.AuditMemberReject, UserID: userID,
+		Before: fields{"status": models.MembershipPending},
+	}
+	var history bool
+	err = tx.QueryRow(`SELECT `+memberHasHistory, userID, groupID).Scan(&history)
+	if err != nil {
+		return fmt.Errorf("failed to check ledger history: %w", err)
+	}
+	if history {
+		query = `
+			UPDATE user_groups SET status = 'archived', updated_at = CURRENT_TIMESTAMP
+			WHERE user_id = $1 AND group_id = $2 AND status = 'pending'
+		`
+		audit.After = fields{"status": models.MembershipArchived}
+	}
+	if approve {
+		query = `
+			UPDATE user_groups SET status = 'active', updated_at = CURRENT_TIMESTAMP
+			WHERE user_id = $1 AND group_id = $2 AND status = 'pending'
+		`
+		audit.Action = models.AuditMemberApprove
+		audit.After = fields{"status": models.MembershipActive}
+	}
+
+	result, err := tx.Exec(query, userID, groupID)
+	if err != nil {
+		return fmt.Errorf("failed to review user group: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := recordAuditTx(tx, audit); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit review: %w", err)
+	}
+
+	return nil
+}
+
 func (db *DB) IsUserMemberOfGroup(userID, groupID int64) (bool, error) {
 	var exists bool
 	err := db.QueryRow(`
-		SELECT EXISTS(SELECT 1 FROM user_groups WHERE user_id = $1 AND group_id = $2)
+		SELECT EXISTS(SELECT 1 FROM user_groups WHERE user_id = $1 AND group_id = $2 AND status = 'active')
 	`, userID, groupID).Scan(&exists)
 
 	return exists, err
 }
 
+// GetUserGroups returns the groups a user is an active member of.
 func (db *DB) GetUserGroups(userID int64) ([]int64, error) {
 	rows, err := db.Query(`
-		SELECT group_id FROM user_groups WHERE user_id = $1
+		SELECT group_id FROM user_groups WHERE user_id = $1 AND status = 'active'
 	`, userID)
 
 	if err != nil {
@@ -206,97 +629,163 @@ func (db *DB) GetUserGroups(userID int64) ([]int64, error) {
 	return groupIDs, nil
 }
 
-func (db *DB) IsUserAdminInGroup(userID, groupID int64) (bool, error) {
-	var role string
+// GetPermission returns a user's permission in a group, or PermissionNone
+// when they have no active membership there.
+func (db *DB) GetPermission(userID, groupID int64) (models.Permission, error) {
+	var permission models.Permission
 	err := db.QueryRow(`
-		SELECT role FROM user_groups WHERE user_id = $1 AND group_id = $2
-	`, userID, groupID).Scan(&role)
+		SELECT permission FROM user_groups WHERE user_id = $1 AND group_id = $2 AND status = 'active'
+	`, userID, groupID).Scan(&permission)
 
 	if err == sql.ErrNoRows {
-		return false, nil
+		return models.PermissionNone, nil
 	}
 	if err != nil {
-		return false, err
+		return models.PermissionNone, err
 	}
 
-	return role == string(models.RoleAdmin), nil
-}
-
-// Rate operations
-func (db *DB) SetRate(groupID int64, role models.UserRole, rate float64) error {
-	_, err := db.Exec(`
-		INSERT INTO rates (group_id, role, rate_per_session)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (group_id, role) DO UPDATE
-		SET rate_per_session = EXCLUDED.rate_per_session,
-		    updated_at = CURRENT_TIMESTAMP
-	`, groupID, role, rate)
-
-	return err
+	return permission, nil
 }
 
-func (db *DB) GetRate(groupID int64, role models.UserRole) (float64, error) {
-	var rate float64
-	err := db.QueryRow(`
-		SELECT rate_per_session FROM rates WHERE group_id = $1 AND role = $2
-	`, groupID, role).Scan(&rate)
-
-	if err == sql.ErrNoRows {
-		return 0, nil
+// GetGroupTelegramIDsWithPermissions returns the Telegram IDs of the active
+// members of a group holding any of the given permissions.
+func (db *DB) GetGroupTelegramIDsWithPermissions(groupID int64, permissions []models.Permission) ([]int64, error) {
+	names := make([]string, len(permissions))
+	for i, p := range permissions {
+		names[i] = string(p)
 	}
 
-	return rate, err
-}
-
-func (db *DB) GetAllRates(groupID int64) (map[models.UserRole]float64, error) {
 	rows, err := db.Query(`
-		SELECT role, rate_per_session FROM rates WHERE group_id = $1
-	`, groupID)
+		SELECT u.telegram_id
+		FROM user_groups ug
+		JOIN users u ON u.id = ug.user_id
+		WHERE ug.group_id = $1 AND ug.permission::text = ANY($2) AND ug.status = 'active'
+	`, groupID, pq.Array(names))
 
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	rates := make(map[models.UserRole]float64)
+	var ids []int64
 	for rows.Next() {
-		var role models.UserRole
-		var rate float64
-		if err := rows.Scan(&role, &rate); err != nil {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
 			return nil, err
 		}
-		rates[role] = rate
+		ids = append(ids, id)
 	}
 
-	return rates, nil
+	return ids, rows.Err()
 }
 
-// Session operations
-func (db *DB) AddSessionsToUser(userID, groupID int64, sessions int) error {
-	_, err := db.Exec(`
-		UPDATE user_groups
-		SET sessions_owed = sessions_owed + $1,
+// AddGroupOwner makes a user the owner of a group, registering them as an
+// active member with the group's default tier if they aren't one yet. An
+// existing membership keeps its status and tier. The change is recorded with
+// the user as its author.
+func (db *DB) AddGroupOwner(userID, groupID int64, name string) (*models.UserGroup, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var old sql.NullString
+	err = tx.QueryRow(`
+		SELECT permission FROM user_groups WHERE user_id = $1 AND group_id = $2 FOR UPDATE
+	`, userID, groupID).Scan(&old)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get permission: %w", err)
+	}
+
+	var ug models.UserGroup
+	err = tx.QueryRow(`
+		INSERT INTO user_groups (user_id, group_id, permission, tier_id, name, status)
+		VALUES ($1, $2, 'owner', (`+defaultTierID+`), $3, 'active')
+		ON CONFLICT (user_id, group_id) DO UPDATE
+		SET permission = 'owner',
+		    tier_id = COALESCE(user_groups.tier_id, EXCLUDED.tier_id),
 		    updated_at = CURRENT_TIMESTAMP
-		WHERE user_id = $2 AND group_id = $3
-	`, sessions, userID, groupID)
+		RETURNING id, user_id, group_id, permission, tier_id, name, status, created_at, updated_at
+	`, userID, groupID, name).Scan(
+		&ug.ID, &ug.UserID, &ug.GroupID, &ug.Permission, &ug.TierID, &ug.Name,
+		&ug.Status, &ug.CreatedAt, &ug.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add group owner: %w", err)
+	}
 
-	return err
+	if err := recordPermissionChangeTx(tx, groupID, userID, old, models.PermissionOwner, userID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit group owner: %w", err)
+	}
+
+	return &ug, nil
 }
 
-func (db *DB) SettleSessions(userID, groupID int64, sessions int) error {
-	_, err := db.Exec(`
+// SetPermission changes the permission of an active member and records who
+// changed it. It returns the previous permission, or sql.ErrNoRows when the
+// user is not an active member of the group.
+func (db *DB) SetPermission(userID, groupID int64, permission models.Permission, changedBy int64) (models.Permission, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return models.PermissionNone, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var old sql.NullString
+	err = tx.QueryRow(`
+		SELECT permission FROM user_groups
+		WHERE user_id = $1 AND group_id = $2 AND status = 'active'
+		FOR UPDATE
+	`, userID, groupID).Scan(&old)
+	if err != nil {
+		return models.PermissionNone, err
+	}
+
+	if models.Permission(old.String) == permission {
+		return permission, nil
+	}
+
+	_, err = tx.Exec(`
 		UPDATE user_groups
-		SET sessions_owed = (sessions_owed - $1),
+		SET permission = $1,
 		    updated_at = CURRENT_TIMESTAMP
 		WHERE user_id = $2 AND group_id = $3
-	`, sessions, userID, groupID)
+	`, permission, userID, groupID)
+	if err != nil {
+		return models.PermissionNone, fmt.Errorf("failed to set permission: %w", err)
+	}
+
+	if err := recordPermissionChangeTx(tx, groupID, userID, old, permission, changedBy); err != nil {
+		return models.PermissionNone, err
+	}
 
-	return err
+	if err := tx.Commit(); err != nil {
+		return models.PermissionNone, fmt.Errorf("failed to commit permission: %w", err)
+	}
+
+	return models.Permission(old.String), nil
+}
+
+func recordPermissionChangeTx(tx *sql.Tx, groupID, userID int64, old sql.NullString, permission models.Permission, changedBy int64) error {
+	var before fields
+	if old.Valid {
+		before = fields{"permission": old.String}
+	}
+
+	return recordAuditTx(tx, auditRecord{
+		GroupID: groupID, ActorID: changedBy, Action: models.AuditPermissionChange, UserID: userID,
+		Before: before, After: fields{"permission": permission},
+	})
 }
 
 func (db *DB) GetAllGroups() ([]models.Group, error) {
 	rows, err := db.Query(`
-		SELECT id, telegram_chat_id, title, type, created_at, updated_at
+		SELECT id, telegram_chat_id, title, type, pricing_mode, created_at, updated_at
 		FROM groups
 		ORDER BY created_at DESC
 	`)
@@ -310,7 +799,7 @@ func (db *DB) GetAllGroups() ([]models.Group, error) {
 	for rows.Next() {
 		var g models.Group
 		err := rows.Scan(
-			&g.ID, &g.TelegramChatID, &g.Title, &g.Type,
+			&g.ID, &g.TelegramChatID, &g.Title, &g.Type, &g.PricingMode,
 			&g.CreatedAt, &g.UpdatedAt,
 		)
 		if err != nil {
@@ -321,3 +810,286 @@ func (db *DB) GetAllGroups() ([]models.Group, error) {
 
 	return groups, nil
 }
+
+// Attendance operations
+
+// CreateAttendanceRecord records the given members as present. nonce
+// identifies the checklist the attendance was taken with; a checklist is
+// only recorded once, and a second try returns ErrAttendanceRecorded.
+func (db *DB) CreateAttendanceRecord(groupID int64, sessionID *int64, adminID int64, userIDs []int64, nonce string) (*models.AttendanceRecord, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	record, err := createAttendanceRecordTx(tx, groupID, sessionID, adminID, userIDs, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit attendance record: %w", err)
+	}
+
+	return record, nil
+}
+
+// RegisterAttendance resolves the given usernames against the members of the
+// group and records one session for each resolved member, all inside a single
+// transaction. Usernames are matched case-insensitively and a username listed
+// more than once is only credited once. Unless partial is set, nothing is
+// written when any username is unknown or not a member of the group; the
+// returned result then has a nil Record and explains why. A non-nil sessionID
+// ties the attendance to that session and marks it completed; it returns
+// ErrSessionStatusChanged when the session is no longer scheduled.
+func (db *DB) RegisterAttendance(groupID int64, sessionID *int64, adminID int64, userNames []string, partial bool) (*models.AttendanceResult, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result := &models.AttendanceResult{}
+	seen := make(map[string]bool, len(userNames))
+	var userIDs []int64
+
+	for _, userName := range userNames {
+		key := strings.ToLower(userName)
+		if seen[key] {
+			result.Duplicates = append(result.Duplicates, userName)
+			continue
+		}
+		seen[key] = true
+
+		var userID int64
+		var ug models.UserGroup
+		var ugID sql.NullInt64
+		var ugName sql.NullString
+		err := tx.QueryRow(`
+			SELECT u.id, ug.id, ug.name
+			FROM users u
+			LEFT JOIN user_groups ug ON ug.user_id = u.id AND ug.group_id = $2 AND ug.status = 'active'
+			WHERE LOWER(u.username) = LOWER($1)
+			ORDER BY ug.id NULLS LAST
+			LIMIT 1
+		`, userName, groupID).Scan(&userID, &ugID, &ugName)
+
+		if err == sql.ErrNoRows {
+			result.Unknown = append(result.Unknown, userName)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve username %q: %w", userName, err)
+		}
+		if !ugID.Valid {
+			result.NotMembers = append(result.NotMembers, userName)
+			continue
+		}
+
+		ug.ID = ugID.Int64
+		ug.UserID = userID
+		ug.GroupID = groupID
+		ug.Name = ugName.String
+		result.Credited = append(result.Credited, ug)
+		userIDs = append(userIDs, userID)
+	}
+
+	if !partial && (len(result.Unknown) > 0 || len(result.NotMembers) > 0) {
+		return result, nil
+	}
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+
+	result.Record, err = createAttendanceRecordTx(tx, groupID, sessionID, adminID, userIDs, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit attendance record: %w", err)
+	}
+
+	return result, nil
+}
+
+// createAttendanceRecordTx records and charges attendance. The session is
+// locked until the transaction ends so that two confirmations of the same
+// session can't both see it scheduled.
+func createAttendanceRecordTx(tx *sql.Tx, groupID int64, sessionID *int64, adminID int64, userIDs []int64, nonce string) (*models.AttendanceRecord, error) {
+	if sessionID != nil {
+		var status models.SessionStatus
+		err := tx.QueryRow(`SELECT status FROM sessions WHERE id = $1 FOR UPDATE`, *sessionID).Scan(&status)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock session: %w", err)
+		}
+		if status != models.SessionScheduled {
+			return nil, ErrSessionStatusChanged
+		}
+		if err := setSessionStatusTx(tx, *sessionID, models.SessionScheduled, models.SessionCompleted); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	record := models.AttendanceRecord{
+		GroupID:   groupID,
+		SessionID: sessionID,
+		AdminID:   adminID,
+		UserIDs:   userIDs,
+	}
+	err = tx.QueryRow(`
+		INSERT INTO attendance_records (group_id, session_id, admin_id, user_ids, nonce)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+		RETURNING id, created_at
+	`, groupID, sessionID, adminID, pq.Array(userIDs), nonce).Scan(&record.ID, &record.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrAttendanceRecorded
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create attendance record: %w", err)
+	}
+
+	if err := chargeAttendanceTx(tx, &record); err != nil {
+		return nil, err
+	}
+
+	err = recordAuditTx(tx, auditRecord{
+		GroupID: groupID, ActorID: adminID, Action: models.AuditAttendanceRecord, EntityID: record.ID,
+		After: fields{"user_ids": userIDs, "session_id": sessionID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+func (db *DB) GetAttendanceRecord(recordID int64) (*models.AttendanceRecord, error) {
+	var record models.AttendanceRecord
+
+	err := db.QueryRow(`
+		SELECT id, group_id, session_id, admin_id, user_ids, created_at, reverted_at, is_reverted
+		FROM attendance_records
+		WHERE id = $1
+	`, recordID).Scan(
+		&record.ID, &record.GroupID, &record.SessionID, &record.AdminID, pq.Array(&record.UserIDs),
+		&record.CreatedAt, &record.RevertedAt, &record.IsReverted,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+func (db *DB) GetAttendanceRecordsByGroupID(groupID int64, limit int) ([]models.AttendanceRecord, error) {
+	rows, err := db.Query(`
+		SELECT id, group_id, session_id, admin_id, user_ids, created_at, reverted_at, is_reverted
+		FROM attendance_records
+		WHERE group_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`, groupID, limit)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []models.AttendanceRecord
+	for rows.Next() {
+		var record models.AttendanceRecord
+		err := rows.Scan(
+			&record.ID, &record.GroupID, &record.SessionID, &record.AdminID, pq.Array(&record.UserIDs),
+			&record.CreatedAt, &record.RevertedAt, &record.IsReverted,
+		)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// RevertAttendanceRecord marks the record as reverted and reverses the
+// session charges it made. The session the record was taken for is scheduled
+// again.
+func (db *DB) RevertAttendanceRecord(recordID, revertedBy int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var groupID int64
+	var sessionID *int64
+	var userIDs []int64
+	err = tx.QueryRow(`
+		UPDATE attendance_records
+		SET is_reverted = TRUE,
+		    reverted_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND NOT is_reverted
+		RETURNING group_id, session_id, user_ids
+	`, recordID).Scan(&groupID, &sessionID, pq.Array(&userIDs))
+	if err == sql.ErrNoRows {
+		return ErrAlreadyReverted
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revert attendance record: %w", err)
+	}
+
+	if err := reverseAttendanceChargeTx(tx, recordID, revertedBy); err != nil {
+		return err
+	}
+
+	if sessionID != nil {
+		if err := setSessionStatusTx(tx, *sessionID, models.SessionCompleted, models.SessionScheduled); err != nil {
+			return err
+		}
+	}
+
+	err = recordAuditTx(tx, auditRecord{
+		GroupID: groupID, ActorID: revertedBy, Action: models.AuditAttendanceRevert, EntityID: recordID,
+		Before: fields{"user_ids": userIDs, "session_id": sessionID},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetLastAttendance returns when each member of a group was last marked
+// present, keyed by user ID. Attendance taken without a session counts from
+// the time it was recorded.
+func (db *DB) GetLastAttendance(groupID int64) (map[int64]time.Time, error) {
+	rows, err := db.Query(`
+		SELECT a.user_id, MAX(COALESCE(s.starts_at, ar.created_at))
+		FROM attendance_records ar
+		CROSS JOIN LATERAL unnest(ar.user_ids) AS a(user_id)
+		LEFT JOIN sessions s ON s.id = ar.session_id
+		WHERE ar.group_id = $1 AND NOT ar.is_reverted
+		GROUP BY a.user_id
+	`, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last attendance: %w", err)
+	}
+	defer rows.Close()
+
+	last := make(map[int64]time.Time)
+	for rows.Next() {
+		var userID int64
+		var date time.Time
+		if err := rows.Scan(&userID, &date); err != nil {
+			return nil, err
+		}
+		last[userID] = date
+	}
+
+	return last, rows.Err()
+}
@@ -0,0 +1,257 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"futsal-bot/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// Adjustment operations
+
+const adjustmentColumns = `id, group_id, user_id, kind, value, session_id, starts_on, ends_on, created_by, created_at, revoked_at`
+
+func scanAdjustment(row rowScanner) (*models.Adjustment, error) {
+	var a models.Adjustment
+	err := row.Scan(&a.ID, &a.GroupID, &a.UserID, &a.Kind, &a.Value, &a.SessionID, &a.StartsOn, &a.EndsOn, &a.CreatedBy, &a.CreatedAt, &a.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// adjustmentFields are the audit values of an adjustment.
+func adjustmentFields(a *models.Adjustment) fields {
+	f := fields{"kind": a.Kind, "value": a.Value, "session_id": a.SessionID}
+	if a.StartsOn != nil {
+		f["starts_on"] = a.StartsOn.Format(dateLayout)
+	}
+	if a.EndsOn != nil {
+		f["ends_on"] = a.EndsOn.Format(dateLayout)
+	}
+	return f
+}
+
+// GrantAdjustment gives a member a discount or exemption. An adjustment for
+// a session must name a scheduled session of the same group; sql.ErrNoRows
+// is returned otherwise.
+func (db *DB) GrantAdjustment(a *models.Adjustment, actorID int64) (*models.Adjustment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if a.SessionID != nil {
+		var sessionID int64
+		err := tx.QueryRow(`
+			SELECT id FROM sessions
+			WHERE id = $1 AND group_id = $2 AND status = 'scheduled'
+		`, *a.SessionID, a.GroupID).Scan(&sessionID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var startsOn, endsOn interface{}
+	if a.StartsOn != nil {
+		startsOn = a.StartsOn.Format(dateLayout)
+	}
+	if a.EndsOn != nil {
+		endsOn = a.EndsOn.Format(dateLayout)
+	}
+
+	granted, err := scanAdjustment(tx.QueryRow(`
+		INSERT INTO member_adjustments (group_id, user_id, kind, value, session_id, starts_on, ends_on, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6::date, $7::date, $8)
+		RETURNING `+adjustmentColumns,
+		a.GroupID, a.UserID, a.Kind, a.Value, a.SessionID, startsOn, endsOn, nullID(actorID)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create adjustment: %w", err)
+	}
+
+	err = recordAuditTx(tx, auditRecord{
+		GroupID: granted.GroupID, ActorID: actorID, Action: models.AuditAdjustmentGrant,
+		UserID: granted.UserID, EntityID: granted.ID, After: adjustmentFields(granted),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return granted, nil
+}
+
+func (db *DB) GetAdjustment(adjustmentID int64) (*models.Adjustment, error) {
+	return scanAdjustment(db.QueryRow(`
+		SELECT `+adjustmentColumns+`
+		FROM member_adjustments
+		WHERE id = $1
+	`, adjustmentID))
+}
+
+// GetActiveAdjustments returns the adjustments of a member that can still
+// apply to a session: not revoked, not ended, and for one session only
+// while that session is scheduled. Oldest first.
+func (db *DB) GetActiveAdjustments(userID, groupID int64) ([]models.Adjustment, error) {
+	rows, err := db.Query(`
+		SELECT `+adjustmentColumns+`
+		FROM member_adjustments a
+		WHERE a.user_id = $1 AND a.group_id = $2 AND a.revoked_at IS NULL
+		  AND (a.ends_on IS NULL OR a.ends_on >= $3::date)
+		  AND (a.session_id IS NULL OR EXISTS (
+		      SELECT 1 FROM sessions s WHERE s.id = a.session_id AND s.status = 'scheduled'))
+		ORDER BY a.id
+	`, userID, groupID, today())
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var adjustments []models.Adjustment
+	for rows.Next() {
+		a, err := scanAdjustment(rows)
+		if err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, *a)
+	}
+
+	return adjustments, rows.Err()
+}
+
+// RevokeAdjustment stops an adjustment from applying to sessions charged
+// from now on; discounts already given stay in the ledger. It returns
+// sql.ErrNoRows when the adjustment is gone or already revoked.
+func (db *DB) RevokeAdjustment(adjustmentID, actorID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	revoked, err := scanAdjustment(tx.QueryRow(`
+		UPDATE member_adjustments
+		SET revoked_at = CURRENT_TIMESTAMP,
+		    revoked_by = $2
+		WHERE id = $1 AND revoked_at IS NULL
+		RETURNING `+adjustmentColumns,
+		adjustmentID, nullID(actorID)))
+	if err != nil {
+		return err
+	}
+
+	err = recordAuditTx(tx, auditRecord{
+		GroupID: revoked.GroupID, ActorID: actorID, Action: models.AuditAdjustmentRevoke,
+		UserID: revoked.UserID, EntityID: revoked.ID, Before: adjustmentFields(revoked),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// discountPostingsTx takes the member adjustments that apply on the day of a
+// charge off the members' fees. Each discount is a negative member posting
+// naming its adjustment, balanced by the discount account. An exemption
+// waives the whole fee; other discounts are each worked out on the full fee
+// and together never exceed it.
+func discountPostingsTx(tx *sql.Tx, record *models.AttendanceRecord, occurredAt time.Time, fees []models.LedgerPosting) ([]models.LedgerPosting, error) {
+	feeOf := make(map[int64]float64)
+	var userIDs []int64
+	for _, p := range fees {
+		if p.UserID != nil && p.Amount > 0 {
+			feeOf[*p.UserID] += p.Amount
+			userIDs = append(userIDs, *p.UserID)
+		}
+	}
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+
+	rows, err := tx.Query(`
+		SELECT `+adjustmentColumns+`
+		FROM member_adjustments
+		WHERE group_id = $1 AND user_id = ANY($2) AND revoked_at IS NULL
+		  AND (session_id = $3
+		       OR (session_id IS NULL
+		           AND (starts_on IS NULL OR starts_on <= $4::date)
+		           AND (ends_on IS NULL OR ends_on >= $4::date)))
+		ORDER BY id
+	`, record.GroupID, pq.Array(userIDs), record.SessionID, occurredAt.In(time.Local).Format(dateLayout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get member adjustments: %w", err)
+	}
+	defer rows.Close()
+
+	byUser := make(map[int64][]models.Adjustment)
+	for rows.Next() {
+		a, err := scanAdjustment(rows)
+		if err != nil {
+			return nil, err
+		}
+		byUser[a.UserID] = append(byUser[a.UserID], *a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var postings []models.LedgerPosting
+	for _, userID := range userIDs {
+		fee := feeOf[userID]
+		for _, d := range adjustmentDiscounts(fee, byUser[userID]) {
+			userID, adjustmentID := userID, d.adjustmentID
+			postings = append(postings,
+				models.LedgerPosting{Account: models.AccountMember, UserID: &userID, Amount: -d.amount, AdjustmentID: &adjustmentID},
+				models.LedgerPosting{Account: models.AccountDiscount, Amount: d.amount, AdjustmentID: &adjustmentID},
+			)
+		}
+	}
+
+	return postings, nil
+}
+
+type discount struct {
+	adjustmentID int64
+	amount       float64
+}
+
+// adjustmentDiscounts works out what each adjustment takes off a fee, in
+// whole toman.
+func adjustmentDiscounts(fee float64, adjustments []models.Adjustment) []discount {
+	for _, a := range adjustments {
+		if a.Kind == models.AdjustmentExempt {
+			return []discount{{a.ID, fee}}
+		}
+	}
+
+	var discounts []discount
+	remaining := fee
+	for _, a := range adjustments {
+		amount := a.Value
+		if a.Kind == models.AdjustmentPercent {
+			amount = math.Round(fee * a.Value / 100)
+		}
+		amount = math.Min(amount, remaining)
+		if amount <= 0 {
+			continue
+		}
+		remaining -= amount
+		discounts = append(discounts, discount{a.ID, amount})
+	}
+
+	return discounts
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"futsal-bot/internal/models"
+)
+
+// fields are the values an audit event records before or after a change.
+type fields map[string]interface{}
+
+// auditRecord is an audit event about to be written. Zero IDs are stored as
+// NULL: a zero ActorID means the bot acted on its own.
+type auditRecord struct {
+	GroupID  int64
+	ActorID  int64
+	Action   models.AuditAction
+	UserID   int64
+	EntityID int64
+	Before   fields
+	After    fields
+}
+
+func nullID(id int64) *int64 {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
+
+func jsonFields(f fields) (interface{}, error) {
+	if f == nil {
+		return nil, nil
+	}
+	return json.Marshal(f)
+}
+
+// recordAuditTx appends an event to the audit log as part of the change it
+// describes, so the log can't miss a committed change.
+func recordAuditTx(tx *sql.Tx, a auditRecord) error {
+	before, err := jsonFields(a.Before)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	after, err := jsonFields(a.After)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO audit_events (group_id, actor_id, action, user_id, entity_id, before, after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, a.GroupID, nullID(a.ActorID), a.Action, nullID(a.UserID), nullID(a.EntityID), before, after)
+	if err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+
+	return nil
+}
+
+// GetAuditEvents returns a page of the audit log of a group, newest first.
+// A non-zero userID keeps only the events about that member, including the
+// attendance records they are part of.
+func (db *DB) GetAuditEvents(groupID, userID int64, limit, offset int) ([]models.AuditEvent, error) {
+	rows, err := db.Query(`
+		SELECT e.id, e.group_id, e.actor_id, COALESCE(aug.name, au.first_name, ''), e.action,
+		       e.user_id, COALESCE(sug.name, su.first_name, ''), e.entity_id, e.before, e.after, e.created_at
+		FROM audit_events e
+		LEFT JOIN users au ON au.id = e.actor_id
+		LEFT JOIN user_groups aug ON aug.user_id = e.actor_id AND aug.group_id = e.group_id
+		LEFT JOIN users su ON su.id = e.user_id
+		LEFT JOIN user_groups sug ON sug.user_id = e.user_id AND sug.group_id = e.group_id
+		WHERE e.group_id = $1
+		  AND ($2::bigint = 0 OR e.user_id = $2::bigint OR COALESCE(e.after, e.before)->'user_ids' @> to_jsonb($2::bigint))
+		ORDER BY e.created_at DESC, e.id DESC
+		LIMIT $3 OFFSET $4
+	`, groupID, userID, limit, offset)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var e models.AuditEvent
+		var before, after []byte
+		err := rows.Scan(
+			&e.ID, &e.GroupID, &e.ActorID, &e.ActorName, &e.Action,
+			&e.UserID, &e.UserName, &e.EntityID, &before, &after, &e.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		e.Before, e.After = before, after
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,503 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"futsal-bot/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// Ledger operations
+
+// checkBalanced returns an error unless postings sum to zero.
+func checkBalanced(postings []models.LedgerPosting) error {
+	var sum float64
+	for _, p := range postings {
+		sum += p.Amount
+	}
+	if math.Abs(sum) >= 0.005 {
+		return fmt.Errorf("unbalanced ledger transaction: postings sum to %.2f", sum)
+	}
+	return nil
+}
+
+// postTransactionTx appends a transaction and its postings to the ledger.
+// Postings must balance to zero.
+func postTransactionTx(tx *sql.Tx, t *models.LedgerTransaction, postings []models.LedgerPosting) error {
+	if err := checkBalanced(postings); err != nil {
+		return err
+	}
+
+	if t.OccurredAt.IsZero() {
+		t.OccurredAt = time.Now()
+	}
+
+	err := tx.QueryRow(`
+		INSERT INTO ledger_transactions (group_id, kind, attendance_record_id, session_id, payment_id, reverses_id, description, created_by, occurred_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at
+	`, t.GroupID, t.Kind, t.AttendanceRecordID, t.SessionID, t.PaymentID, t.ReversesID, t.Description, t.CreatedBy, t.OccurredAt,
+	).Scan(&t.ID, &t.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create ledger transaction: %w", err)
+	}
+
+	for _, p := range postings {
+		_, err := tx.Exec(`
+			INSERT INTO ledger_postings (transaction_id, account, user_id, amount, sessions, weight, adjustment_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, t.ID, p.Account, p.UserID, p.Amount, p.Sessions, p.Weight, p.AdjustmentID)
+		if err != nil {
+			return fmt.Errorf("failed to create ledger posting: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ErrVenueCostMissing is returned when attendance is recorded in a group that
+// splits venue costs for a session whose cost is not set, or for no session.
+var ErrVenueCostMissing = errors.New("venue cost of the session is not set")
+
+// ErrRateMissing is returned when attendance is recorded in a group with
+// fixed pricing for a day before the first rate of an attendee's tier.
+var ErrRateMissing = errors.New("tier has no rate for the session day")
+
+// chargeAttendanceTx charges every member of an attendance record for one
+// session. In fixed pricing each pays the rate of their pricing tier on the
+// day of the session, which it must have by then, and members without a tier
+// are not charged. In split pricing the venue cost of the session is shared
+// by tier weight. Member discounts are then taken off in the same
+// transaction.
+func chargeAttendanceTx(tx *sql.Tx, record *models.AttendanceRecord) error {
+	var mode models.PricingMode
+	err := tx.QueryRow(`SELECT pricing_mode FROM groups WHERE id = $1`, record.GroupID).Scan(&mode)
+	if err != nil {
+		return fmt.Errorf("failed to get pricing mode: %w", err)
+	}
+
+	occurredAt := record.CreatedAt
+	var venueCost *float64
+	if record.SessionID != nil {
+		err := tx.QueryRow(`SELECT starts_at, venue_cost FROM sessions WHERE id = $1`, *record.SessionID).Scan(&occurredAt, &venueCost)
+		if err != nil {
+			return fmt.Errorf("failed to get session: %w", err)
+		}
+	}
+
+	var postings []models.LedgerPosting
+	if mode == models.PricingSplit {
+		if venueCost == nil {
+			return ErrVenueCostMissing
+		}
+		postings, err = splitChargesTx(tx, record, *venueCost)
+	} else {
+		postings, err = rateChargesTx(tx, record, occurredAt)
+	}
+	if err != nil {
+		return err
+	}
+
+	var total float64
+	for _, p := range postings {
+		total += p.Amount
+	}
+
+	discounts, err := discountPostingsTx(tx, record, occurredAt, postings)
+	if err != nil {
+		return err
+	}
+	postings = append(postings, discounts...)
+	postings = append(postings, models.LedgerPosting{Account: models.AccountRevenue, Amount: -total})
+
+	recordID := record.ID
+	adminID := record.AdminID
+	return postTransactionTx(tx, &models.LedgerTransaction{
+		GroupID:            record.GroupID,
+		Kind:               models.LedgerCharge,
+		AttendanceRecordID: &recordID,
+		SessionID:          record.SessionID,
+		CreatedBy:          &adminID,
+		OccurredAt:         occurredAt,
+	}, postings)
+}
+
+// rateChargesTx charges each member the rate of their tier on the day of the
+// session. It returns ErrRateMissing if a tier has no rate yet on that day.
+func rateChargesTx(tx *sql.Tx, record *models.AttendanceRecord, occurredAt time.Time) ([]models.LedgerPosting, error) {
+	rows, err := tx.Query(`
+		SELECT ug.user_id, ug.tier_id IS NOT NULL, `+tierRateAt("$3::date")+`
+		FROM user_groups ug
+		LEFT JOIN pricing_tiers t ON t.id = ug.tier_id
+		WHERE ug.group_id = $1 AND ug.user_id = ANY($2)
+	`, record.GroupID, pq.Array(record.UserIDs), occurredAt.In(time.Local).Format(dateLayout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get member rates: %w", err)
+	}
+	defer rows.Close()
+
+	var postings []models.LedgerPosting
+	for rows.Next() {
+		var userID int64
+		var hasTier bool
+		var rate sql.NullFloat64
+		if err := rows.Scan(&userID, &hasTier, &rate); err != nil {
+			return nil, err
+		}
+		if hasTier && !rate.Valid {
+			return nil, ErrRateMissing
+		}
+		postings = append(postings, models.LedgerPosting{
+			Account:  models.AccountMember,
+			UserID:   &userID,
+			Amount:   rate.Float64,
+			Sessions: 1,
+		})
+	}
+
+	return postings, rows.Err()
+}
+
+// splitChargesTx shares the venue cost among the members by the weights of
+// their tiers. Members without a tier count as weight 1.
+func splitChargesTx(tx *sql.Tx, record *models.AttendanceRecord, venueCost float64) ([]models.LedgerPosting, error) {
+	rows, err := tx.Query(`
+		SELECT ug.user_id, COALESCE(t.weight, 1)
+		FROM user_groups ug
+		LEFT JOIN pricing_tiers t ON t.id = ug.tier_id
+		WHERE ug.group_id = $1 AND ug.user_id = ANY($2)
+		ORDER BY ug.user_id
+	`, record.GroupID, pq.Array(record.UserIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get member weights: %w", err)
+	}
+	defer rows.Close()
+
+	var userIDs []int64
+	var weights []float64
+	for rows.Next() {
+		var userID int64
+		var weight float64
+		if err := rows.Scan(&userID, &weight); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+		weights = append(weights, weight)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	shares := splitCost(venueCost, weights)
+	postings := make([]models.LedgerPosting, len(userIDs))
+	for i := range userIDs {
+		postings[i] = models.LedgerPosting{
+			Account:  models.AccountMember,
+			UserID:   &userIDs[i],
+			Amount:   shares[i],
+			Sessions: 1,
+			Weight:   &weights[i],
+		}
+	}
+
+	return postings, nil
+}
+
+// splitCost divides cost, rounded to whole toman, in proportion to weights.
+// The toman lost to rounding go to the largest remainders, so the shares
+// always add up to the cost. Weights that are all zero split evenly.
+func splitCost(cost float64, weights []float64) []float64 {
+	shares := make([]float64, len(weights))
+	if len(weights) == 0 {
+		return shares
+	}
+
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	if total == 0 {
+		// Normalise a copy; the caller records the weights as configured
+		even := make([]float64, len(weights))
+		for i := range even {
+			even[i] = 1
+		}
+		weights, total = even, float64(len(even))
+	}
+
+	whole := math.Round(cost)
+	remainders := make([]float64, len(weights))
+	var assigned float64
+	for i, w := range weights {
+		exact := whole * w / total
+		shares[i] = math.Floor(exact)
+		remainders[i] = exact - shares[i]
+		assigned += shares[i]
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]] > remainders[order[b]] })
+	for i := 0; assigned < whole; i++ {
+		shares[order[i%len(order)]]++
+		assigned++
+	}
+
+	return shares
+}
+
+// reverseAttendanceChargeTx cancels the session charges of an attendance record.
+func reverseAttendanceChargeTx(tx *sql.Tx, recordID, reversedBy int64) error {
+	rows, err := tx.Query(`
+		SELECT t.id
+		FROM ledger_transactions t
+		WHERE t.attendance_record_id = $1 AND t.kind = 'charge'
+		  AND NOT EXISTS (SELECT 1 FROM ledger_transactions r WHERE r.reverses_id = t.id)
+	`, recordID)
+	if err != nil {
+		return fmt.Errorf("failed to get attendance charges: %w", err)
+	}
+
+	var txnIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		txnIDs = append(txnIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range txnIDs {
+		if err := reverseTransactionTx(tx, id, reversedBy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reverseTransactionTx appends a transaction that exactly cancels another one.
+// The original stays in the ledger untouched.
+func reverseTransactionTx(tx *sql.Tx, txnID, reversedBy int64) error {
+	var original models.LedgerTransaction
+	err := tx.QueryRow(`
+		SELECT id, group_id, attendance_record_id, session_id, payment_id
+		FROM ledger_transactions
+		WHERE id = $1
+	`, txnID).Scan(&original.ID, &original.GroupID, &original.AttendanceRecordID, &original.SessionID, &original.PaymentID)
+	if err != nil {
+		return fmt.Errorf("failed to get ledger transaction: %w", err)
+	}
+
+	rows, err := tx.Query(`
+		SELECT account, user_id, amount, sessions, weight, adjustment_id
+		FROM ledger_postings
+		WHERE transaction_id = $1
+	`, txnID)
+	if err != nil {
+		return fmt.Errorf("failed to get ledger postings: %w", err)
+	}
+
+	var postings []models.LedgerPosting
+	for rows.Next() {
+		var p models.LedgerPosting
+		if err := rows.Scan(&p.Account, &p.UserID, &p.Amount, &p.Sessions, &p.Weight, &p.AdjustmentID); err != nil {
+			rows.Close()
+			return err
+		}
+		postings = append(postings, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return postTransactionTx(tx, &models.LedgerTransaction{
+		GroupID:            original.GroupID,
+		Kind:               models.LedgerReversal,
+		AttendanceRecordID: original.AttendanceRecordID,
+		SessionID:          original.SessionID,
+		PaymentID:          original.PaymentID,
+		ReversesID:         &original.ID,
+		CreatedBy:          &reversedBy,
+	}, reversePostings(postings))
+}
+
+// reversePostings returns postings that cancel the given ones: the same
+// accounts, members and adjustments with amounts and sessions negated.
+func reversePostings(postings []models.LedgerPosting) []models.LedgerPosting {
+	reversed := make([]models.LedgerPosting, len(postings))
+	for i, p := range postings {
+		p.Amount = -p.Amount
+		p.Sessions = -p.Sessions
+		reversed[i] = p
+	}
+	return reversed
+}
+
+// balanceColumns sums member postings by the kind of their transaction (t),
+// the kind of the transaction a reversal cancels (o) and whether they are
+// discounts, for addPostings to fold into a balance.
+const balanceColumns = `
+	t.kind, COALESCE(o.kind, ''), p.adjustment_id IS NOT NULL,
+	COALESCE(SUM(p.sessions), 0), COALESCE(SUM(p.amount), 0)`
+
+// addPostings adds member postings to a balance. A reversal counts as the
+// kind of transaction it cancels, so a reversed payment takes off Paid rather
+// than adding to Charged. Discount postings are told apart by the adjustment
+// they name, reversals included.
+func addPostings(b *models.Balance, kind, reverses models.LedgerKind, discount bool, sessions int, amount float64) {
+	if kind == models.LedgerReversal && reverses != "" {
+		kind = reverses
+	}
+
+	b.Sessions += sessions
+	b.Balance += amount
+	switch {
+	case discount:
+		b.Discounts -= amount
+	case kind == models.LedgerPayment:
+		b.Paid -= amount
+	default:
+		b.Charged += amount
+	}
+}
+
+// GetBalance derives a member's balance in a group from the ledger.
+func (db *DB) GetBalance(userID, groupID int64) (*models.Balance, error) {
+	rows, err := db.Query(`
+		SELECT `+balanceColumns+`
+		FROM ledger_postings p
+		JOIN ledger_transactions t ON t.id = p.transaction_id
+		LEFT JOIN ledger_transactions o ON o.id = t.reverses_id
+		WHERE p.account = 'member' AND p.user_id = $1 AND t.group_id = $2
+		GROUP BY 1, 2, 3
+	`, userID, groupID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balance := models.Balance{UserID: userID}
+	for rows.Next() {
+		var kind, reverses models.LedgerKind
+		var discount bool
+		var sessions int
+		var amount float64
+		if err := rows.Scan(&kind, &reverses, &discount, &sessions, &amount); err != nil {
+			return nil, err
+		}
+		addPostings(&balance, kind, reverses, discount, sessions, amount)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &balance, nil
+}
+
+// GetGroupBalances derives the balance of every member with ledger activity
+// in a group, keyed by user ID.
+func (db *DB) GetGroupBalances(groupID int64) (map[int64]models.Balance, error) {
+	rows, err := db.Query(`
+		SELECT p.user_id, `+balanceColumns+`
+		FROM ledger_postings p
+		JOIN ledger_transactions t ON t.id = p.transaction_id
+		LEFT JOIN ledger_transactions o ON o.id = t.reverses_id
+		WHERE p.account = 'member' AND t.group_id = $1
+		GROUP BY 1, 2, 3, 4
+	`, groupID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := make(map[int64]models.Balance)
+	for rows.Next() {
+		var userID int64
+		var kind, reverses models.LedgerKind
+		var discount bool
+		var sessions int
+		var amount float64
+		if err := rows.Scan(&userID, &kind, &reverses, &discount, &sessions, &amount); err != nil {
+			return nil, err
+		}
+		b := balances[userID]
+		b.UserID = userID
+		addPostings(&b, kind, reverses, discount, sessions, amount)
+		balances[userID] = b
+	}
+
+	return balances, rows.Err()
+}
+
+// GetOutstandingCharges returns the session fees that make up a member's
+// current debt, oldest first. Payments settle the oldest fees first, so the
+// outstanding fees are the most recent ones covering the balance.
+func (db *DB) GetOutstandingCharges(userID, groupID int64) ([]models.Charge, error) {
+	balance, err := db.GetBalance(userID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if balance.Balance <= 0 {
+		return nil, nil
+	}
+
+	rows, err := db.Query(`
+		SELECT t.id, t.session_id, COALESCE(s.starts_at, t.occurred_at), COALESCE(s.venue, ''),
+		       SUM(p.amount), COALESCE(-SUM(p.amount) FILTER (WHERE p.adjustment_id IS NOT NULL), 0)
+		FROM ledger_postings p
+		JOIN ledger_transactions t ON t.id = p.transaction_id
+		LEFT JOIN sessions s ON s.id = t.session_id
+		WHERE p.account = 'member' AND p.user_id = $1 AND t.group_id = $2
+		  AND t.kind IN ('charge', 'opening_balance')
+		  AND NOT EXISTS (SELECT 1 FROM ledger_transactions r WHERE r.reverses_id = t.id)
+		GROUP BY t.id, s.starts_at, s.venue
+		HAVING SUM(p.amount) > 0
+		ORDER BY 3 DESC, t.id DESC
+	`, userID, groupID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var charges []models.Charge
+	remaining := balance.Balance
+	for remaining > 0.005 && rows.Next() {
+		var c models.Charge
+		if err := rows.Scan(&c.TransactionID, &c.SessionID, &c.Date, &c.Venue, &c.Amount, &c.Discount); err != nil {
+			return nil, err
+		}
+		if c.Amount > remaining {
+			c.Amount = remaining
+		}
+		remaining -= c.Amount
+		charges = append(charges, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Oldest first
+	for i, j := 0, len(charges)-1; i < j; i, j = i+1, j-1 {
+		charges[i], charges[j] = charges[j], charges[i]
+	}
+
+	return charges, nil
+}
@@ -0,0 +1,154 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+
+	"futsal-bot/internal/models"
+)
+
+func TestSplitCost(t *testing.T) {
+	tests := []struct {
+		name    string
+		cost    float64
+		weights []float64
+		want    []float64
+	}{
+		{"even", 300000, []float64{1, 1, 1}, []float64{100000, 100000, 100000}},
+		{"weighted", 270000, []float64{1, 0.7, 1}, []float64{100000, 70000, 100000}},
+		{"rounding goes to the largest remainders", 100, []float64{1, 1, 1}, []float64{34, 33, 33}},
+		{"zero weights split evenly", 90, []float64{0, 0, 0}, []float64{30, 30, 30}},
+		{"nobody attended", 100, nil, []float64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			weights := append([]float64(nil), tt.weights...)
+			got := splitCost(tt.cost, weights)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitCost(%v, %v) = %v, want %v", tt.cost, tt.weights, got, tt.want)
+			}
+			if !reflect.DeepEqual(weights, append([]float64(nil), tt.weights...)) {
+				t.Errorf("splitCost changed the weights %v to %v", tt.weights, weights)
+			}
+		})
+	}
+}
+
+func TestAdjustmentDiscounts(t *testing.T) {
+	percent := models.Adjustment{ID: 1, Kind: models.AdjustmentPercent, Value: 30}
+	fixed := models.Adjustment{ID: 2, Kind: models.AdjustmentFixed, Value: 50000}
+	exempt := models.Adjustment{ID: 3, Kind: models.AdjustmentExempt}
+
+	tests := []struct {
+		name        string
+		fee         float64
+		adjustments []models.Adjustment
+		want        []discount
+	}{
+		{"none", 150000, nil, nil},
+		{"percent", 150000, []models.Adjustment{percent}, []discount{{1, 45000}}},
+		{"fixed", 150000, []models.Adjustment{fixed}, []discount{{2, 50000}}},
+		{"both are taken off the gross fee", 150000, []models.Adjustment{percent, fixed}, []discount{{1, 45000}, {2, 50000}}},
+		{"capped at the fee", 60000, []models.Adjustment{percent, fixed}, []discount{{1, 18000}, {2, 42000}}},
+		{"exemption waives the fee alone", 150000, []models.Adjustment{percent, exempt}, []discount{{3, 150000}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := adjustmentDiscounts(tt.fee, tt.adjustments)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("adjustmentDiscounts(%v) = %v, want %v", tt.fee, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddPostings(t *testing.T) {
+	type row struct {
+		kind, reverses models.LedgerKind
+		discount       bool
+		sessions       int
+		amount         float64
+	}
+
+	tests := []struct {
+		name string
+		rows []row
+		want models.Balance
+	}{
+		{"nothing", nil, models.Balance{}},
+		{"charge, discount and payment", []row{
+			{models.LedgerCharge, "", false, 2, 300000},
+			{models.LedgerCharge, "", true, 0, -60000},
+			{models.LedgerPayment, "", false, 0, -100000},
+		}, models.Balance{Sessions: 2, Charged: 300000, Discounts: 60000, Paid: 100000, Balance: 140000}},
+		{"opening balance is a charge", []row{
+			{models.LedgerOpeningBalance, "", false, 0, 50000},
+		}, models.Balance{Charged: 50000, Balance: 50000}},
+		{"reversed charge and its discount", []row{
+			{models.LedgerCharge, "", false, 1, 150000},
+			{models.LedgerCharge, "", true, 0, -45000},
+			{models.LedgerReversal, models.LedgerCharge, false, -1, -150000},
+			{models.LedgerReversal, models.LedgerCharge, true, 0, 45000},
+		}, models.Balance{}},
+		{"reversed payment", []row{
+			{models.LedgerPayment, "", false, 0, -100000},
+			{models.LedgerReversal, models.LedgerPayment, false, 0, 100000},
+		}, models.Balance{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got models.Balance
+			for _, r := range tt.rows {
+				addPostings(&got, r.kind, r.reverses, r.discount, r.sessions, r.amount)
+			}
+			if got != tt.want {
+				t.Errorf("balance = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckBalanced(t *testing.T) {
+	user := int64(7)
+	charge := []models.LedgerPosting{
+		{Account: models.AccountMember, UserID: &user, Amount: 100000, Sessions: 1},
+		{Account: models.AccountRevenue, Amount: -100000},
+	}
+	if err := checkBalanced(charge); err != nil {
+		t.Errorf("balanced charge: %v", err)
+	}
+	if err := checkBalanced(nil); err != nil {
+		t.Errorf("no postings: %v", err)
+	}
+
+	charge[1].Amount = -99999.99
+	if err := checkBalanced(charge); err == nil {
+		t.Error("a rial off went through")
+	}
+}
+
+func TestReversePostings(t *testing.T) {
+	user, adjustment := int64(7), int64(3)
+	postings := []models.LedgerPosting{
+		{Account: models.AccountMember, UserID: &user, Amount: 150000, Sessions: 1},
+		{Account: models.AccountRevenue, Amount: -150000},
+		{Account: models.AccountMember, UserID: &user, Amount: -45000, AdjustmentID: &adjustment},
+		{Account: models.AccountDiscount, Amount: 45000, AdjustmentID: &adjustment},
+	}
+
+	want := []models.LedgerPosting{
+		{Account: models.AccountMember, UserID: &user, Amount: -150000, Sessions: -1},
+		{Account: models.AccountRevenue, Amount: 150000},
+		{Account: models.AccountMember, UserID: &user, Amount: 45000, AdjustmentID: &adjustment},
+		{Account: models.AccountDiscount, Amount: -45000, AdjustmentID: &adjustment},
+	}
+	if got := reversePostings(postings); !reflect.DeepEqual(got, want) {
+		t.Errorf("reversePostings = %+v, want %+v", got, want)
+	}
+	if postings[0].Amount != 150000 {
+		t.Error("reversePostings changed the original postings")
+	}
+}
@@ -0,0 +1,217 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"futsal-bot/internal/models"
+)
+
+var ErrPaymentReviewed = errors.New("payment already reviewed")
+
+const paymentColumns = `id, group_id, user_id, amount, method, note, status, receipt_file_id, recorded_by, reviewed_by, reviewed_at, created_at`
+
+func scanPayment(row rowScanner) (*models.Payment, error) {
+	var p models.Payment
+	err := row.Scan(
+		&p.ID, &p.GroupID, &p.UserID, &p.Amount, &p.Method, &p.Note, &p.Status,
+		&p.ReceiptFileID, &p.RecordedBy, &p.ReviewedBy, &p.ReviewedAt, &p.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Payment operations
+
+// RecordPayment stores a payment taken by an admin and credits it to the
+// member's account.
+func (db *DB) RecordPayment(groupID, userID int64, amount float64, method models.PaymentMethod, note string, recordedBy int64) (*models.Payment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	payment, err := scanPayment(tx.QueryRow(`
+		INSERT INTO payments (group_id, user_id, amount, method, note, status, recorded_by, reviewed_by, reviewed_at)
+		VALUES ($1, $2, $3, $4, $5, 'approved', $6, $6, CURRENT_TIMESTAMP)
+		RETURNING `+paymentColumns,
+		groupID, userID, amount, method, note, recordedBy,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create payment: %w", err)
+	}
+
+	if err := postPaymentTx(tx, payment, recordedBy); err != nil {
+		return nil, err
+	}
+
+	err = recordAuditTx(tx, auditRecord{
+		GroupID: groupID, ActorID: recordedBy, Action: models.AuditPaymentRecord, UserID: userID, EntityID: payment.ID,
+		After: fields{"amount": amount, "method": method, "note": note},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit payment: %w", err)
+	}
+
+	return payment, nil
+}
+
+// CreatePaymentClaim stores a payment reported by the member themselves.
+// It stays pending and does not touch the ledger until an admin approves it.
+func (db *DB) CreatePaymentClaim(groupID, userID int64, amount float64, receiptFileID string) (*models.Payment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	payment, err := scanPayment(tx.QueryRow(`
+		INSERT INTO payments (group_id, user_id, amount, status, receipt_file_id, recorded_by)
+		VALUES ($1, $2, $3, 'pending', $4, $2)
+		RETURNING `+paymentColumns,
+		groupID, userID, amount, receiptFileID,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create payment claim: %w", err)
+	}
+
+	err = recordAuditTx(tx, auditRecord{
+		GroupID: groupID, ActorID: userID, Action: models.AuditPaymentClaim, UserID: userID, EntityID: payment.ID,
+		After: fields{"amount": amount, "status": payment.Status},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit payment claim: %w", err)
+	}
+
+	return payment, nil
+}
+
+func (db *DB) GetPayment(paymentID int64) (*models.Payment, error) {
+	return scanPayment(db.QueryRow(`
+		SELECT `+paymentColumns+`
+		FROM payments
+		WHERE id = $1
+	`, paymentID))
+}
+
+// ReviewPaymentClaim approves or rejects a pending claim. Approving credits
+// the payment to the member's account. It returns ErrPaymentReviewed when
+// another admin got there first.
+func (db *DB) ReviewPaymentClaim(paymentID, reviewerID int64, approve bool) (*models.Payment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	payment, err := scanPayment(tx.QueryRow(`
+		SELECT `+paymentColumns+`
+		FROM payments
+		WHERE id = $1
+		FOR UPDATE
+	`, paymentID))
+	if err != nil {
+		return nil, err
+	}
+
+	if payment.Status != models.PaymentPending {
+		return payment, ErrPaymentReviewed
+	}
+
+	status := models.PaymentRejected
+	if approve {
+		status = models.PaymentApproved
+	}
+
+	err = tx.QueryRow(`
+		UPDATE payments
+		SET status = $1, reviewed_by = $2, reviewed_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+		RETURNING reviewed_at
+	`, status, reviewerID, paymentID).Scan(&payment.ReviewedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to review payment: %w", err)
+	}
+	payment.Status = status
+	payment.ReviewedBy = &reviewerID
+
+	if approve {
+		if err := postPaymentTx(tx, payment, reviewerID); err != nil {
+			return nil, err
+		}
+	}
+
+	action := models.AuditPaymentReject
+	if approve {
+		action = models.AuditPaymentApprove
+	}
+	err = recordAuditTx(tx, auditRecord{
+		GroupID: payment.GroupID, ActorID: reviewerID, Action: action, UserID: payment.UserID, EntityID: paymentID,
+		Before: fields{"amount": payment.Amount, "status": models.PaymentPending},
+		After:  fields{"amount": payment.Amount, "status": status},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit payment review: %w", err)
+	}
+
+	return payment, nil
+}
+
+func postPaymentTx(tx *sql.Tx, payment *models.Payment, postedBy int64) error {
+	paymentID := payment.ID
+	userID := payment.UserID
+	return postTransactionTx(tx, &models.LedgerTransaction{
+		GroupID:     payment.GroupID,
+		Kind:        models.LedgerPayment,
+		PaymentID:   &paymentID,
+		Description: payment.Note,
+		CreatedBy:   &postedBy,
+	}, []models.LedgerPosting{
+		{Account: models.AccountMember, UserID: &userID, Amount: -payment.Amount},
+		{Account: models.AccountCash, Amount: payment.Amount},
+	})
+}
+
+// GetPayments returns a member's most recent payments in a group, including
+// pending and rejected claims, newest first.
+func (db *DB) GetPayments(userID, groupID int64, limit int) ([]models.Payment, error) {
+	rows, err := db.Query(`
+		SELECT `+paymentColumns+`
+		FROM payments
+		WHERE user_id = $1 AND group_id = $2
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`, userID, groupID, limit)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []models.Payment
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *p)
+	}
+
+	return payments, rows.Err()
+}
@@ -0,0 +1,403 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"futsal-bot/internal/models"
+)
+
+var ErrSessionClosed = errors.New("session is not open for rsvp")
+
+// ErrSessionStatusChanged is returned when a session is no longer in the
+// status an update expects, e.g. attendance for a session that was already
+// completed or cancelled.
+var ErrSessionStatusChanged = errors.New("session status changed")
+
+const sessionColumns = `id, group_id, starts_at, venue, capacity, venue_cost, status, created_by, rsvp_message_id, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSession(row rowScanner) (*models.Session, error) {
+	var s models.Session
+	err := row.Scan(
+		&s.ID, &s.GroupID, &s.StartsAt, &s.Venue, &s.Capacity, &s.VenueCost,
+		&s.Status, &s.CreatedBy, &s.RSVPMessageID, &s.CreatedAt, &s.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Session operations
+// CreateSession schedules a session. venueCost is what the venue charges for
+// it, or nil when unknown.
+func (db *DB) CreateSession(groupID int64, startsAt time.Time, venue string, capacity int, venueCost *float64, createdBy int64) (*models.Session, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	session, err := scanSession(tx.QueryRow(`
+		INSERT INTO sessions (group_id, starts_at, venue, capacity, venue_cost, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+sessionColumns,
+		groupID, startsAt, venue, capacity, venueCost, createdBy,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	err = recordAuditTx(tx, auditRecord{
+		GroupID: groupID, ActorID: createdBy, Action: models.AuditSessionCreate, EntityID: session.ID,
+		After: fields{"starts_at": session.StartsAt, "venue": session.Venue, "capacity": session.Capacity, "venue_cost": session.VenueCost},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit session: %w", err)
+	}
+
+	return session, nil
+}
+
+func (db *DB) GetSession(sessionID int64) (*models.Session, error) {
+	return scanSession(db.QueryRow(`
+		SELECT `+sessionColumns+`
+		FROM sessions
+		WHERE id = $1
+	`, sessionID))
+}
+
+// GetUpcomingSessions returns the scheduled sessions of a group starting at or
+// after the given time, soonest first.
+func (db *DB) GetUpcomingSessions(groupID int64, from time.Time) ([]models.Session, error) {
+	return db.querySessions(`
+		SELECT `+sessionColumns+`
+		FROM sessions
+		WHERE group_id = $1 AND status = 'scheduled' AND starts_at >= $2
+		ORDER BY starts_at
+	`, groupID, from)
+}
+
+// GetScheduledSessionsBetween returns the scheduled sessions of a group that
+// start within [from, to], soonest first.
+func (db *DB) GetScheduledSessionsBetween(groupID int64, from, to time.Time) ([]models.Session, error) {
+	return db.querySessions(`
+		SELECT `+sessionColumns+`
+		FROM sessions
+		WHERE group_id = $1 AND status = 'scheduled' AND starts_at BETWEEN $2 AND $3
+		ORDER BY starts_at
+	`, groupID, from, to)
+}
+
+func (db *DB) CancelSession(sessionID, actorID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var groupID int64
+	err = tx.QueryRow(`
+		UPDATE sessions
+		SET status = 'cancelled',
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'scheduled'
+		RETURNING group_id
+	`, sessionID).Scan(&groupID)
+	if err != nil {
+		return err
+	}
+
+	err = recordAuditTx(tx, auditRecord{
+		GroupID: groupID, ActorID: actorID, Action: models.AuditSessionCancel, EntityID: sessionID,
+		Before: fields{"status": models.SessionScheduled}, After: fields{"status": models.SessionCancelled},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetSessionVenueCost sets what the venue charges for a scheduled session.
+// It returns sql.ErrNoRows when the session is no longer scheduled.
+func (db *DB) SetSessionVenueCost(sessionID int64, venueCost float64, actorID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var groupID int64
+	var old *float64
+	err = tx.QueryRow(`
+		SELECT group_id, venue_cost FROM sessions
+		WHERE id = $1 AND status = 'scheduled'
+		FOR UPDATE
+	`, sessionID).Scan(&groupID, &old)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE sessions
+		SET venue_cost = $1,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`, venueCost, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to set venue cost: %w", err)
+	}
+
+	err = recordAuditTx(tx, auditRecord{
+		GroupID: groupID, ActorID: actorID, Action: models.AuditSessionCost, EntityID: sessionID,
+		Before: fields{"venue_cost": old}, After: fields{"venue_cost": venueCost},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) querySessions(query string, args ...interface{}) ([]models.Session, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *s)
+	}
+
+	return sessions, rows.Err()
+}
+
+// setSessionStatusTx moves a session from one status to another. It returns
+// ErrSessionStatusChanged when the session is not in the from status.
+func setSessionStatusTx(tx *sql.Tx, sessionID int64, from, to models.SessionStatus) error {
+	res, err := tx.Exec(`
+		UPDATE sessions
+		SET status = $1,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND status = $3
+	`, to, sessionID, from)
+	if err != nil {
+		return fmt.Errorf("failed to update session status: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update session status: %w", err)
+	}
+	if n == 0 {
+		return ErrSessionStatusChanged
+	}
+
+	return nil
+}
+
+func (db *DB) SetSessionRSVPMessage(sessionID int64, messageID int) error {
+	_, err := db.Exec(`
+		UPDATE sessions
+		SET rsvp_message_id = $1,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`, messageID, sessionID)
+
+	return err
+}
+
+// RSVP operations
+
+// SetRSVP records a member's answer to a session poll. Asking for a spot in
+// a full session puts the member on the waitlist instead, and giving up a
+// spot promotes the longest-waiting member. The session row is locked for
+// the duration so concurrent answers cannot overbook it.
+func (db *DB) SetRSVP(sessionID, userID int64, status models.RSVPStatus) (*models.RSVPChange, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var capacity int
+	err = tx.QueryRow(`
+		SELECT capacity FROM sessions WHERE id = $1 AND status = 'scheduled' FOR UPDATE
+	`, sessionID).Scan(&capacity)
+	if err == sql.ErrNoRows {
+		return nil, ErrSessionClosed
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock session: %w", err)
+	}
+
+	var previous models.RSVPStatus
+	err = tx.QueryRow(`
+		SELECT status FROM session_rsvps WHERE session_id = $1 AND user_id = $2
+	`, sessionID, userID).Scan(&previous)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get rsvp: %w", err)
+	}
+
+	var taken int
+	if status == models.RSVPIn {
+		err = tx.QueryRow(`
+			SELECT COUNT(*) FROM session_rsvps WHERE session_id = $1 AND status = 'in'
+		`, sessionID).Scan(&taken)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count rsvps: %w", err)
+		}
+	}
+
+	change := &models.RSVPChange{Status: rsvpStatus(previous, status, taken, capacity)}
+
+	if change.Status != previous {
+		_, err = tx.Exec(`
+			INSERT INTO session_rsvps (session_id, user_id, status)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (session_id, user_id) DO UPDATE
+			SET status = EXCLUDED.status,
+			    updated_at = CURRENT_TIMESTAMP
+		`, sessionID, userID, change.Status)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save rsvp: %w", err)
+		}
+	}
+
+	if previous == models.RSVPIn && change.Status != models.RSVPIn {
+		waitlist, err := waitlistTx(tx, sessionID)
+		if err != nil {
+			return nil, err
+		}
+		if next, ok := nextWaitlisted(waitlist); ok {
+			_, err = tx.Exec(`
+				UPDATE session_rsvps
+				SET status = 'in',
+				    updated_at = CURRENT_TIMESTAMP
+				WHERE id = $1
+			`, next.id)
+			if err != nil {
+				return nil, fmt.Errorf("failed to promote waitlist: %w", err)
+			}
+			change.Promoted = append(change.Promoted, next.userID)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit rsvp: %w", err)
+	}
+
+	return change, nil
+}
+
+// rsvpStatus decides where an answer puts a member. Asking for a spot keeps
+// the spot or waitlist place the member already holds; otherwise a session
+// with taken of its capacity spots filled puts them on the waitlist. A
+// capacity of 0 is unlimited.
+func rsvpStatus(previous, requested models.RSVPStatus, taken, capacity int) models.RSVPStatus {
+	if requested != models.RSVPIn {
+		return requested
+	}
+	if previous == models.RSVPIn || previous == models.RSVPWaitlist {
+		return previous
+	}
+	if capacity > 0 && taken >= capacity {
+		return models.RSVPWaitlist
+	}
+	return models.RSVPIn
+}
+
+// waitlistEntry is a member waiting for a spot in a session.
+type waitlistEntry struct {
+	id       int64
+	userID   int64
+	joinedAt time.Time
+}
+
+// waitlistTx locks and returns the waitlist of a session.
+func waitlistTx(tx *sql.Tx, sessionID int64) ([]waitlistEntry, error) {
+	rows, err := tx.Query(`
+		SELECT id, user_id, updated_at FROM session_rsvps
+		WHERE session_id = $1 AND status = 'waitlist'
+		FOR UPDATE
+	`, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get waitlist: %w", err)
+	}
+	defer rows.Close()
+
+	var waitlist []waitlistEntry
+	for rows.Next() {
+		var e waitlistEntry
+		if err := rows.Scan(&e.id, &e.userID, &e.joinedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan waitlist: %w", err)
+		}
+		waitlist = append(waitlist, e)
+	}
+
+	return waitlist, rows.Err()
+}
+
+// nextWaitlisted picks who gets a freed spot: whoever joined the waitlist
+// first, the earlier answer winning a tie. It is the order GetSessionRSVPs
+// lists the waitlist in.
+func nextWaitlisted(waitlist []waitlistEntry) (waitlistEntry, bool) {
+	if len(waitlist) == 0 {
+		return waitlistEntry{}, false
+	}
+
+	next := waitlist[0]
+	for _, e := range waitlist[1:] {
+		if e.joinedAt.Before(next.joinedAt) || (e.joinedAt.Equal(next.joinedAt) && e.id < next.id) {
+			next = e
+		}
+	}
+	return next, true
+}
+
+// GetSessionRSVPs returns the answers to a session poll in the order they
+// were given, which is also the waitlist order.
+func (db *DB) GetSessionRSVPs(sessionID int64) ([]models.RSVP, error) {
+	rows, err := db.Query(`
+		SELECT r.session_id, r.user_id, COALESCE(ug.name, u.first_name, ''), r.status, r.updated_at
+		FROM session_rsvps r
+		JOIN sessions s ON s.id = r.session_id
+		JOIN users u ON u.id = r.user_id
+		LEFT JOIN user_groups ug ON ug.user_id = r.user_id AND ug.group_id = s.group_id
+		WHERE r.session_id = $1
+		ORDER BY r.updated_at, r.id
+	`, sessionID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rsvps []models.RSVP
+	for rows.Next() {
+		var r models.RSVP
+		if err := rows.Scan(&r.SessionID, &r.UserID, &r.Name, &r.Status, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		rsvps = append(rsvps, r)
+	}
+
+	return rsvps, rows.Err()
+}
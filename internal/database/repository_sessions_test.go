@@ -0,0 +1,67 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"futsal-bot/internal/models"
+)
+
+func TestRSVPStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		previous  models.RSVPStatus
+		requested models.RSVPStatus
+		taken     int
+		capacity  int
+		want      models.RSVPStatus
+	}{
+		{"spot left", "", models.RSVPIn, 9, 10, models.RSVPIn},
+		{"full session", "", models.RSVPIn, 10, 10, models.RSVPWaitlist},
+		{"unlimited", models.RSVPMaybe, models.RSVPIn, 50, 0, models.RSVPIn},
+		{"keeps the spot", models.RSVPIn, models.RSVPIn, 10, 10, models.RSVPIn},
+		{"keeps the queue place", models.RSVPWaitlist, models.RSVPIn, 9, 10, models.RSVPWaitlist},
+		{"leaving the waitlist", models.RSVPWaitlist, models.RSVPOut, 10, 10, models.RSVPOut},
+		{"maybe needs no spot", "", models.RSVPMaybe, 10, 10, models.RSVPMaybe},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rsvpStatus(tt.previous, tt.requested, tt.taken, tt.capacity)
+			if got != tt.want {
+				t.Errorf("rsvpStatus(%q, %q, %d, %d) = %q, want %q",
+					tt.previous, tt.requested, tt.taken, tt.capacity, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextWaitlisted(t *testing.T) {
+	at := time.Date(2026, 3, 21, 18, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		waitlist []waitlistEntry
+		want     int64
+	}{
+		{"nobody waiting", nil, 0},
+		{"longest waiting", []waitlistEntry{
+			{id: 1, userID: 10, joinedAt: at.Add(time.Minute)},
+			{id: 2, userID: 20, joinedAt: at},
+			{id: 3, userID: 30, joinedAt: at.Add(time.Hour)},
+		}, 20},
+		{"earlier answer breaks a tie", []waitlistEntry{
+			{id: 5, userID: 50, joinedAt: at},
+			{id: 4, userID: 40, joinedAt: at},
+		}, 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, ok := nextWaitlisted(tt.waitlist)
+			if ok != (tt.want != 0) || next.userID != tt.want {
+				t.Errorf("nextWaitlisted = user %d (%v), want user %d", next.userID, ok, tt.want)
+			}
+		})
+	}
+}
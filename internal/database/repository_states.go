@@ -0,0 +1,113 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"futsal-bot/internal/models"
+)
+
+// Conversation state operations. Keyed by Telegram user ID, chat ID and flow
+// name, since a flow can start before the user has a row in users.
+
+func scanUserState(row rowScanner) (*models.UserState, error) {
+	var state models.UserState
+	var data []byte
+	err := row.Scan(&state.UserID, &state.ChatID, &state.Flow, &state.State, &data, &state.LastUpdated)
+	if err != nil {
+		return nil, err
+	}
+
+	state.Data, err = models.NewFlowData(state.Flow)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state.Data); err != nil {
+		return nil, fmt.Errorf("failed to decode %s state: %w", state.Flow, err)
+	}
+
+	return &state, nil
+}
+
+// GetUserState returns one flow of a user in a chat, or nil when that flow
+// is not in progress.
+func (db *DB) GetUserState(telegramID, chatID int64, flow string) (*models.UserState, error) {
+	state, err := scanUserState(db.QueryRow(`
+		SELECT telegram_id, chat_id, flow, state, data, updated_at
+		FROM user_states
+		WHERE telegram_id = $1 AND chat_id = $2 AND flow = $3
+	`, telegramID, chatID, flow))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return state, err
+}
+
+// GetLatestUserState returns the most recently updated flow of a user in a
+// chat, or nil when none is in progress.
+func (db *DB) GetLatestUserState(telegramID, chatID int64) (*models.UserState, error) {
+	state, err := scanUserState(db.QueryRow(`
+		SELECT telegram_id, chat_id, flow, state, data, updated_at
+		FROM user_states
+		WHERE telegram_id = $1 AND chat_id = $2
+		ORDER BY updated_at DESC
+		LIMIT 1
+	`, telegramID, chatID))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return state, err
+}
+
+func (db *DB) SaveUserState(state *models.UserState) error {
+	data, err := json.Marshal(state.Data)
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO user_states (telegram_id, chat_id, flow, state, data, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (telegram_id, chat_id, flow) DO UPDATE
+		SET state = $4, data = $5, updated_at = $6
+	`, state.UserID, state.ChatID, state.Flow, state.State, data, state.LastUpdated)
+
+	if err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+
+	return nil
+}
+
+func (db *DB) DeleteUserState(telegramID, chatID int64, flow string) error {
+	_, err := db.Exec(`
+		DELETE FROM user_states WHERE telegram_id = $1 AND chat_id = $2 AND flow = $3
+	`, telegramID, chatID, flow)
+	return err
+}
+
+// DeleteUserStates removes every flow of a user in a chat and returns how
+// many were removed.
+func (db *DB) DeleteUserStates(telegramID, chatID int64) (int64, error) {
+	result, err := db.Exec(`
+		DELETE FROM user_states WHERE telegram_id = $1 AND chat_id = $2
+	`, telegramID, chatID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete states: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// DeleteStaleUserStates removes states last updated before the given time and
+// returns how many were removed.
+func (db *DB) DeleteStaleUserStates(before time.Time) (int64, error) {
+	result, err := db.Exec(`DELETE FROM user_states WHERE updated_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete stale states: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,404 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"futsal-bot/internal/models"
+)
+
+// ErrTierInUse is returned when deleting a pricing tier members still have.
+var ErrTierInUse = errors.New("pricing tier is in use")
+
+// ErrRateBackdated is returned when a rate would start on a past day the
+// tier already has a rate for. Only days before a tier's first rate, which no
+// charge can have used, may be given a rate in hindsight.
+var ErrRateBackdated = errors.New("rate would change past charges")
+
+const dateLayout = "2006-01-02"
+
+// today is the current date in the bot's time zone.
+func today() string {
+	return time.Now().In(time.Local).Format(dateLayout)
+}
+
+// tierRateAt is the SQL for the rate of the tier aliased t on the day bound
+// to the given placeholder: the latest version from on or before that day.
+// It is NULL for days before the tier's first rate takes effect.
+func tierRateAt(day string) string {
+	return `(SELECT r.rate FROM tier_rates r WHERE r.tier_id = t.id AND r.effective_from <= ` + day + ` ORDER BY r.effective_from DESC LIMIT 1)`
+}
+
+// defaultTierID is the SQL for the tier of group $2 a member gets when they
+// don't pick one: the full-fare tier, or else the first one.
+const defaultTierID = `SELECT id FROM pricing_tiers WHERE group_id = $2 ORDER BY weight = 1 DESC, position, id LIMIT 1`
+
+// nullFloat turns a nullable number into an audit field value.
+func nullFloat(n sql.NullFloat64) interface{} {
+	if !n.Valid {
+		return nil
+	}
+	return n.Float64
+}
+
+// tierColumns selects a tier aliased t with its rate on the given day, zero
+// while it has none yet.
+func tierColumns(day string) string {
+	return `t.id, t.group_id, t.name, COALESCE(` + tierRateAt(day) + `, 0), t.weight, t.position, t.created_at, t.updated_at`
+}
+
+func scanPricingTier(row rowScanner) (*models.PricingTier, error) {
+	var t models.PricingTier
+	err := row.Scan(&t.ID, &t.GroupID, &t.Name, &t.RatePerSession, &t.Weight, &t.Position, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Pricing tier operations
+
+// GetPricingTiers returns the pricing tiers of a group in display order.
+func (db *DB) GetPricingTiers(groupID int64) ([]models.PricingTier, error) {
+	rows, err := db.Query(`
+		SELECT `+tierColumns("$2::date")+`
+		FROM pricing_tiers t
+		WHERE t.group_id = $1
+		ORDER BY t.position, t.id
+	`, groupID, today())
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tiers []models.PricingTier
+	for rows.Next() {
+		t, err := scanPricingTier(rows)
+		if err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, *t)
+	}
+
+	return tiers, rows.Err()
+}
+
+func (db *DB) GetPricingTier(tierID int64) (*models.PricingTier, error) {
+	return scanPricingTier(db.QueryRow(`
+		SELECT `+tierColumns("$2::date")+`
+		FROM pricing_tiers t
+		WHERE t.id = $1
+	`, tierID, today()))
+}
+
+// CreatePricingTier adds a tier at the end of the group's list.
+func (db *DB) CreatePricingTier(groupID int64, name string, actorID int64) (*models.PricingTier, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	tier, err := scanPricingTier(tx.QueryRow(`
+		INSERT INTO pricing_tiers AS t (group_id, name, position)
+		VALUES ($1, $2, (SELECT COALESCE(MAX(position), 0) + 1 FROM pricing_tiers WHERE group_id = $1))
+		RETURNING `+tierColumns("$3::date"),
+		groupID, name, today(),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pricing tier: %w", err)
+	}
+
+	err = recordAuditTx(tx, auditRecord{
+		GroupID: groupID, ActorID: actorID, Action: models.AuditTierCreate, EntityID: tier.ID,
+		After: fields{"name": tier.Name, "rate": tier.RatePerSession},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return tier, nil
+}
+
+// lockPricingTierTx loads a tier for update so its old values can be audited.
+func lockPricingTierTx(tx *sql.Tx, tierID int64) (*models.PricingTier, error) {
+	return scanPricingTier(tx.QueryRow(`
+		SELECT `+tierColumns("$2::date")+`
+		FROM pricing_tiers t
+		WHERE t.id = $1
+		FOR UPDATE
+	`, tierID, today()))
+}
+
+func (db *DB) RenamePricingTier(tierID int64, name string, actorID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	tier, err := lockPricingTierTx(tx, tierID)
+	if err != nil {
+		return fmt.Errorf("failed to rename pricing tier: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE pricing_tiers
+		SET name = $1,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`, name, tierID)
+	if err != nil {
+		return fmt.Errorf("failed to rename pricing tier: %w", err)
+	}
+
+	err = recordAuditTx(tx, auditRecord{
+		GroupID: tier.GroupID, ActorID: actorID, Action: models.AuditTierRename, EntityID: tierID,
+		Before: fields{"name": tier.Name}, After: fields{"name": name},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// SetTierRate makes rate the tier's rate for sessions held from the given day
+// on, replacing a version that already starts that day. Charges already
+// posted keep the rate they were made with. A past day is only accepted
+// before the tier's first rate; otherwise ErrRateBackdated is returned.
+func (db *DB) SetTierRate(tierID int64, rate float64, effectiveFrom time.Time, actorID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	day := effectiveFrom.Format(dateLayout)
+
+	var groupID int64
+	var name string
+	var old sql.NullFloat64
+	err = tx.QueryRow(`
+		SELECT t.group_id, t.name, `+tierRateAt("$2::date")+`
+		FROM pricing_tiers t
+		WHERE t.id = $1
+		FOR UPDATE
+	`, tierID, day).Scan(&groupID, &name, &old)
+	if err != nil {
+		return fmt.Errorf("failed to set tier rate: %w", err)
+	}
+	if day < today() && old.Valid {
+		return ErrRateBackdated
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO tier_rates (tier_id, rate, effective_from, created_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tier_id, effective_from) DO UPDATE
+		SET rate = EXCLUDED.rate,
+		    created_by = EXCLUDED.created_by,
+		    created_at = CURRENT_TIMESTAMP
+	`, tierID, rate, day, nullID(actorID))
+	if err != nil {
+		return fmt.Errorf("failed to set tier rate: %w", err)
+	}
+
+	_, err = tx.Exec(`UPDATE pricing_tiers SET updated_at = CURRENT_TIMESTAMP WHERE id = $1`, tierID)
+	if err != nil {
+		return fmt.Errorf("failed to set tier rate: %w", err)
+	}
+
+	err = recordAuditTx(tx, auditRecord{
+		GroupID: groupID, ActorID: actorID, Action: models.AuditTierRate, EntityID: tierID,
+		Before: fields{"name": name, "rate": nullFloat(old)},
+		After:  fields{"name": name, "rate": rate, "effective_from": day},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// SetTierWeight sets the share of a split venue cost the tier pays.
+func (db *DB) SetTierWeight(tierID int64, weight float64, actorID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	tier, err := lockPricingTierTx(tx, tierID)
+	if err != nil {
+		return fmt.Errorf("failed to set tier weight: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE pricing_tiers
+		SET weight = $1,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`, weight, tierID)
+	if err != nil {
+		return fmt.Errorf("failed to set tier weight: %w", err)
+	}
+
+	err = recordAuditTx(tx, auditRecord{
+		GroupID: tier.GroupID, ActorID: actorID, Action: models.AuditTierWeight, EntityID: tierID,
+		Before: fields{"name": tier.Name, "weight": tier.Weight},
+		After:  fields{"name": tier.Name, "weight": weight},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+const tierRateColumns = `id, tier_id, rate, effective_from, created_by, created_at`
+
+func scanTierRate(row rowScanner) (*models.TierRate, error) {
+	var r models.TierRate
+	err := row.Scan(&r.ID, &r.TierID, &r.Rate, &r.EffectiveFrom, &r.CreatedBy, &r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// GetTierRates returns every version of a tier's rate, oldest first.
+func (db *DB) GetTierRates(tierID int64) ([]models.TierRate, error) {
+	rows, err := db.Query(`
+		SELECT `+tierRateColumns+`
+		FROM tier_rates
+		WHERE tier_id = $1
+		ORDER BY effective_from
+	`, tierID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []models.TierRate
+	for rows.Next() {
+		r, err := scanTierRate(rows)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, *r)
+	}
+
+	return rates, rows.Err()
+}
+
+func (db *DB) GetTierRate(rateID int64) (*models.TierRate, error) {
+	return scanTierRate(db.QueryRow(`
+		SELECT `+tierRateColumns+`
+		FROM tier_rates
+		WHERE id = $1
+	`, rateID))
+}
+
+// CancelTierRate removes a rate change scheduled for a future day. It
+// returns sql.ErrNoRows when the version is gone or already in effect.
+func (db *DB) CancelTierRate(rateID, actorID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var groupID, tierID int64
+	var name string
+	var rate float64
+	var effectiveFrom time.Time
+	err = tx.QueryRow(`
+		DELETE FROM tier_rates r
+		USING pricing_tiers t
+		WHERE r.id = $1 AND r.effective_from > $2::date AND t.id = r.tier_id
+		RETURNING t.group_id, t.id, t.name, r.rate, r.effective_from
+	`, rateID, today()).Scan(&groupID, &tierID, &name, &rate, &effectiveFrom)
+	if err != nil {
+		return err
+	}
+
+	err = recordAuditTx(tx, auditRecord{
+		GroupID: groupID, ActorID: actorID, Action: models.AuditTierRateCancel, EntityID: tierID,
+		Before: fields{"name": name, "rate": rate, "effective_from": effectiveFrom.Format(dateLayout)},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// DeletePricingTier removes a tier nobody is on. It returns ErrTierInUse
+// while any membership, archived ones included, still points at it.
+func (db *DB) DeletePricingTier(tierID, actorID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	tier, err := lockPricingTierTx(tx, tierID)
+	if err != nil {
+		return fmt.Errorf("failed to check pricing tier: %w", err)
+	}
+
+	var inUse bool
+	err = tx.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM user_groups WHERE tier_id = $1)
+	`, tierID).Scan(&inUse)
+	if err != nil {
+		return fmt.Errorf("failed to check pricing tier: %w", err)
+	}
+	if inUse {
+		return ErrTierInUse
+	}
+
+	_, err = tx.Exec(`DELETE FROM pricing_tiers WHERE id = $1`, tierID)
+	if err != nil {
+		return fmt.Errorf("failed to delete pricing tier: %w", err)
+	}
+
+	err = recordAuditTx(tx, auditRecord{
+		GroupID: tier.GroupID, ActorID: actorID, Action: models.AuditTierDelete, EntityID: tierID,
+		Before: fields{"name": tier.Name, "rate": tier.RatePerSession},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package handlers_test
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAttendanceIsChargedAtTheTierRate(t *testing.T) {
+	h := newHarness(t)
+	group, admin, player := h.setup("بزرگسال")
+
+	h.setRate(admin, "بزرگسال", 150000)
+
+	h.sendGroup(group, admin, "/attendance @ali")
+	h.expect(group.ID, "✅ حضور و غیاب ثبت شد.", "• Ali")
+	h.sendGroup(group, admin, "/attendance @ali")
+	h.expect(group.ID, "✅ حضور و غیاب ثبت شد.")
+
+	h.press(player, h.last(player.ID), "💰 صورتحساب")
+	invoice := h.expect(player.ID,
+		"نرخ فعلی هر جلسه: 150000 تومان",
+		"تعداد جلسات: 2",
+		"مجموع هزینه جلسات: 300000 تومان",
+		"بدهی باقیمانده: 300000 تومان",
+	)
+	if n := strings.Count(invoice.Text, ": 150000 تومان"); n != 3 {
+		t.Errorf("invoice lists %d amounts of 150000, want the rate and 2 sessions:\n%s", n, invoice.Text)
+	}
+}
+
+func TestDiscountIsTakenOffEveryCharge(t *testing.T) {
+	h := newHarness(t)
+	group, admin, player := h.setup("دانشجو")
+	h.setRate(admin, "دانشجو", 100000)
+
+	h.sendPrivate(admin, "/start")
+	accounts := h.press(admin, h.last(admin.ID), "✅ تسویه حساب کاربر")
+	account := h.press(admin, accounts, "Ali - ")
+	discounts := h.press(admin, account, "🎁 تخفیف و معافیت")
+	h.press(admin, discounts, "٪ تخفیف درصدی")
+	h.sendPrivate(admin, "30")
+	h.press(admin, h.last(admin.ID), "🔁 همه جلسات")
+	h.expect(player.ID, "🎁 در گروه Futsal برای شما ثبت شد")
+
+	h.sendGroup(group, admin, "/attendance @ali")
+	h.sendGroup(group, admin, "/attendance @ali")
+
+	h.sendPrivate(player, "/start")
+	h.press(player, h.last(player.ID), "💰 صورتحساب")
+	h.expect(player.ID,
+		"تعداد جلسات: 2",
+		"مجموع هزینه جلسات: 200000 تومان",
+		"مجموع تخفیف و معافیت: 60000 تومان",
+		"بدهی باقیمانده: 140000 تومان",
+		": 70000 تومان (با 30000 تومان تخفیف)",
+	)
+}
+
+func TestOnlyStaffCanTakeAttendance(t *testing.T) {
+	h := newHarness(t)
+	group, _, player := h.setup("بزرگسال")
+
+	h.sendGroup(group, player, "/attendance @ali")
+	h.expect(group.ID, "فقط ادمین‌ها می‌توانند حضور و غیاب ثبت کنند.")
+
+	h.sendPrivate(player, "/start")
+	h.press(player, h.last(player.ID), "💰 صورتحساب")
+	h.expect(player.ID, "تعداد جلسات: 0", "حساب تسویه است ✅")
+}
+
+func TestConfirmingAChecklistTwiceChargesOnce(t *testing.T) {
+	h := newHarness(t)
+	group, admin, player := h.setup("بزرگسال")
+	h.setRate(admin, "بزرگسال", 150000)
+
+	h.sendGroup(group, admin, "/attendance")
+	checklist := h.press(admin, h.last(group.ID), "⬜️ Ali")
+	h.press(admin, checklist, "✔️ ثبت")
+	h.expect(group.ID, "✅ حضور و غیاب ثبت شد.", "• Ali")
+
+	// A second press on the same checklist, e.g. a double tap or a retry
+	h.press(admin, checklist, "✔️ ثبت")
+	h.expect(group.ID, "قبلا ثبت شده")
+
+	h.sendPrivate(player, "/start")
+	h.press(player, h.last(player.ID), "💰 صورتحساب")
+	h.expect(player.ID, "تعداد جلسات: 1", "بدهی باقیمانده: 150000 تومان")
+}
+
+func TestSyncKeepsPendingInvitees(t *testing.T) {
+	h := newHarness(t)
+	group, admin, _ := h.setup("بزرگسال")
+	invitee := newUser(300, "reza", "Reza")
+
+	token := h.invite(admin)
+
+	// The invitee registers before joining the group chat
+	h.sendPrivate(invitee, "/start "+token)
+	h.expect(invitee.ID, "شما به گروه Futsal دعوت شده‌اید")
+	h.sendPrivate(invitee, "Reza")
+	h.press(invitee, h.last(invitee.ID), "👤 بزرگسال")
+	h.expect(invitee.ID, "درخواست عضویت شما ثبت شد")
+
+	h.sendGroup(group, admin, "/sync")
+	h.expect(group.ID, "همه عضویت‌ها با گروه هماهنگ است")
+
+	h.approve(admin)
+	h.expect(invitee.ID, "عضویت شما در گروه Futsal تایید شد")
+}
+
+func TestPaymentRejectsAmountsThatArentMoney(t *testing.T) {
+	h := newHarness(t)
+	_, admin, _ := h.setup("بزرگسال")
+
+	h.sendPrivate(admin, "/start")
+	accounts := h.press(admin, h.last(admin.ID), "✅ تسویه حساب کاربر")
+	account := h.press(admin, accounts, "Ali - ")
+	h.press(admin, account, "💵 ثبت پرداخت")
+
+	for _, amount := range []string{"NaN", "Inf", "1e400", "99999999999", "-5000", "0"} {
+		h.sendPrivate(admin, amount)
+		h.expect(admin.ID, "لطفا یک مبلغ معتبر وارد کنید")
+	}
+
+	h.sendPrivate(admin, "۵۰۰۰۰")
+	h.expect(admin.ID, "مبلغ: 50000 تومان")
+	note := h.press(admin, h.last(admin.ID), "⏭ نامشخص")
+	h.press(admin, note, "⏭ بدون توضیح")
+	h.expect(admin.ID, "✅ پرداخت ثبت شد.", "کاربر: Ali", "مبلغ: 50000 تومان")
+}
+
+func TestRevertedAttendanceIsNotCharged(t *testing.T) {
+	h := newHarness(t)
+	group, admin, player := h.setup("بزرگسال")
+	h.setRate(admin, "بزرگسال", 150000)
+
+	h.sendGroup(group, admin, "/attendance @ali")
+	h.expect(group.ID, "✅ حضور و غیاب ثبت شد.")
+	h.sendGroup(group, player, "/revert")
+	h.expect(group.ID, "فقط ادمین‌ها می‌توانند حضور و غیاب را بازگردانی کنند.")
+	h.sendGroup(group, admin, "/revert")
+	h.expect(group.ID, "↩️ حضور و غیاب", "• Ali")
+
+	h.sendPrivate(player, "/start")
+	h.press(player, h.last(player.ID), "💰 صورتحساب")
+	h.expect(player.ID, "تعداد جلسات: 0", "حساب تسویه است ✅")
+}
+
+func TestAttendanceWaitsForTheTierRate(t *testing.T) {
+	h := newHarness(t)
+	group, admin, player := h.setup("بزرگسال")
+
+	h.sendGroup(group, admin, "/attendance @ali")
+	h.expect(group.ID, "هنوز نرخی ندارد", "هیچ تغییری اعمال نشد")
+
+	h.sendPrivate(player, "/start")
+	h.press(player, h.last(player.ID), "💰 صورتحساب")
+	h.expect(player.ID, "تعداد جلسات: 0")
+}
+
+func TestAChatAdminAddingTheBotToAnOwnerlessGroupBecomesOwner(t *testing.T) {
+	h := newHarness(t)
+
+	group := newGroupChat(-1001, "Futsal")
+	admin := newUser(100, "sara", "Sara")
+	other := newUser(101, "reza", "Reza")
+	member := newUser(200, "ali", "Ali")
+
+	owners := func() int {
+		n := 0
+		for _, m := range h.fake.Messages(group.ID) {
+			if strings.Contains(m.Text, "مالک این گروه شد") {
+				n++
+			}
+		}
+		return n
+	}
+
+	// A plain member adding the bot leaves the group without an owner
+	h.addBotAs(group, member, "member")
+	if n := owners(); n != 0 {
+		t.Fatalf("group got %d owners from a plain member", n)
+	}
+
+	// The chat's creator re-adding it claims the ownerless group
+	h.addBotAs(group, admin, "creator")
+	if n := owners(); n != 1 {
+		t.Fatalf("got %d ownership messages, want 1", n)
+	}
+
+	// Another chat admin re-adding it can't take the group over
+	h.addBotAs(group, other, "administrator")
+	if n := owners(); n != 1 {
+		t.Fatalf("got %d ownership messages, want 1", n)
+	}
+	h.sendGroup(group, other, "/attendance @ali")
+	h.expect(group.ID, "فقط ادمین‌ها می‌توانند حضور و غیاب ثبت کنند.")
+}
@@ -1,17 +1,53 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"futsal-bot/internal/bot"
+	"futsal-bot/internal/database"
 	"futsal-bot/internal/models"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"go.uber.org/zap"
 )
 
+// maxInvoiceSessions caps how many session dates an invoice lists.
+const maxInvoiceSessions = 10
+
+// maxRate is the largest rate in whole toman the DECIMAL(10, 2) rate columns
+// hold.
+const maxRate = 99999999
+
+// HandleUpdate routes one update to its handler. Polling and the webhook
+// server both feed updates through it.
+func HandleUpdate(b *bot.Bot, update tgbotapi.Update) {
+	if update.Message != nil {
+		if update.Message.Chat.IsPrivate() {
+			if update.Message.IsCommand() {
+				switch update.Message.Command() {
+				case "start":
+					HandleStart(b, update.Message)
+				case "cancel":
+					HandleCancel(b, update.Message)
+				default:
+					b.SendMessage(update.Message.Chat.ID,
+						"دستور نامعتبر. از /start استفاده کنید.", nil)
+				}
+			} else {
+				HandleMessage(b, update.Message)
+			}
+		} else {
+			HandleGroupMessage(b, update.Message)
+		}
+	} else if update.CallbackQuery != nil {
+		HandleCallbackQuery(b, update.CallbackQuery)
+	}
+}
+
 func HandleStart(b *bot.Bot, message *tgbotapi.Message) {
 	userID := message.From.ID
 	chatID := message.Chat.ID
@@ -31,77 +67,164 @@ func HandleStart(b *bot.Bot, message *tgbotapi.Message) {
 		return
 	}
 
-	// Check if user is default admin
-	isDefaultAdmin := b.IsDefaultAdmin(userID)
+	// /start <token> comes from an invite link
+	if token := strings.TrimSpace(message.CommandArguments()); token != "" {
+		handleInviteStart(b, message, user, token)
+		return
+	}
 
-	// Get all groups where bot is member
-	allGroups, err := b.DB.GetAllGroups()
+	groups, err := accessibleGroups(b, user, userID)
 	if err != nil {
-		zap.L().Error("Error getting groups", zap.Error(err))
+		zap.L().Error("Error getting groups", zap.Error(err), zap.Int64("user_id", user.ID))
+		b.SendMessage(chatID, "خطا در دریافت گروه‌ها. لطفا دوباره تلاش کنید.", nil)
+		return
 	}
 
-	if len(allGroups) == 0 && !isDefaultAdmin {
-		b.SendMessage(chatID, "ربات در هیچ گروهی عضو نیست. لطفا ابتدا ربات را به یک گروه اضافه کنید.", nil)
+	if len(groups) == 0 {
+		b.SendMessage(chatID, "شما عضو هیچ گروهی نیستید که ربات در آن فعال باشد. ابتدا عضو گروه شوید یا ربات را به گروه اضافه کنید.", nil)
 		return
 	}
 
-	// For simplicity, show first group
-	var groupID int64
-	if len(allGroups) > 0 {
-		groupID = allGroups[0].ID
-	}
+	welcomeText := fmt.Sprintf("سلام %s! به ربات مدیریت فوتسال خوش آمدید.", message.From.FirstName)
 
-	// Show message about available groups if multiple exist
-	if len(allGroups) > 1 {
-		groupNames := make([]string, 0)
-		for _, g := range allGroups {
-			groupNames = append(groupNames, g.Title)
+	// Reopen the group picked last time, or the only one there is
+	var group *models.Group
+	for i, g := range groups {
+		if (user.CurrentGroupID != nil && g.ID == *user.CurrentGroupID) || len(groups) == 1 {
+			group = &groups[i]
+			break
 		}
-		b.SendMessage(chatID,
-			fmt.Sprintf("ربات در %d گروه عضو است: %s\n\nدر حال حاضر گروه اول نمایش داده می‌شود.",
-				len(allGroups), strings.Join(groupNames, "، ")), nil)
 	}
 
-	isAdmin := isDefaultAdmin
-	if !isDefaultAdmin && groupID > 0 {
-		isAdmin, _ = b.DB.IsUserAdminInGroup(user.ID, groupID)
+	if group == nil {
+		keyboard := b.GroupPickerKeyboard(groups, 0)
+		b.SendMessage(chatID, welcomeText+"\n\nگروه مورد نظر را انتخاب کنید:", keyboard)
+		return
 	}
 
-	welcomeText := fmt.Sprintf("سلام %s! به ربات مدیریت فوتسال خوش آمدید.", message.From.FirstName)
-	keyboard := b.MainMenuKeyboard(user.ID, groupID, isAdmin)
+	if user.CurrentGroupID == nil || *user.CurrentGroupID != group.ID {
+		if err := b.DB.SetCurrentGroup(user.ID, group.ID); err != nil {
+			zap.L().Error("Error setting current group", zap.Error(err), zap.Int64("user_id", user.ID))
+		}
+	}
 
-	b.SendMessage(chatID, welcomeText, keyboard)
+	keyboard := b.MainMenuKeyboard(user.ID, group.ID, menuPermission(b, userID, group.ID))
+	b.SendMessage(chatID, welcomeText+"\n\n"+mainMenuText(group), keyboard)
 }
 
 func HandleMessage(b *bot.Bot, message *tgbotapi.Message) {
-	// Check if user has a state
-	state := b.GetState(message.From.ID)
+	// Free text goes to the flow the user touched last in this chat
+	state := b.ActiveState(message.From.ID, message.Chat.ID)
 	if state == nil {
+		b.SendMessage(message.Chat.ID,
+			"عملیات فعالی وجود ندارد یا عملیات قبلی به دلیل عدم فعالیت منقضی شده است. برای شروع از /start استفاده کنید.", nil)
 		return
 	}
 
-	switch state.State {
-	case "awaiting_name":
-		handleNameInput(b, message, state)
-	case "awaiting_rate":
-		handleRateInput(b, message, state)
-	case "awaiting_settle_sessions":
-		handleSettleSessionsInput(b, message, state)
-	default:
-		b.ClearState(message.From.ID)
+	switch data := state.Data.(type) {
+	case *models.RegistrationFlow:
+		if state.State == "awaiting_name" {
+			handleNameInput(b, message, data)
+			return
+		}
+	case *models.RateFlow:
+		switch state.State {
+		case "awaiting_rate":
+			handleRateInput(b, message, data)
+			return
+		case "awaiting_rate_date":
+			handleRateDateInput(b, message, data)
+			return
+		}
+	case *models.TierFlow:
+		handleTierNameInput(b, message, data)
+		return
+	case *models.PaymentFlow:
+		switch state.State {
+		case "awaiting_payment_amount":
+			handlePaymentAmountInput(b, message, data)
+			return
+		case "awaiting_payment_note":
+			handlePaymentNoteInput(b, message, data)
+			return
+		}
+	case *models.ClaimFlow:
+		switch state.State {
+		case "awaiting_claim_amount":
+			handleClaimAmountInput(b, message, data)
+			return
+		case "awaiting_claim_receipt":
+			handleClaimReceiptInput(b, message, data)
+			return
+		}
+	case *models.SessionFlow:
+		switch state.State {
+		case "awaiting_session_start":
+			handleSessionStartInput(b, message, data)
+			return
+		case "awaiting_session_venue":
+			handleSessionVenueInput(b, message, data)
+			return
+		case "awaiting_session_capacity":
+			handleSessionCapacityInput(b, message, data)
+			return
+		case "awaiting_session_cost":
+			handleSessionCostInput(b, message, data)
+			return
+		}
+	case *models.WeightFlow:
+		handleWeightInput(b, message, data)
+		return
+	case *models.DiscountFlow:
+		switch state.State {
+		case "awaiting_discount_value":
+			handleDiscountValueInput(b, message, data)
+			return
+		case "awaiting_discount_period":
+			handleDiscountPeriodInput(b, message, data)
+			return
+		}
 	}
+
+	// The flow is waiting on a button press
+	b.SendMessage(message.Chat.ID, "لطفا یکی از دکمه‌ها را انتخاب کنید یا با /cancel عملیات را لغو کنید.", nil)
 }
 
-func handleNameInput(b *bot.Bot, message *tgbotapi.Message, state *models.UserState) {
+// HandleCancel aborts every flow the user has in progress in this chat.
+func HandleCancel(b *bot.Bot, message *tgbotapi.Message) {
+	if b.ClearStates(message.From.ID, message.Chat.ID) == 0 {
+		b.SendMessage(message.Chat.ID, "عملیات فعالی برای لغو وجود ندارد.", nil)
+		return
+	}
+
+	b.SendMessage(message.Chat.ID, "❌ عملیات لغو شد. برای شروع دوباره از /start استفاده کنید.", nil)
+}
+
+// flowAt returns the data of the flow a button belongs to, provided the flow
+// is still waiting on that step. Otherwise it tells the user the button has
+// expired.
+func flowAt[T models.FlowData](b *bot.Bot, callback *tgbotapi.CallbackQuery, flow, step string) (T, bool) {
+	state := b.GetState(callback.From.ID, callback.Message.Chat.ID, flow)
+	if state != nil && state.State == step {
+		if data, ok := state.Data.(T); ok {
+			return data, true
+		}
+	}
+
+	var zero T
+	b.AnswerCallbackQuery(callback.ID, "این عملیات منقضی شده است.")
+	return zero, false
+}
+
+func handleNameInput(b *bot.Bot, message *tgbotapi.Message, flow *models.RegistrationFlow) {
 	name := strings.TrimSpace(message.Text)
 	if name == "" {
 		b.SendMessage(message.Chat.ID, "لطفا یک نام معتبر وارد کنید:", nil)
 		return
 	}
 
-	// Save name in temp data
-	state.TempData["name"] = name
-	groupID := state.TempData["group_id"].(int64)
+	flow.Name = name
+	groupID := flow.GroupID
 
 	// Get or create user
 	user, err := b.DB.GetOrCreateUser(
@@ -115,121 +238,98 @@ func handleNameInput(b *bot.Bot, message *tgbotapi.Message, state *models.UserSt
 	if err != nil {
 		zap.L().Error("Error getting/creating user", zap.Error(err), zap.Int64("chat_id", message.Chat.ID))
 		b.SendMessage(message.Chat.ID, "خطا در ثبت اطلاعات. لطفا دوباره تلاش کنید.", nil)
-		b.ClearState(message.From.ID)
+		b.ClearState(message.From.ID, message.Chat.ID, models.FlowRegistration)
 		return
 	}
 
-	isAdmin := b.IsDefaultAdmin(message.From.ID)
-	if !isAdmin {
-		isAdmin, _ = b.DB.IsUserAdminInGroup(user.ID, groupID)
+	tiers, err := b.DB.GetPricingTiers(groupID)
+	if err != nil {
+		zap.L().Error("Error getting pricing tiers", zap.Error(err), zap.Int64("group_id", groupID))
+		b.SendMessage(message.Chat.ID, "خطا در دریافت نقش‌ها. لطفا دوباره تلاش کنید.", nil)
+		return
 	}
 
 	// Update state to role selection
-	state.State = "awaiting_role"
-	state.TempData["user_id"] = user.ID
-	b.SetState(message.From.ID, state.State, state.TempData)
+	flow.UserID = user.ID
+	b.SetState(message.From.ID, message.Chat.ID, "awaiting_role", flow)
 
 	// Show role selection
-	keyboard := b.RoleSelectionKeyboard(groupID, isAdmin)
+	keyboard := b.RoleSelectionKeyboard(groupID, tiers)
 	b.SendMessage(message.Chat.ID, "لطفا نقش خود را انتخاب کنید:", keyboard)
 }
 
-func handleRateInput(b *bot.Bot, message *tgbotapi.Message, state *models.UserState) {
-	rateStr := strings.TrimSpace(message.Text)
-	rate, err := strconv.ParseFloat(rateStr, 64)
-	if err != nil || rate < 0 {
+func handleRateInput(b *bot.Bot, message *tgbotapi.Message, flow *models.RateFlow) {
+	rate, err := parseNumber(message.Text)
+	if err != nil || rate < 0 || rate > maxRate {
 		b.SendMessage(message.Chat.ID, "لطفا یک عدد معتبر وارد کنید:", nil)
 		return
 	}
 
-	groupID := state.TempData["group_id"].(int64)
-	role := state.TempData["role"].(models.UserRole)
+	flow.Rate = rate
+	b.SetState(message.From.ID, message.Chat.ID, "awaiting_rate_date", flow)
 
-	err = b.DB.SetRate(groupID, role, rate)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📅 از امروز", "rate_today"),
+		),
+	)
+	b.SendMessage(message.Chat.ID,
+		fmt.Sprintf("این نرخ از چه روزی اعمال شود؟\nتاریخ را به صورت %s وارد کنید یا «از امروز» را بزنید.\n"+
+			"اولین نرخ هر نقش می‌تواند از یک روز گذشته شروع شود تا جلسات قبلی هم محاسبه شوند:",
+			time.Now().In(time.Local).AddDate(0, 1, 0).Format(rateDateLayout)),
+		keyboard)
+}
+
+func handleRateDateInput(b *bot.Bot, message *tgbotapi.Message, flow *models.RateFlow) {
+	from, err := time.ParseInLocation(rateDateLayout, strings.TrimSpace(message.Text), time.Local)
 	if err != nil {
-		zap.L().Error("Error setting rate", zap.Error(err), zap.Int64("group_id", groupID))
-		b.SendMessage(message.Chat.ID, "خطا در ثبت نرخ. لطفا دوباره تلاش کنید.", nil)
-		b.ClearState(message.From.ID)
+		b.SendMessage(message.Chat.ID, fmt.Sprintf("تاریخ نامعتبر است. لطفا به صورت %s وارد کنید:", rateDateLayout), nil)
 		return
 	}
 
-	b.ClearState(message.From.ID)
+	saveRate(b, message.From.ID, message.Chat.ID, flow, from)
+}
 
-	roleNames := map[models.UserRole]string{
-		models.RoleStudent:   "دانشجو",
-		models.RoleAdult:     "بزرگسال",
-		models.RoleHalfAdult: "نیمه بزرگسال",
+func handleRateTodayCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	flow, ok := flowAt[*models.RateFlow](b, callback, models.FlowRate, "awaiting_rate_date")
+	if !ok {
+		return
 	}
 
-	text := fmt.Sprintf("✅ نرخ برای %s به %.0f تومان تنظیم شد.", roleNames[role], rate)
-	keyboard := b.RateSettingKeyboard(groupID)
-	b.SendMessage(message.Chat.ID, text, keyboard)
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, "از امروز.", nil)
+	saveRate(b, callback.From.ID, callback.Message.Chat.ID, flow, startOfToday())
 }
 
-func handleSettleSessionsInput(b *bot.Bot, message *tgbotapi.Message, state *models.UserState) {
-	sessionsStr := strings.TrimSpace(message.Text)
-	sessions, err := strconv.Atoi(sessionsStr)
-	if err != nil || sessions <= 0 {
-		b.SendMessage(message.Chat.ID, "لطفا یک عدد معتبر وارد کنید:", nil)
+func saveRate(b *bot.Bot, telegramID, chatID int64, flow *models.RateFlow, from time.Time) {
+	groupID := flow.GroupID
+
+	tier, err := b.DB.GetPricingTier(flow.TierID)
+	if err != nil {
+		b.ClearState(telegramID, chatID, models.FlowRate)
+		b.SendMessage(chatID, "این نقش دیگر وجود ندارد.", nil)
 		return
 	}
 
-	userID := state.TempData["target_user_id"].(int64)
-	groupID := state.TempData["group_id"].(int64)
-
-	// Get user group info
-	ug, err := b.DB.GetUserGroup(userID, groupID)
+	// The flow stays open so another day can be entered
+	err = b.DB.SetTierRate(tier.ID, flow.Rate, from, flow.AdminID)
+	if errors.Is(err, database.ErrRateBackdated) {
+		b.SendMessage(chatID, "این نقش در آن روز نرخ داشته و نرخ روزهای گذشته قابل تغییر نیست؛ فقط روزهای پیش از اولین نرخ هر نقش را می‌توان از قبل تعیین کرد. لطفا امروز یا یک روز آینده را وارد کنید:", nil)
+		return
+	}
+	b.ClearState(telegramID, chatID, models.FlowRate)
 	if err != nil {
-		zap.L().Error("Error getting user group", zap.Error(err), zap.Int64("user_id", userID), zap.Int64("group_id", groupID))
-		b.SendMessage(message.Chat.ID, "خطا در دریافت اطلاعات کاربر.", nil)
-		b.ClearState(message.From.ID)
+		zap.L().Error("Error setting rate", zap.Error(err), zap.Int64("group_id", groupID), zap.Int64("tier_id", tier.ID))
+		b.SendMessage(chatID, "خطا در ثبت نرخ. لطفا دوباره تلاش کنید.", nil)
 		return
 	}
 
-	err = b.DB.SettleSessions(userID, groupID, sessions)
-	if err != nil {
-		zap.L().Error("Error settling sessions", zap.Error(err), zap.Int64("user_id", userID), zap.Int64("group_id", groupID))
-		b.SendMessage(message.Chat.ID, "خطا در تسویه حساب.", nil)
-		b.ClearState(message.From.ID)
-		return
-	}
-
-	b.ClearState(message.From.ID)
-
-	// Get updated info
-	ug, _ = b.DB.GetUserGroup(userID, groupID)
-	rate, _ := b.DB.GetRate(groupID, ug.Role)
-	remainingDebt := float64(ug.SessionsOwed) * rate
-
-	var text string
-
-	if ug.SessionsOwed > 0 {
-		text = fmt.Sprintf(
-			"✅ تسویه حساب انجام شد.\n\n"+
-				"کاربر: %s\n"+
-				"جلسات تسویه شده: %d\n"+
-				"جلسات باقیمانده: %d\n"+
-				"بدهی باقیمانده: %.0f تومان",
-			ug.Name, sessions, ug.SessionsOwed, remainingDebt,
-		)
-	} else if ug.SessionsOwed < 0 {
-		text = fmt.Sprintf(
-			"✅ تسویه حساب انجام شد.\n\n"+
-				"کاربر: %s\n"+
-				"جلسات تسویه شده: %d\n"+
-				"جلسات طلب کار: %d\n",
-			ug.Name, sessions, ug.SessionsOwed*(-1),
-		)
-	} else {
-		text = fmt.Sprintf(
-			"✅ تسویه حساب انجام شد.\n\n"+
-				"کاربر: %s\n"+
-				"جلسات تسویه شده: %d"+
-				ug.Name, sessions,
-		)
-	}
-
-	b.SendMessage(message.Chat.ID, text, nil)
+	text := fmt.Sprintf("✅ نرخ برای %s به %.0f تومان تنظیم شد.", tier.Name, flow.Rate)
+	if from.After(startOfToday()) {
+		text = fmt.Sprintf("✅ نرخ %s از %s به %.0f تومان تغییر می‌کند.", tier.Name, from.Format(rateDateLayout), flow.Rate)
+	} else if from.Before(startOfToday()) {
+		text = fmt.Sprintf("✅ نرخ %s از %s به %.0f تومان تنظیم شد.", tier.Name, from.Format(rateDateLayout), flow.Rate)
+	}
+	showTiers(b, chatID, 0, groupID, text)
 }
 
 func HandleCallbackQuery(b *bot.Bot, callback *tgbotapi.CallbackQuery) {
@@ -245,6 +345,24 @@ func HandleCallbackQuery(b *bot.Bot, callback *tgbotapi.CallbackQuery) {
 	action := parts[0]
 
 	switch action {
+	case "members":
+		handleMembersCallback(b, callback, parts)
+	case "member_ok":
+		handleMemberReviewCallback(b, callback, parts, true)
+	case "member_no":
+		handleMemberReviewCallback(b, callback, parts, false)
+	case "invite":
+		handleInviteCallback(b, callback, parts)
+	case "perms":
+		handlePermsCallback(b, callback, parts)
+	case "perm":
+		handlePermCallback(b, callback, parts)
+	case "perm_set":
+		handlePermSetCallback(b, callback, parts)
+	case "groups":
+		handleGroupsCallback(b, callback, parts)
+	case "group":
+		handleGroupCallback(b, callback, parts)
 	case "register":
 		handleRegisterCallback(b, callback, parts)
 	case "edit":
@@ -257,12 +375,76 @@ func HandleCallbackQuery(b *bot.Bot, callback *tgbotapi.CallbackQuery) {
 		handleSetRatesCallback(b, callback, parts)
 	case "setrate":
 		handleSetRateCallback(b, callback, parts)
+	case "rate_today":
+		handleRateTodayCallback(b, callback, parts)
+	case "tier_rate_del":
+		handleTierRateCancelCallback(b, callback, parts)
+	case "tier":
+		handleTierCallback(b, callback, parts)
+	case "tier_new":
+		handleTierNewCallback(b, callback, parts)
+	case "tier_rename":
+		handleTierRenameCallback(b, callback, parts)
+	case "tier_del":
+		handleTierDeleteCallback(b, callback, parts)
+	case "tier_weight":
+		handleTierWeightCallback(b, callback, parts)
+	case "pricing_mode":
+		handlePricingModeCallback(b, callback, parts)
 	case "settle":
 		handleSettleCallback(b, callback, parts)
 	case "settle_user":
 		handleSettleUserCallback(b, callback, parts)
+	case "pay":
+		handlePayCallback(b, callback, parts)
+	case "pay_method":
+		handlePayMethodCallback(b, callback, parts)
+	case "pay_note_skip":
+		handlePayNoteSkipCallback(b, callback, parts)
+	case "payments":
+		handlePaymentsCallback(b, callback, parts)
+	case "claim":
+		handleClaimCallback(b, callback, parts)
+	case "claim_skip":
+		handleClaimSkipCallback(b, callback, parts)
+	case "claim_ok":
+		handleClaimReviewCallback(b, callback, parts, true)
+	case "claim_no":
+		handleClaimReviewCallback(b, callback, parts, false)
 	case "back":
 		handleBackCallback(b, callback, parts)
+	case "sessions":
+		handleSessionsCallback(b, callback, parts)
+	case "session":
+		handleSessionCallback(b, callback, parts)
+	case "session_new":
+		handleSessionNewCallback(b, callback, parts)
+	case "session_cancel":
+		handleSessionCancelCallback(b, callback, parts)
+	case "session_cost":
+		handleSessionCostCallback(b, callback, parts)
+	case "rsvp":
+		handleRSVPCallback(b, callback, parts)
+	case "rsvp_post":
+		handleRSVPPostCallback(b, callback, parts)
+	case "att_session":
+		handleAttendanceSessionCallback(b, callback, parts)
+	case "att_toggle":
+		handleAttendanceToggleCallback(b, callback, parts)
+	case "att_confirm":
+		handleAttendanceConfirmCallback(b, callback, parts)
+	case "att_cancel":
+		handleAttendanceCancelCallback(b, callback, parts)
+	case "discounts":
+		handleDiscountsCallback(b, callback, parts)
+	case "discount_new":
+		handleDiscountNewCallback(b, callback, parts)
+	case "discount_scope":
+		handleDiscountScopeCallback(b, callback, parts)
+	case "discount_del":
+		handleDiscountRevokeCallback(b, callback, parts)
+	case "audit":
+		handleAuditCallback(b, callback, parts)
 	}
 
 	b.AnswerCallbackQuery(callback.ID, "")
@@ -278,12 +460,23 @@ func handleRegisterCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts
 		return
 	}
 
-	// Start registration process
-	tempData := map[string]interface{}{
-		"group_id": groupID,
-		"action":   "register",
+	user, err := b.DB.GetUserByTelegramID(callback.From.ID)
+	if err != nil {
+		b.SendMessage(callback.Message.Chat.ID, "خطا در دریافت اطلاعات کاربر.", nil)
+		return
+	}
+
+	group, err := b.DB.GetGroup(groupID)
+	if err != nil || !canAccessGroup(b, user, callback.From.ID, group) {
+		b.AnswerCallbackQuery(callback.ID, "شما به این گروه دسترسی ندارید.")
+		return
 	}
-	b.SetState(callback.From.ID, "awaiting_name", tempData)
+
+	// Start registration process
+	b.SetState(callback.From.ID, callback.Message.Chat.ID, "awaiting_name", &models.RegistrationFlow{
+		GroupID: groupID,
+		Edit:    false,
+	})
 
 	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID,
 		"لطفا نام خود را وارد کنید:", nil)
@@ -299,12 +492,22 @@ func handleEditCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []st
 		return
 	}
 
-	// Start edit process
-	tempData := map[string]interface{}{
-		"group_id": groupID,
-		"action":   "edit",
+	user, err := b.DB.GetUserByTelegramID(callback.From.ID)
+	if err != nil {
+		b.SendMessage(callback.Message.Chat.ID, "خطا در دریافت اطلاعات کاربر.", nil)
+		return
 	}
-	b.SetState(callback.From.ID, "awaiting_name", tempData)
+
+	if _, err := b.DB.GetUserGroup(user.ID, groupID); err != nil {
+		b.AnswerCallbackQuery(callback.ID, "شما در این گروه ثبت نام نکرده‌اید.")
+		return
+	}
+
+	// Start edit process
+	b.SetState(callback.From.ID, callback.Message.Chat.ID, "awaiting_name", &models.RegistrationFlow{
+		GroupID: groupID,
+		Edit:    true,
+	})
 
 	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID,
 		"لطفا نام جدید خود را وارد کنید:", nil)
@@ -315,40 +518,62 @@ func handleRoleCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []st
 		return
 	}
 
-	roleStr := parts[1]
 	groupID, err := strconv.ParseInt(parts[2], 10, 64)
 	if err != nil {
 		return
 	}
 
-	role := models.UserRole(roleStr)
-	state := b.GetState(callback.From.ID)
-	if state == nil {
+	flow, ok := flowAt[*models.RegistrationFlow](b, callback, models.FlowRegistration, "awaiting_role")
+	if !ok || flow.GroupID != groupID {
 		return
 	}
 
-	name := state.TempData["name"].(string)
-	userID := state.TempData["user_id"].(int64)
+	name := flow.Name
+	userID := flow.UserID
+
+	tierID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	tier, err := b.DB.GetPricingTier(tierID)
+	if err != nil || tier.GroupID != groupID {
+		b.AnswerCallbackQuery(callback.ID, "نقش نامعتبر است.")
+		return
+	}
+	roleName := tier.Name
+
+	// Those who approve registrations are trusted; everyone else waits for
+	// one of them
+	status := models.MembershipPending
+	if menuPermission(b, callback.From.ID, groupID).Can(models.CapManageMembers) {
+		status = models.MembershipActive
+	}
 
 	// Save user group
-	err = b.DB.CreateOrUpdateUserGroup(userID, groupID, role, name)
+	ug, err := b.DB.CreateOrUpdateUserGroup(userID, groupID, &tier.ID, name, status)
 	if err != nil {
 		zap.L().Error("Error creating/updating user group", zap.Error(err), zap.Int64("user_id", userID), zap.Int64("group_id", groupID))
 		b.SendMessage(callback.Message.Chat.ID, "خطا در ثبت اطلاعات.", nil)
-		b.ClearState(callback.From.ID)
+		b.ClearState(callback.From.ID, callback.Message.Chat.ID, models.FlowRegistration)
 		return
 	}
 
-	b.ClearState(callback.From.ID)
+	b.ClearState(callback.From.ID, callback.Message.Chat.ID, models.FlowRegistration)
 
-	roleNames := map[models.UserRole]string{
-		models.RoleAdmin:     "ادمین",
-		models.RoleStudent:   "دانشجو",
-		models.RoleAdult:     "بزرگسال",
-		models.RoleHalfAdult: "نیمه بزرگسال",
+	if ug.Status == models.MembershipPending {
+		text := fmt.Sprintf("📨 درخواست عضویت شما ثبت شد و پس از تایید ادمین فعال می‌شود.\n\nنام: %s\nنقش: %s", name, roleName)
+		b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, nil)
+		if !flow.Edit {
+			notifyMembershipRequest(b, ug)
+		}
+		return
 	}
 
-	text := fmt.Sprintf("✅ ثبت نام با موفقیت انجام شد!\n\nنام: %s\nنقش: %s", name, roleNames[role])
+	text := fmt.Sprintf("✅ ثبت نام با موفقیت انجام شد!\n\nنام: %s\nنقش: %s", name, roleName)
+	if flow.Edit {
+		text = fmt.Sprintf("✅ مشخصات شما به‌روز شد.\n\nنام: %s\nنقش: %s", name, roleName)
+	}
 	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, nil)
 }
 
@@ -369,31 +594,64 @@ func handleInvoiceCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts [
 	}
 
 	ug, err := b.DB.GetUserGroup(user.ID, groupID)
+	if err != nil || ug.Status != models.MembershipActive {
+		b.SendMessage(callback.Message.Chat.ID, "شما در این گروه ثبت نام نکرده‌اید یا عضویت شما هنوز تایید نشده است.", nil)
+		return
+	}
+
+	balance, err := b.DB.GetBalance(user.ID, groupID)
 	if err != nil {
-		b.SendMessage(callback.Message.Chat.ID, "شما در این گروه ثبت نام نکرده‌اید.", nil)
+		zap.L().Error("Error getting balance", zap.Error(err), zap.Int64("user_id", user.ID), zap.Int64("group_id", groupID))
+		b.SendMessage(callback.Message.Chat.ID, "خطا در دریافت صورتحساب.", nil)
 		return
 	}
 
-	rate, _ := b.DB.GetRate(groupID, ug.Role)
-	totalDebt := float64(ug.SessionsOwed) * rate
+	roleName, rate, weight := memberTier(b, ug)
 
-	roleNames := map[models.UserRole]string{
-		models.RoleAdmin:     "ادمین",
-		models.RoleStudent:   "دانشجو",
-		models.RoleAdult:     "بزرگسال",
-		models.RoleHalfAdult: "نیمه بزرگسال",
+	priceLine := fmt.Sprintf("نرخ فعلی هر جلسه: %.0f تومان", rate)
+	if group, err := b.DB.GetGroup(groupID); err == nil && group.PricingMode == models.PricingSplit {
+		priceLine = fmt.Sprintf("سهم از هزینه زمین هر جلسه: ضریب %s", formatWeight(weight))
 	}
 
 	text := fmt.Sprintf(
 		"💰 *صورتحساب*\n\n"+
 			"نام: %s\n"+
 			"نقش: %s\n"+
+			"%s\n\n"+
 			"تعداد جلسات: %d\n"+
-			"نرخ هر جلسه: %.0f تومان\n"+
-			"مجموع بدهی: %.0f تومان",
-		ug.Name, roleNames[ug.Role], ug.SessionsOwed, rate, totalDebt,
+			"مجموع هزینه جلسات: %.0f تومان\n"+
+			"%s"+
+			"مجموع پرداختی: %.0f تومان\n"+
+			"%s",
+		ug.Name, roleName, priceLine, balance.Sessions, balance.Charged, discountLine(balance.Discounts), balance.Paid, balanceLine(balance.Balance),
 	)
 
+	// List the sessions the outstanding debt comes from, at the price each was charged at
+	charges, err := b.DB.GetOutstandingCharges(user.ID, groupID)
+	if err != nil {
+		zap.L().Error("Error getting outstanding charges", zap.Error(err), zap.Int64("user_id", user.ID), zap.Int64("group_id", groupID))
+	}
+
+	if len(charges) > 0 {
+		text += "\n\n📅 *جلسات پرداخت نشده:*"
+		shown := charges
+		if len(shown) > maxInvoiceSessions {
+			shown = shown[len(shown)-maxInvoiceSessions:]
+			text += fmt.Sprintf("\n• ... و %d جلسه قدیمی‌تر", len(charges)-len(shown))
+		}
+		for _, c := range shown {
+			line := "\n• " + c.Date.In(time.Local).Format(sessionTimeLayout)
+			if c.Venue != "" {
+				line += " - " + escapeMarkdown(c.Venue)
+			}
+			line += fmt.Sprintf(": %.0f تومان", c.Amount)
+			if c.Discount > 0 {
+				line += fmt.Sprintf(" (با %.0f تومان تخفیف)", c.Discount)
+			}
+			text += line
+		}
+	}
+
 	b.SendMessageWithMarkdown(callback.Message.Chat.ID, text, nil)
 	b.AnswerCallbackQuery(callback.ID, "")
 }
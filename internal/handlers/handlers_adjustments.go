@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"futsal-bot/internal/bot"
+	"futsal-bot/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// maxDiscountSessions caps how many upcoming sessions are offered for a
+// one-off discount.
+const maxDiscountSessions = 8
+
+// discountLine is the invoice line for a member's discounts, empty when they
+// never had any.
+func discountLine(discounts float64) string {
+	if discounts == 0 {
+		return ""
+	}
+	return fmt.Sprintf("مجموع تخفیف و معافیت: %.0f تومان\n", discounts)
+}
+
+// adjustmentText describes an adjustment and the sessions it applies to.
+func adjustmentText(b *bot.Bot, a models.Adjustment) string {
+	var text string
+	switch a.Kind {
+	case models.AdjustmentPercent:
+		text = fmt.Sprintf("%s٪ تخفیف", strconv.FormatFloat(a.Value, 'f', -1, 64))
+	case models.AdjustmentFixed:
+		text = fmt.Sprintf("%.0f تومان تخفیف", a.Value)
+	default:
+		text = "معافیت از پرداخت"
+	}
+
+	switch {
+	case a.SessionID != nil:
+		if s, err := b.DB.GetSession(*a.SessionID); err == nil {
+			return text + " - فقط جلسه " + s.StartsAt.In(time.Local).Format(sessionTimeLayout)
+		}
+		return text + fmt.Sprintf(" - فقط جلسه #%d", *a.SessionID)
+	case a.StartsOn != nil && a.EndsOn != nil:
+		return text + fmt.Sprintf(" - از %s تا %s", a.StartsOn.Format(rateDateLayout), a.EndsOn.Format(rateDateLayout))
+	case a.EndsOn != nil:
+		return text + " - تا " + a.EndsOn.Format(rateDateLayout)
+	case a.StartsOn != nil:
+		return text + " - از " + a.StartsOn.Format(rateDateLayout)
+	}
+	return text + " - همه جلسات"
+}
+
+// discountMember parses the member and group of a discount button and makes
+// sure the presser manages payments in the group.
+func discountMember(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) (*models.User, *models.UserGroup, bool) {
+	if len(parts) < 3 {
+		return nil, nil, false
+	}
+
+	targetUserID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	groupID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	admin, ok := requireCapability(b, callback, groupID, models.CapManagePayments)
+	if !ok {
+		return nil, nil, false
+	}
+
+	ug, err := b.DB.GetUserGroup(targetUserID, groupID)
+	if err != nil {
+		b.AnswerCallbackQuery(callback.ID, "کاربر در این گروه ثبت نشده است.")
+		return nil, nil, false
+	}
+
+	return admin, ug, true
+}
+
+func handleDiscountsCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	_, ug, ok := discountMember(b, callback, parts)
+	if !ok {
+		return
+	}
+
+	showDiscounts(b, callback.Message.Chat.ID, callback.Message.MessageID, ug, "")
+}
+
+// showDiscounts lists the discounts of a member that still apply, with
+// buttons to revoke them or grant new ones. A zero messageID sends a new
+// message.
+func showDiscounts(b *bot.Bot, chatID int64, messageID int, ug *models.UserGroup, header string) {
+	adjustments, err := b.DB.GetActiveAdjustments(ug.UserID, ug.GroupID)
+	if err != nil {
+		zap.L().Error("Error getting adjustments", zap.Error(err), zap.Int64("user_id", ug.UserID), zap.Int64("group_id", ug.GroupID))
+		b.SendMessage(chatID, "خطا در دریافت اطلاعات.", nil)
+		return
+	}
+
+	lines := []string{fmt.Sprintf("🎁 تخفیف‌ها و معافیت‌های %s", ug.Name), ""}
+	if header != "" {
+		lines = append([]string{header, ""}, lines...)
+	}
+	if len(adjustments) == 0 {
+		lines = append(lines, "هیچ تخفیف فعالی ثبت نشده است.")
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, a := range adjustments {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, adjustmentText(b, a)))
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("❌ لغو مورد %d", i+1), fmt.Sprintf("discount_del:%d", a.ID)),
+		})
+	}
+
+	rows = append(rows,
+		[]tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("٪ تخفیف درصدی", fmt.Sprintf("discount_new:%d:%d:%s", ug.UserID, ug.GroupID, models.AdjustmentPercent)),
+			tgbotapi.NewInlineKeyboardButtonData("💵 تخفیف مبلغی", fmt.Sprintf("discount_new:%d:%d:%s", ug.UserID, ug.GroupID, models.AdjustmentFixed)),
+		},
+		[]tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("🩹 معافیت برای یک دوره", fmt.Sprintf("discount_new:%d:%d:%s", ug.UserID, ug.GroupID, models.AdjustmentExempt)),
+		},
+		[]tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", fmt.Sprintf("settle_user:%d:%d", ug.UserID, ug.GroupID)),
+		},
+	)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	if messageID == 0 {
+		b.SendMessage(chatID, strings.Join(lines, "\n"), keyboard)
+		return
+	}
+	b.EditMessage(chatID, messageID, strings.Join(lines, "\n"), &keyboard)
+}
+
+func handleDiscountNewCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 4 {
+		return
+	}
+
+	admin, ug, ok := discountMember(b, callback, parts)
+	if !ok {
+		return
+	}
+
+	flow := &models.DiscountFlow{
+		GroupID: ug.GroupID,
+		UserID:  ug.UserID,
+		AdminID: admin.ID,
+		Kind:    models.AdjustmentKind(parts[3]),
+	}
+
+	var text string
+	switch flow.Kind {
+	case models.AdjustmentPercent:
+		b.SetState(callback.From.ID, callback.Message.Chat.ID, "awaiting_discount_value", flow)
+		text = fmt.Sprintf("چند درصد از هزینه هر جلسه %s کم شود؟\nمثال: 50", ug.Name)
+	case models.AdjustmentFixed:
+		b.SetState(callback.From.ID, callback.Message.Chat.ID, "awaiting_discount_value", flow)
+		text = fmt.Sprintf("چند تومان از هزینه هر جلسه %s کم شود؟", ug.Name)
+	case models.AdjustmentExempt:
+		b.SetState(callback.From.ID, callback.Message.Chat.ID, "awaiting_discount_period", flow)
+		text = fmt.Sprintf("%s تا چه روزی از پرداخت معاف باشد؟\n"+
+			"تاریخ پایان را به صورت %s وارد کنید، یا تاریخ شروع و پایان را با فاصله:\nمثال: %s",
+			ug.Name, rateDateLayout, startOfToday().AddDate(0, 1, 0).Format(rateDateLayout))
+	default:
+		b.AnswerCallbackQuery(callback.ID, "نوع تخفیف نامعتبر است.")
+		return
+	}
+
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, nil)
+}
+
+func handleDiscountValueInput(b *bot.Bot, message *tgbotapi.Message, flow *models.DiscountFlow) {
+	value, err := parseAmount(message.Text)
+	if err != nil || (flow.Kind == models.AdjustmentPercent && value > 100) {
+		b.SendMessage(message.Chat.ID, "لطفا یک عدد معتبر وارد کنید:", nil)
+		return
+	}
+
+	flow.Value = value
+	b.SetState(message.From.ID, message.Chat.ID, "awaiting_discount_scope", flow)
+
+	rows := [][]tgbotapi.InlineKeyboardButton{{
+		tgbotapi.NewInlineKeyboardButtonData("🔁 همه جلسات", "discount_scope:all"),
+	}}
+
+	sessions, err := b.DB.GetUpcomingSessions(flow.GroupID, time.Now())
+	if err != nil {
+		zap.L().Error("Error getting upcoming sessions", zap.Error(err), zap.Int64("group_id", flow.GroupID))
+	}
+	if len(sessions) > maxDiscountSessions {
+		sessions = sessions[:maxDiscountSessions]
+	}
+	for _, s := range sessions {
+		label := "📅 فقط " + s.StartsAt.In(time.Local).Format(sessionTimeLayout)
+		if s.Venue != "" {
+			label += " - " + s.Venue
+		}
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("discount_scope:%d", s.ID)),
+		})
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	b.SendMessage(message.Chat.ID, "این تخفیف برای همه جلسات از این پس اعمال شود یا فقط یک جلسه؟", keyboard)
+}
+
+func handleDiscountScopeCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 2 {
+		return
+	}
+
+	flow, ok := flowAt[*models.DiscountFlow](b, callback, models.FlowDiscount, "awaiting_discount_scope")
+	if !ok {
+		return
+	}
+
+	adjustment := &models.Adjustment{
+		GroupID: flow.GroupID,
+		UserID:  flow.UserID,
+		Kind:    flow.Kind,
+		Value:   flow.Value,
+	}
+
+	if parts[1] == "all" {
+		from := startOfToday()
+		adjustment.StartsOn = &from
+	} else {
+		sessionID, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return
+		}
+		adjustment.SessionID = &sessionID
+	}
+
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, adjustmentText(b, *adjustment), nil)
+	grantDiscount(b, callback.From.ID, callback.Message.Chat.ID, flow, adjustment)
+}
+
+func handleDiscountPeriodInput(b *bot.Bot, message *tgbotapi.Message, flow *models.DiscountFlow) {
+	invalid := fmt.Sprintf("تاریخ نامعتبر است. لطفا به صورت %s وارد کنید:", rateDateLayout)
+
+	var days []time.Time
+	for _, field := range strings.Fields(message.Text) {
+		day, err := time.ParseInLocation(rateDateLayout, field, time.Local)
+		if err != nil {
+			b.SendMessage(message.Chat.ID, invalid, nil)
+			return
+		}
+		days = append(days, day)
+	}
+
+	from, to := startOfToday(), time.Time{}
+	switch len(days) {
+	case 1:
+		to = days[0]
+	case 2:
+		from, to = days[0], days[1]
+	default:
+		b.SendMessage(message.Chat.ID, invalid, nil)
+		return
+	}
+
+	if from.Before(startOfToday()) {
+		b.SendMessage(message.Chat.ID, "معافیت از روزهای گذشته ممکن نیست. لطفا امروز یا یک روز آینده را وارد کنید:", nil)
+		return
+	}
+	if to.Before(from) {
+		b.SendMessage(message.Chat.ID, "تاریخ پایان باید بعد از تاریخ شروع باشد:", nil)
+		return
+	}
+
+	grantDiscount(b, message.From.ID, message.Chat.ID, flow, &models.Adjustment{
+		GroupID:  flow.GroupID,
+		UserID:   flow.UserID,
+		Kind:     models.AdjustmentExempt,
+		StartsOn: &from,
+		EndsOn:   &to,
+	})
+}
+
+func grantDiscount(b *bot.Bot, telegramID, chatID int64, flow *models.DiscountFlow, adjustment *models.Adjustment) {
+	b.ClearState(telegramID, chatID, models.FlowDiscount)
+
+	ug, err := b.DB.GetUserGroup(flow.UserID, flow.GroupID)
+	if err != nil {
+		b.SendMessage(chatID, "کاربر در این گروه ثبت نشده است.", nil)
+		return
+	}
+
+	granted, err := b.DB.GrantAdjustment(adjustment, flow.AdminID)
+	if errors.Is(err, sql.ErrNoRows) {
+		b.SendMessage(chatID, "این جلسه دیگر برنامه‌ریزی شده نیست.", nil)
+		return
+	}
+	if err != nil {
+		zap.L().Error("Error granting adjustment", zap.Error(err), zap.Int64("user_id", flow.UserID), zap.Int64("group_id", flow.GroupID))
+		b.SendMessage(chatID, "خطا در ثبت تخفیف. لطفا دوباره تلاش کنید.", nil)
+		return
+	}
+
+	if member, err := b.DB.GetUser(ug.UserID); err == nil {
+		groupTitle := ""
+		if group, err := b.DB.GetGroup(ug.GroupID); err == nil {
+			groupTitle = group.Title
+		}
+		b.SendMessage(member.TelegramID,
+			fmt.Sprintf("🎁 در گروه %s برای شما ثبت شد: %s", groupTitle, adjustmentText(b, *granted)), nil)
+	}
+
+	showDiscounts(b, chatID, 0, ug, "✅ "+adjustmentText(b, *granted)+" ثبت شد.")
+}
+
+func handleDiscountRevokeCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 2 {
+		return
+	}
+
+	adjustmentID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	adjustment, err := b.DB.GetAdjustment(adjustmentID)
+	if err != nil {
+		b.AnswerCallbackQuery(callback.ID, "این تخفیف دیگر وجود ندارد.")
+		return
+	}
+
+	admin, ok := requireCapability(b, callback, adjustment.GroupID, models.CapManagePayments)
+	if !ok {
+		return
+	}
+
+	ug, err := b.DB.GetUserGroup(adjustment.UserID, adjustment.GroupID)
+	if err != nil {
+		b.AnswerCallbackQuery(callback.ID, "کاربر در این گروه ثبت نشده است.")
+		return
+	}
+
+	err = b.DB.RevokeAdjustment(adjustmentID, admin.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		zap.L().Error("Error revoking adjustment", zap.Error(err), zap.Int64("adjustment_id", adjustmentID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در لغو تخفیف.")
+		return
+	}
+
+	b.AnswerCallbackQuery(callback.ID, "تخفیف لغو شد.")
+	showDiscounts(b, callback.Message.Chat.ID, callback.Message.MessageID, ug, "")
+}
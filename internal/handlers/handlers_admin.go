@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"futsal-bot/internal/bot"
 	"futsal-bot/internal/models"
@@ -18,66 +19,6 @@ const (
 	sessionColWidth = 15
 )
 
-func handleSetRatesCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
-	if len(parts) < 2 {
-		return
-	}
-
-	groupID, err := strconv.ParseInt(parts[1], 10, 64)
-	if err != nil {
-		return
-	}
-
-	// Check if user is admin
-	user, err := b.DB.GetUserByTelegramID(callback.From.ID)
-	if err != nil {
-		b.SendMessage(callback.Message.Chat.ID, "خطا در دریافت اطلاعات کاربر.", nil)
-		return
-	}
-
-	isAdmin := b.IsDefaultAdmin(callback.From.ID)
-	if !isAdmin {
-		isAdmin, _ = b.DB.IsUserAdminInGroup(user.ID, groupID)
-	}
-
-	if !isAdmin {
-		b.AnswerCallbackQuery(callback.ID, "شما دسترسی ادمین ندارید.")
-		return
-	}
-
-	keyboard := b.RateSettingKeyboard(groupID)
-	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, "برای تنظیم نرخ، یک نقش را انتخاب کنید:", &keyboard)
-}
-
-func handleSetRateCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
-	if len(parts) < 3 {
-		return
-	}
-
-	roleStr := parts[1]
-	groupID, err := strconv.ParseInt(parts[2], 10, 64)
-	if err != nil {
-		return
-	}
-
-	role := models.UserRole(roleStr)
-
-	roleNames := map[models.UserRole]string{
-		models.RoleStudent:   "دانشجو",
-		models.RoleAdult:     "بزرگسال",
-		models.RoleHalfAdult: "نیمه بزرگسال",
-	}
-
-	tempData := map[string]interface{}{
-		"group_id": groupID,
-		"role":     role,
-	}
-	b.SetState(callback.From.ID, "awaiting_rate", tempData)
-
-	text := fmt.Sprintf("لطفا نرخ هر جلسه برای %s را به تومان وارد کنید:", roleNames[role])
-	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, nil)
-}
-
 func handleSettleCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
 	if len(parts) < 2 {
 		return
@@ -88,25 +29,12 @@ func handleSettleCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []
 		return
 	}
 
-	// Check if user is admin
-	user, err := b.DB.GetUserByTelegramID(callback.From.ID)
-	if err != nil {
-		b.SendMessage(callback.Message.Chat.ID, "خطا در دریافت اطلاعات کاربر.", nil)
+	if _, ok := requireCapability(b, callback, groupID, models.CapManagePayments); !ok {
 		return
 	}
 
-	isAdmin := b.IsDefaultAdmin(callback.From.ID)
-	if !isAdmin {
-		isAdmin, _ = b.DB.IsUserAdminInGroup(user.ID, groupID)
-	}
-
-	if !isAdmin {
-		b.AnswerCallbackQuery(callback.ID, "شما دسترسی ادمین ندارید.")
-		return
-	}
-
-	// Get all users in group
-	userGroups, err := b.DB.GetUserGroupsByGroupID(groupID)
+	// Get all users in group, including those who left, since they may still owe
+	userGroups, err := b.DB.GetGroupMemberships(groupID)
 	if err != nil || len(userGroups) == 0 {
 		backKeyboard := tgbotapi.NewInlineKeyboardMarkup(
 			tgbotapi.NewInlineKeyboardRow(
@@ -118,26 +46,35 @@ func handleSettleCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []
 		return
 	}
 
+	balances, err := b.DB.GetGroupBalances(groupID)
+	if err != nil {
+		zap.L().Error("Error getting balances", zap.Error(err), zap.Int64("group_id", groupID))
+		b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, "خطا در دریافت اطلاعات.", nil)
+		return
+	}
+
 	// Create keyboard with user list
 	var rows [][]tgbotapi.InlineKeyboardButton
 	for _, ug := range userGroups {
-		if ug.SessionsOwed > 0 {
-			buttonText := fmt.Sprintf("%s - %d جلسه", ug.Name, ug.SessionsOwed)
-			buttonData := fmt.Sprintf("settle_user:%d:%d", ug.UserID, groupID)
-			rows = append(rows, []tgbotapi.InlineKeyboardButton{
-				tgbotapi.NewInlineKeyboardButtonData(buttonText, buttonData),
-			})
-		} else if ug.SessionsOwed < 0 {
-			buttonText := fmt.Sprintf("%s - %d جلسه طلب کار", ug.Name, ug.SessionsOwed*(-1))
-			rows = append(rows, []tgbotapi.InlineKeyboardButton{
-				tgbotapi.NewInlineKeyboardButtonData(buttonText, "noop"),
-			})
-		} else {
-			buttonText := fmt.Sprintf("%s - تسویه", ug.Name)
-			rows = append(rows, []tgbotapi.InlineKeyboardButton{
-				tgbotapi.NewInlineKeyboardButtonData(buttonText, "noop"),
-			})
+		balance := balances[ug.UserID].Balance
+		if !listedInAccounts(ug, balance) {
+			continue
+		}
+
+		name := ug.Name
+		if ug.Status == models.MembershipArchived {
+			name += " (خارج شده)"
+		}
+
+		buttonText := fmt.Sprintf("%s - تسویه ✅", name)
+		if balance > 0 {
+			buttonText = fmt.Sprintf("%s - %.0f تومان بدهکار", name, balance)
+		} else if balance < 0 {
+			buttonText = fmt.Sprintf("%s - %.0f تومان طلب کار", name, -balance)
 		}
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(buttonText, fmt.Sprintf("settle_user:%d:%d", ug.UserID, groupID)),
+		})
 	}
 
 	if len(rows) == 0 {
@@ -160,6 +97,19 @@ func handleSettleCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []
 	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, "کاربری که می‌خواهید تسویه کنید را انتخاب کنید:", &keyboard)
 }
 
+// listedInAccounts reports whether a membership belongs in the settle list and
+// the report: active members always, members who left only while their
+// account is open, and never registrations still waiting for approval.
+func listedInAccounts(ug models.UserGroup, balance float64) bool {
+	switch ug.Status {
+	case models.MembershipActive:
+		return true
+	case models.MembershipArchived:
+		return balance != 0
+	}
+	return false
+}
+
 func handleSettleUserCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
 	if len(parts) < 3 {
 		return
@@ -175,13 +125,47 @@ func handleSettleUserCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, part
 		return
 	}
 
-	tempData := map[string]interface{}{
-		"target_user_id": targetUserID,
-		"group_id":       groupID,
+	if _, ok := requireCapability(b, callback, groupID, models.CapManagePayments); !ok {
+		return
 	}
-	b.SetState(callback.From.ID, "awaiting_settle_sessions", tempData)
 
-	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, "تعداد جلساتی که تسویه شده را وارد کنید:", nil)
+	ug, err := b.DB.GetUserGroup(targetUserID, groupID)
+	if err != nil {
+		b.AnswerCallbackQuery(callback.ID, "کاربر در این گروه ثبت نشده است.")
+		return
+	}
+
+	balance, err := b.DB.GetBalance(targetUserID, groupID)
+	if err != nil {
+		zap.L().Error("Error getting balance", zap.Error(err), zap.Int64("user_id", targetUserID), zap.Int64("group_id", groupID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در دریافت اطلاعات.")
+		return
+	}
+
+	text := fmt.Sprintf(
+		"👤 %s\n\n"+
+			"مجموع هزینه جلسات: %.0f تومان\n"+
+			"%s"+
+			"مجموع پرداختی: %.0f تومان\n"+
+			"%s",
+		ug.Name, balance.Charged, discountLine(balance.Discounts), balance.Paid, balanceLine(balance.Balance),
+	)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💵 ثبت پرداخت", fmt.Sprintf("pay:%d:%d", targetUserID, groupID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📜 تاریخچه پرداخت", fmt.Sprintf("payments:%d:%d", targetUserID, groupID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🎁 تخفیف و معافیت", fmt.Sprintf("discounts:%d:%d", targetUserID, groupID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", fmt.Sprintf("settle:%d", groupID)),
+		),
+	)
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
 }
 
 func handleBackCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
@@ -194,29 +178,27 @@ func handleBackCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []st
 		return
 	}
 
-	user, err := b.DB.GetUserByTelegramID(callback.From.ID)
+	access, err := b.Authorize(callback.From.ID, groupID)
 	if err != nil {
 		return
 	}
 
-	isAdmin := b.IsDefaultAdmin(callback.From.ID)
-	if !isAdmin {
-		isAdmin, _ = b.DB.IsUserAdminInGroup(user.ID, groupID)
+	text := "منوی اصلی:"
+	if group, err := b.DB.GetGroup(groupID); err == nil {
+		text = mainMenuText(group)
 	}
 
-	keyboard := b.MainMenuKeyboard(user.ID, groupID, isAdmin)
-	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID,
-		"منوی اصلی:", &keyboard)
+	keyboard := b.MainMenuKeyboard(access.User.ID, groupID, access.Permission)
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
 }
 
 // Group message handlers
 func HandleGroupMessage(b *bot.Bot, message *tgbotapi.Message) {
-	// Handle when bot is added to a group
 	if message.NewChatMembers != nil {
 		for _, member := range message.NewChatMembers {
-			if member.ID == b.API.Self.ID {
+			if b.IsSelf(member.ID) {
 				// Bot was added to group
-				_, err := b.DB.GetOrCreateGroup(
+				group, err := b.DB.GetOrCreateGroup(
 					message.Chat.ID,
 					message.Chat.Title,
 					message.Chat.Type,
@@ -228,136 +210,74 @@ func HandleGroupMessage(b *bot.Bot, message *tgbotapi.Message) {
 						"سلام! من ربات مدیریت فوتسال هستم. "+
 							"برای استفاده از امکانات من، لطفا به پیوی من مراجعه کنید.",
 						nil)
+					claimGroupOwnership(b, group, message.From)
 				}
+			} else {
+				handleMemberJoined(b, message.Chat.ID, &member)
 			}
 		}
 	}
 
+	// A member leaving the chat leaves the group
+	if message.LeftChatMember != nil && !b.IsSelf(message.LeftChatMember.ID) {
+		handleMemberLeft(b, message.Chat.ID, message.LeftChatMember)
+	}
+
 	// Handle commands in group
 	if message.IsCommand() {
 		switch message.Command() {
 		case "attendance":
 			handleAttendanceCommand(b, message)
+		case "revert":
+			handleRevertCommand(b, message)
 		case "report":
 			handleReportCommand(b, message)
+		case "sync":
+			handleSyncCommand(b, message)
+		case "admins":
+			handleAdminsCommand(b, message)
+		case "audit":
+			handleAuditCommand(b, message)
 		}
 	}
 }
 
-func handleAttendanceCommand(b *bot.Bot, message *tgbotapi.Message) {
-	// Check if sender is admin
-	user, err := b.DB.GetUserByTelegramID(message.From.ID)
-	if err != nil {
-		b.SendMessage(message.Chat.ID, "خطا در دریافت اطلاعات کاربر.", nil)
-		return
-	}
-
-	group, err := b.DB.GetGroupByTelegramChatID(message.Chat.ID)
-	if err != nil {
-		b.SendMessage(message.Chat.ID, "این گروه در سیستم ثبت نشده است.", nil)
-		return
-	}
-
-	isAdmin := b.IsDefaultAdmin(message.From.ID)
-	if !isAdmin {
-		isAdmin, _ = b.DB.IsUserAdminInGroup(user.ID, group.ID)
-	}
-
-	if !isAdmin {
-		b.SendMessage(message.Chat.ID, "فقط ادمین‌ها می‌توانند حضور و غیاب ثبت کنند.", nil)
-		return
-	}
-
-	// Parse user IDs from command arguments
-	args := strings.Fields(message.CommandArguments())
-	if len(args) == 0 {
-		b.SendMessage(message.Chat.ID, "لطفا آیدی کاربران را وارد کنید.\n"+"مثال: /attendance @user1 @user2 @user3 @user4", nil)
-		return
-	}
-
-	var userIDs []int64
-	var userNames []string
-	for _, arg := range args {
-		userName := strings.TrimPrefix(arg, "@")
-		userNames = append(userNames, userName)
-	}
-
-	if len(userNames) == 0 {
-		b.SendMessage(message.Chat.ID, "هیچ آیدی معتبری یافت نشد.", nil)
-		return
-	}
-
-	// Add sessions for each user
-	successCount := 0
-	for _, userName := range userNames {
-		u, err := b.DB.GetUserByUserName(userName)
-		if err != nil {
-			zap.L().Error("Error getting user by username", zap.String("username", userName), zap.Error(err))
-			continue
-		}
-
-		// Check if user is member of this group
-		isMember, err := b.DB.IsUserMemberOfGroup(u.ID, group.ID)
-		if err != nil || !isMember {
-			continue
-		}
-
-		userIDs = append(userIDs, u.TelegramID)
-
-		err = b.DB.AddSessionsToUser(u.ID, group.ID, 1)
-		if err != nil {
-			zap.L().Error("Error adding session for user", zap.Int64("telegram_id", u.TelegramID), zap.Error(err))
-			continue
-		}
-
-		successCount++
-	}
-
-	text := fmt.Sprintf(
-		"✅ حضور و غیاب ثبت شد.\n\n"+
-			"تعداد کاربران: %d\n",
-		successCount,
-	)
-
-	b.SendMessage(message.Chat.ID, text, nil)
-}
-
 func handleReportCommand(b *bot.Bot, message *tgbotapi.Message) {
 	zap.L().Info("Handling report command", zap.Int64("chat_id", message.Chat.ID))
-	// Check if sender is admin
-	user, err := b.DB.GetUserByTelegramID(message.From.ID)
-	if err != nil {
-		b.SendMessage(message.Chat.ID, "خطا در دریافت اطلاعات کاربر.", nil)
+	_, group, ok := commandAccess(b, message, models.CapViewReports, "فقط ادمین‌ها و خزانه‌دار می‌توانند گزارش مشاهده کنند.")
+	if !ok {
 		return
 	}
 
-	group, err := b.DB.GetGroupByTelegramChatID(message.Chat.ID)
+	// Get all users with debts in this group
+	userGroups, err := b.DB.GetGroupMemberships(group.ID)
 	if err != nil {
-		b.SendMessage(message.Chat.ID, "این گروه در سیستم ثبت نشده است.", nil)
+		b.SendMessage(message.Chat.ID, "خطا در دریافت اطلاعات.", nil)
 		return
 	}
 
-	isAdmin := b.IsDefaultAdmin(message.From.ID)
-	if !isAdmin {
-		isAdmin, _ = b.DB.IsUserAdminInGroup(user.ID, group.ID)
-	}
-
-	if !isAdmin {
-		b.SendMessage(message.Chat.ID, "فقط ادمین‌ها می‌توانند گزارش مشاهده کنند.", nil)
+	balances, err := b.DB.GetGroupBalances(group.ID)
+	if err != nil {
+		zap.L().Error("Error getting balances", zap.Error(err), zap.Int64("group_id", group.ID))
+		b.SendMessage(message.Chat.ID, "خطا در دریافت اطلاعات.", nil)
 		return
 	}
 
-	// Get all users with debts in this group
-	userGroups, err := b.DB.GetUserGroupsByGroupID(group.ID)
+	lastAttended, err := b.DB.GetLastAttendance(group.ID)
 	if err != nil {
+		zap.L().Error("Error getting last attendance", zap.Error(err), zap.Int64("group_id", group.ID))
 		b.SendMessage(message.Chat.ID, "خطا در دریافت اطلاعات.", nil)
 		return
 	}
 
 	var reportLines []string
-	reportLines = append(reportLines, "📊 گزارش بدهی‌ جلسات\n")
+	reportLines = append(reportLines, "📊 گزارش بدهی‌ جلسات (تومان)\n")
 	hasDebts := false
+	var totalDiscounts float64
 	for _, ug := range userGroups {
+		if !listedInAccounts(ug, balances[ug.UserID].Balance) {
+			continue
+		}
 		hasDebts = true
 		// Get user telegram ID
 		var telegramUsername, line string
@@ -369,12 +289,26 @@ func handleReportCommand(b *bot.Bot, message *tgbotapi.Message) {
 			continue
 		}
 
-		if ug.SessionsOwed > 0 {
-			line = fmt.Sprintf("• %s = %d", telegramUsername, ug.SessionsOwed)
-		} else if ug.SessionsOwed < 0 {
-			line = fmt.Sprintf("• %s = %d ❤️", telegramUsername, ug.SessionsOwed)
+		balance := balances[ug.UserID].Balance
+		if balance > 0 {
+			line = fmt.Sprintf("• %s = %.0f", telegramUsername, balance)
+		} else if balance < 0 {
+			line = fmt.Sprintf("• %s = %.0f ❤️", telegramUsername, balance)
 		} else {
-			line = fmt.Sprintf("• %s = %d ✅", telegramUsername, ug.SessionsOwed)
+			line = fmt.Sprintf("• %s = 0 ✅", telegramUsername)
+		}
+
+		if ug.Status == models.MembershipArchived {
+			line += " (خارج شده)"
+		}
+
+		if discounts := balances[ug.UserID].Discounts; discounts != 0 {
+			line += fmt.Sprintf(" (تخفیف: %.0f)", discounts)
+			totalDiscounts += discounts
+		}
+
+		if date, ok := lastAttended[ug.UserID]; ok {
+			line += fmt.Sprintf(" (آخرین جلسه: %s)", date.In(time.Local).Format("2006-01-02"))
 		}
 
 		reportLines = append(reportLines, line)
@@ -385,10 +319,25 @@ func handleReportCommand(b *bot.Bot, message *tgbotapi.Message) {
 		return
 	}
 
+	if totalDiscounts != 0 {
+		reportLines = append(reportLines, fmt.Sprintf("\n🎁 مجموع تخفیف‌ها و معافیت‌ها: %.0f", totalDiscounts))
+	}
+
 	report := strings.Join(reportLines, "\n")
 	b.SendMessageWithMarkdown(message.Chat.ID, report, nil)
 }
 
+// escapeMarkdown escapes the characters that are special in legacy Markdown.
+func escapeMarkdown(text string) string {
+	replacer := strings.NewReplacer(
+		"_", "\\_",
+		"*", "\\*",
+		"[", "\\[",
+		"`", "\\`",
+	)
+	return replacer.Replace(text)
+}
+
 func escapeMarkdownV2(text string) string {
 	replacer := strings.NewReplacer(
 		"_", "\\_",
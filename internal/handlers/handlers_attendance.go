@@ -0,0 +1,649 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"futsal-bot/internal/bot"
+	"futsal-bot/internal/database"
+	"futsal-bot/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	// attendanceLookback and attendanceLookahead bound how far from a
+	// session's start time attendance is still recorded against it.
+	attendanceLookback  = 18 * time.Hour
+	attendanceLookahead = 6 * time.Hour
+)
+
+// venueCostMissingText explains why attendance can't be charged in a group
+// that splits venue costs.
+const venueCostMissingText = "این گروه هزینه زمین را بین حاضرین تقسیم می‌کند، اما جلسه‌ای با هزینه زمین مشخص برای این حضور و غیاب پیدا نشد. " +
+	"ابتدا از منوی جلسات در پیوی ربات جلسه را بسازید یا هزینه زمین آن را وارد کنید و دوباره تلاش کنید. هیچ تغییری اعمال نشد."
+
+// rateMissingText explains why attendance can't be charged when a tier has
+// no rate yet on the day of the session.
+const rateMissingText = "نقش یکی از حاضرین برای روز این جلسه هنوز نرخی ندارد. " +
+	"ابتدا از منوی «تعیین نرخ» در پیوی ربات نرخ آن نقش را تنظیم کنید؛ اولین نرخ هر نقش را می‌توان از روز جلسه یا پیش از آن شروع کرد. سپس دوباره تلاش کنید. هیچ تغییری اعمال نشد."
+
+// sessionTakenText explains why attendance can't be recorded for a session
+// that was completed or cancelled in the meantime, or for a checklist that
+// was already confirmed.
+const sessionTakenText = "این حضور و غیاب قبلا ثبت شده یا جلسه آن لغو شده است. هیچ تغییری اعمال نشد."
+
+func handleAttendanceCommand(b *bot.Bot, message *tgbotapi.Message) {
+	user, group, ok := commandAccess(b, message, models.CapManageSessions, "فقط ادمین‌ها می‌توانند حضور و غیاب ثبت کنند.")
+	if !ok {
+		return
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		openAttendanceChecklist(b, message, group)
+		return
+	}
+
+	// Parse usernames from command arguments; -p switches to partial mode and
+	// #<id> picks the session
+	partial := false
+	var sessionArg string
+	var userNames []string
+	for _, arg := range args {
+		if arg == "-p" || arg == "--partial" {
+			partial = true
+			continue
+		}
+		if strings.HasPrefix(arg, "#") {
+			sessionArg = strings.TrimPrefix(arg, "#")
+			continue
+		}
+		if userName := strings.TrimPrefix(arg, "@"); userName != "" {
+			userNames = append(userNames, userName)
+		}
+	}
+
+	if len(userNames) == 0 {
+		b.SendMessage(message.Chat.ID, "لطفا آیدی کاربران را وارد کنید.\n"+"مثال: /attendance @user1 @user2 @user3 @user4", nil)
+		return
+	}
+
+	session, ok := commandSession(b, message, group.ID, sessionArg)
+	if !ok {
+		return
+	}
+	var sessionID *int64
+	if session != nil {
+		sessionID = &session.ID
+	}
+
+	result, err := b.DB.RegisterAttendance(group.ID, sessionID, user.ID, userNames, partial)
+	if errors.Is(err, database.ErrSessionStatusChanged) || errors.Is(err, database.ErrAttendanceRecorded) {
+		b.SendMessage(message.Chat.ID, sessionTakenText, nil)
+		return
+	}
+	if errors.Is(err, database.ErrVenueCostMissing) {
+		b.SendMessage(message.Chat.ID, venueCostMissingText, nil)
+		return
+	}
+	if errors.Is(err, database.ErrRateMissing) {
+		b.SendMessage(message.Chat.ID, rateMissingText, nil)
+		return
+	}
+	if err != nil {
+		zap.L().Error("Error registering attendance", zap.Error(err), zap.Int64("group_id", group.ID))
+		b.SendMessage(message.Chat.ID, "خطا در ثبت حضور و غیاب. هیچ تغییری اعمال نشد.", nil)
+		return
+	}
+
+	if result.Record != nil && session != nil {
+		session.Status = models.SessionCompleted
+		refreshSessionRSVP(b, session)
+	}
+
+	b.SendMessage(message.Chat.ID, attendanceResultText(b, result, session), nil)
+}
+
+// commandSession resolves the session /attendance is recorded for: the one
+// named with #<id>, or else the only scheduled session around now. When
+// several sessions are around now the admin is asked to name one and ok is
+// false. A nil session records attendance without one.
+func commandSession(b *bot.Bot, message *tgbotapi.Message, groupID int64, sessionArg string) (*models.Session, bool) {
+	if sessionArg != "" {
+		id, err := strconv.ParseInt(sessionArg, 10, 64)
+		session := checklistSession(b, groupID, id)
+		if err != nil || session == nil || session.Status != models.SessionScheduled {
+			b.SendMessage(message.Chat.ID, fmt.Sprintf("جلسه برنامه‌ریزی شده #%s در این گروه پیدا نشد.", sessionArg), nil)
+			return nil, false
+		}
+		return session, true
+	}
+
+	sessions, err := attendanceSessions(b, groupID)
+	if err != nil {
+		b.SendMessage(message.Chat.ID, "خطا در دریافت اطلاعات.", nil)
+		return nil, false
+	}
+
+	switch len(sessions) {
+	case 0:
+		return nil, true
+	case 1:
+		return &sessions[0], true
+	}
+
+	lines := []string{"چند جلسه برای این حضور و غیاب وجود دارد. شماره جلسه را هم بنویسید:", ""}
+	for i := range sessions {
+		lines = append(lines, fmt.Sprintf("#%d: %s", sessions[i].ID, formatSession(&sessions[i])))
+	}
+	lines = append(lines, "", fmt.Sprintf("مثال: /attendance #%d @user1 @user2", sessions[0].ID))
+	b.SendMessage(message.Chat.ID, strings.Join(lines, "\n"), nil)
+	return nil, false
+}
+
+// openAttendanceChecklist starts a checklist. When sessions are scheduled
+// around now the admin first picks the one attendance is taken for.
+func openAttendanceChecklist(b *bot.Bot, message *tgbotapi.Message, group *models.Group) {
+	members, err := b.DB.GetUserGroupsByGroupID(group.ID)
+	if err != nil {
+		zap.L().Error("Error getting group members", zap.Error(err), zap.Int64("group_id", group.ID))
+		b.SendMessage(message.Chat.ID, "خطا در دریافت اطلاعات.", nil)
+		return
+	}
+
+	if len(members) == 0 {
+		b.SendMessage(message.Chat.ID, "هیچ کاربری در این گروه ثبت نشده است.", nil)
+		return
+	}
+
+	sessions, err := attendanceSessions(b, group.ID)
+	if err != nil {
+		b.SendMessage(message.Chat.ID, "خطا در دریافت اطلاعات.", nil)
+		return
+	}
+
+	if len(sessions) > 0 {
+		keyboard := attendanceSessionKeyboard(group.ID, sessions)
+		b.SendMessage(message.Chat.ID, "📋 حضور و غیاب\n\nحضور و غیاب برای کدام جلسه ثبت شود؟", keyboard)
+		return
+	}
+
+	keyboard := b.AttendanceChecklistKeyboard(group.ID, 0, newChecklistNonce(), members, nil)
+	b.SendMessage(message.Chat.ID, attendanceChecklistText(nil, 0), keyboard)
+}
+
+// attendanceSessionKeyboard lets an admin pick which of the given sessions
+// attendance is taken for, or take it without a session.
+func attendanceSessionKeyboard(groupID int64, sessions []models.Session) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := range sessions {
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("📅 "+formatSession(&sessions[i]), fmt.Sprintf("att_session:%d:%d", groupID, sessions[i].ID)),
+		})
+	}
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("📋 بدون جلسه", fmt.Sprintf("att_session:%d:0", groupID)),
+	})
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("❌ انصراف", fmt.Sprintf("att_cancel:%d", groupID)),
+	})
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// handleAttendanceSessionCallback opens the checklist for the session the
+// admin picked, or for none when the session ID is zero.
+func handleAttendanceSessionCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 3 {
+		return
+	}
+
+	_, group, ok := checklistAdmin(b, callback, parts)
+	if !ok {
+		return
+	}
+
+	sessionID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return
+	}
+
+	session := checklistSession(b, group.ID, sessionID)
+	if sessionID != 0 && (session == nil || session.Status != models.SessionScheduled) {
+		b.AnswerCallbackQuery(callback.ID, "این جلسه دیگر برنامه‌ریزی شده نیست.")
+		return
+	}
+
+	members, err := b.DB.GetUserGroupsByGroupID(group.ID)
+	if err != nil {
+		zap.L().Error("Error getting group members", zap.Error(err), zap.Int64("group_id", group.ID))
+		return
+	}
+
+	// Members who answered "I'm in" to the session poll start out selected
+	selected := shownSelection(rsvpSelection(b, session), members)
+
+	keyboard := b.AttendanceChecklistKeyboard(group.ID, sessionID, newChecklistNonce(), members, selected)
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, attendanceChecklistText(session, len(selected)), &keyboard)
+}
+
+// newChecklistNonce returns a random identifier for a new checklist.
+func newChecklistNonce() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("reading random bytes: %v", err))
+	}
+	return hex.EncodeToString(buf)
+}
+
+func attendanceChecklistText(session *models.Session, selectedCount int) string {
+	text := "📋 حضور و غیاب\n\n"
+	if session != nil {
+		text += "جلسه: " + formatSession(session) + "\n\n"
+	}
+	return text + fmt.Sprintf("افراد حاضر را انتخاب کنید و سپس دکمه ثبت را بزنید.\nانتخاب شده: %d نفر", selectedCount)
+}
+
+// attendanceSessions returns the scheduled sessions of the group attendance
+// may be taken for: those that started up to attendanceLookback ago or start
+// within attendanceLookahead, soonest first.
+func attendanceSessions(b *bot.Bot, groupID int64) ([]models.Session, error) {
+	now := time.Now()
+	sessions, err := b.DB.GetScheduledSessionsBetween(groupID, now.Add(-attendanceLookback), now.Add(attendanceLookahead))
+	if err != nil {
+		zap.L().Error("Error getting scheduled sessions", zap.Error(err), zap.Int64("group_id", groupID))
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// checklistConfirm reads the session a checklist was opened for and its
+// nonce back from its confirm button.
+func checklistConfirm(markup *tgbotapi.InlineKeyboardMarkup) (int64, string) {
+	if markup == nil {
+		return 0, ""
+	}
+
+	for _, row := range markup.InlineKeyboard {
+		for _, button := range row {
+			if button.CallbackData == nil {
+				continue
+			}
+			parts := strings.Split(*button.CallbackData, ":")
+			if len(parts) == 4 && parts[0] == "att_confirm" {
+				sessionID, _ := strconv.ParseInt(parts[2], 10, 64)
+				return sessionID, parts[3]
+			}
+		}
+	}
+
+	return 0, ""
+}
+
+// checklistSession loads the session a checklist was opened for, or nil.
+func checklistSession(b *bot.Bot, groupID, sessionID int64) *models.Session {
+	if sessionID == 0 {
+		return nil
+	}
+
+	session, err := b.DB.GetSession(sessionID)
+	if err != nil || session.GroupID != groupID {
+		return nil
+	}
+
+	return session
+}
+
+// checklistSelection reads the current selection back from the toggle buttons
+// of an attendance checklist message.
+func checklistSelection(markup *tgbotapi.InlineKeyboardMarkup) map[int64]bool {
+	selected := make(map[int64]bool)
+	if markup == nil {
+		return selected
+	}
+
+	for _, row := range markup.InlineKeyboard {
+		for _, button := range row {
+			if button.CallbackData == nil {
+				continue
+			}
+			parts := strings.Split(*button.CallbackData, ":")
+			if len(parts) != 4 || parts[0] != "att_toggle" || parts[3] != "1" {
+				continue
+			}
+			if userID, err := strconv.ParseInt(parts[2], 10, 64); err == nil {
+				selected[userID] = true
+			}
+		}
+	}
+
+	return selected
+}
+
+// shownSelection keeps the selected users a checklist lists, so its counts
+// leave out those who aren't active members, such as archived ones who
+// answered a poll before leaving.
+func shownSelection(selected map[int64]bool, members []models.UserGroup) map[int64]bool {
+	shown := make(map[int64]bool)
+	for _, ug := range members {
+		if selected[ug.UserID] {
+			shown[ug.UserID] = true
+		}
+	}
+	return shown
+}
+
+// checklistAdmin resolves the group of a checklist callback and makes sure the
+// presser may take attendance there.
+func checklistAdmin(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) (*models.User, *models.Group, bool) {
+	if len(parts) < 2 || callback.Message == nil {
+		return nil, nil, false
+	}
+
+	groupID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	group, err := b.DB.GetGroupByTelegramChatID(callback.Message.Chat.ID)
+	if err != nil || group.ID != groupID {
+		return nil, nil, false
+	}
+
+	access, err := b.Authorize(callback.From.ID, group.ID)
+	if err != nil {
+		b.AnswerCallbackQuery(callback.ID, "خطا در دریافت اطلاعات کاربر.")
+		return nil, nil, false
+	}
+
+	if !access.Can(models.CapManageSessions) {
+		b.AnswerCallbackQuery(callback.ID, "فقط ادمین‌ها می‌توانند حضور و غیاب ثبت کنند.")
+		return nil, nil, false
+	}
+
+	return access.User, group, true
+}
+
+func handleAttendanceToggleCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 4 {
+		return
+	}
+
+	_, group, ok := checklistAdmin(b, callback, parts)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return
+	}
+
+	selected := checklistSelection(callback.Message.ReplyMarkup)
+	if selected[userID] {
+		delete(selected, userID)
+	} else {
+		selected[userID] = true
+	}
+
+	members, err := b.DB.GetUserGroupsByGroupID(group.ID)
+	if err != nil {
+		zap.L().Error("Error getting group members", zap.Error(err), zap.Int64("group_id", group.ID))
+		return
+	}
+
+	selected = shownSelection(selected, members)
+
+	sessionID, nonce := checklistConfirm(callback.Message.ReplyMarkup)
+	session := checklistSession(b, group.ID, sessionID)
+
+	keyboard := b.AttendanceChecklistKeyboard(group.ID, sessionID, nonce, members, selected)
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, attendanceChecklistText(session, len(selected)), &keyboard)
+}
+
+func handleAttendanceConfirmCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	user, group, ok := checklistAdmin(b, callback, parts)
+	if !ok {
+		return
+	}
+
+	selected := checklistSelection(callback.Message.ReplyMarkup)
+	if len(selected) == 0 {
+		b.AnswerCallbackQuery(callback.ID, "هیچ کاربری انتخاب نشده است.")
+		return
+	}
+
+	members, err := b.DB.GetUserGroupsByGroupID(group.ID)
+	if err != nil {
+		zap.L().Error("Error getting group members", zap.Error(err), zap.Int64("group_id", group.ID))
+		return
+	}
+
+	// Only members still registered in the group are credited
+	result := &models.AttendanceResult{}
+	var userIDs []int64
+	for _, ug := range members {
+		if selected[ug.UserID] {
+			result.Credited = append(result.Credited, ug)
+			userIDs = append(userIDs, ug.UserID)
+		}
+	}
+
+	// Checklists sent before nonces were added can't be told apart from a
+	// second press and have to be opened again
+	if len(parts) < 4 || parts[3] == "" {
+		b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, "این فهرست حضور و غیاب منقضی شده است. لطفا دوباره /attendance را بزنید.", nil)
+		return
+	}
+	nonce := parts[3]
+
+	var session *models.Session
+	var sessionID *int64
+	if id, _ := strconv.ParseInt(parts[2], 10, 64); id != 0 {
+		if session = checklistSession(b, group.ID, id); session == nil {
+			b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, sessionTakenText, nil)
+			return
+		}
+		sessionID = &session.ID
+	}
+
+	result.Record, err = b.DB.CreateAttendanceRecord(group.ID, sessionID, user.ID, userIDs, nonce)
+	if errors.Is(err, database.ErrSessionStatusChanged) || errors.Is(err, database.ErrAttendanceRecorded) {
+		b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, sessionTakenText, nil)
+		return
+	}
+	if errors.Is(err, database.ErrVenueCostMissing) {
+		b.SendMessage(callback.Message.Chat.ID, venueCostMissingText, nil)
+		return
+	}
+	if errors.Is(err, database.ErrRateMissing) {
+		b.SendMessage(callback.Message.Chat.ID, rateMissingText, nil)
+		return
+	}
+	if err != nil {
+		zap.L().Error("Error creating attendance record", zap.Error(err), zap.Int64("group_id", group.ID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در ثبت حضور و غیاب.")
+		return
+	}
+
+	if session != nil {
+		session.Status = models.SessionCompleted
+		refreshSessionRSVP(b, session)
+	}
+
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, attendanceResultText(b, result, session), nil)
+}
+
+func handleAttendanceCancelCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if _, _, ok := checklistAdmin(b, callback, parts); !ok {
+		return
+	}
+
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, "حضور و غیاب لغو شد.", nil)
+}
+
+func attendanceResultText(b *bot.Bot, result *models.AttendanceResult, session *models.Session) string {
+	var lines []string
+
+	if result.Record != nil {
+		lines = append(lines, "✅ حضور و غیاب ثبت شد.", "")
+		if session != nil {
+			lines = append(lines, "جلسه: "+formatSession(session))
+		}
+		lines = append(lines, fmt.Sprintf("شناسه رکورد: %d", result.Record.ID))
+	} else {
+		lines = append(lines, "❌ حضور و غیاب ثبت نشد و هیچ تغییری اعمال نشد.")
+	}
+
+	if len(result.Credited) > 0 {
+		title := fmt.Sprintf("\n👥 ثبت شده (%d):", len(result.Credited))
+		if result.Record == nil {
+			title = fmt.Sprintf("\n👥 قابل ثبت (%d):", len(result.Credited))
+		}
+		lines = append(lines, title)
+		for _, ug := range result.Credited {
+			lines = append(lines, "• "+ug.Name)
+		}
+	}
+
+	sections := []struct {
+		title     string
+		userNames []string
+	}{
+		{"❓ کاربر ناشناس (هنوز ربات را استارت نکرده)", result.Unknown},
+		{"🚫 عضو این گروه نیست", result.NotMembers},
+		{"🔁 تکراری (فقط یک بار حساب شد)", result.Duplicates},
+	}
+	for _, section := range sections {
+		if len(section.userNames) == 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("\n%s (%d):", section.title, len(section.userNames)))
+		for _, userName := range section.userNames {
+			lines = append(lines, "• @"+userName)
+		}
+	}
+
+	if result.Record != nil {
+		lines = append(lines, "", fmt.Sprintf("برای بازگردانی تا %s از /revert استفاده کنید.", formatDuration(b.RevertWindow)))
+	} else if len(result.Credited) > 0 {
+		lines = append(lines, "", "برای ثبت فقط کاربران معتبر، دستور را با -p تکرار کنید:\n/attendance -p @user1 @user2")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func handleRevertCommand(b *bot.Bot, message *tgbotapi.Message) {
+	user, group, ok := commandAccess(b, message, models.CapManageSessions, "فقط ادمین‌ها می‌توانند حضور و غیاب را بازگردانی کنند.")
+	if !ok {
+		return
+	}
+
+	// Without an argument the latest attendance of the group is reverted
+	var record *models.AttendanceRecord
+	if arg := strings.TrimSpace(message.CommandArguments()); arg != "" {
+		recordID, err := strconv.ParseInt(strings.TrimPrefix(arg, "#"), 10, 64)
+		if err != nil {
+			b.SendMessage(message.Chat.ID, "شناسه رکورد نامعتبر است.\nمثال: /revert 12", nil)
+			return
+		}
+
+		record, err = b.DB.GetAttendanceRecord(recordID)
+		if err != nil || record.GroupID != group.ID {
+			b.SendMessage(message.Chat.ID, "رکورد حضور و غیاب یافت نشد.", nil)
+			return
+		}
+	} else {
+		records, err := b.DB.GetAttendanceRecordsByGroupID(group.ID, 1)
+		if err != nil {
+			zap.L().Error("Error getting attendance records", zap.Error(err), zap.Int64("group_id", group.ID))
+			b.SendMessage(message.Chat.ID, "خطا در دریافت اطلاعات.", nil)
+			return
+		}
+		if len(records) == 0 {
+			b.SendMessage(message.Chat.ID, "هیچ حضور و غیابی در این گروه ثبت نشده است.", nil)
+			return
+		}
+		record = &records[0]
+	}
+
+	if record.IsReverted {
+		b.SendMessage(message.Chat.ID, fmt.Sprintf("رکورد %d قبلا بازگردانی شده است.", record.ID), nil)
+		return
+	}
+
+	if time.Since(record.CreatedAt) > b.RevertWindow {
+		b.SendMessage(message.Chat.ID,
+			fmt.Sprintf("مهلت %s برای بازگردانی رکورد %d تمام شده است.", formatDuration(b.RevertWindow), record.ID), nil)
+		return
+	}
+
+	err := b.DB.RevertAttendanceRecord(record.ID, user.ID)
+	if errors.Is(err, database.ErrAlreadyReverted) {
+		b.SendMessage(message.Chat.ID, fmt.Sprintf("رکورد %d قبلا بازگردانی شده است.", record.ID), nil)
+		return
+	}
+	if err != nil {
+		zap.L().Error("Error reverting attendance record", zap.Error(err), zap.Int64("record_id", record.ID))
+		b.SendMessage(message.Chat.ID, "خطا در بازگردانی حضور و غیاب.", nil)
+		return
+	}
+
+	// The session is scheduled again, so its poll reopens
+	if record.SessionID != nil {
+		session, err := b.DB.GetSession(*record.SessionID)
+		if err != nil {
+			zap.L().Error("Error getting session", zap.Error(err), zap.Int64("session_id", *record.SessionID))
+		} else {
+			refreshSessionRSVP(b, session)
+		}
+	}
+
+	names := memberNames(b, group.ID, record.UserIDs)
+	text := fmt.Sprintf(
+		"↩️ حضور و غیاب %d بازگردانی شد.\n\n"+
+			"هزینه این جلسه از حساب این افراد برگشت خورد:\n%s",
+		record.ID, strings.Join(names, "\n"),
+	)
+
+	b.SendMessage(message.Chat.ID, text, nil)
+}
+
+// memberNames returns the registered names of the given users in a group,
+// in the order of userIDs.
+func memberNames(b *bot.Bot, groupID int64, userIDs []int64) []string {
+	userGroups, err := b.DB.GetUserGroupsByGroupID(groupID)
+	if err != nil {
+		zap.L().Error("Error getting group members", zap.Error(err), zap.Int64("group_id", groupID))
+	}
+
+	byUserID := make(map[int64]string, len(userGroups))
+	for _, ug := range userGroups {
+		byUserID[ug.UserID] = ug.Name
+	}
+
+	names := make([]string, 0, len(userIDs))
+	for _, id := range userIDs {
+		name, ok := byUserID[id]
+		if !ok {
+			name = fmt.Sprintf("کاربر %d", id)
+		}
+		names = append(names, "• "+name)
+	}
+
+	return names
+}
+
+func formatDuration(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%d ساعت", int(d/time.Hour))
+	}
+	return fmt.Sprintf("%d دقیقه", int(d/time.Minute))
+}
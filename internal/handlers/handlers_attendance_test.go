@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"futsal-bot/internal/bot"
+	"futsal-bot/internal/messenger/messengertest"
+	"futsal-bot/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestChecklistRoundTrip(t *testing.T) {
+	b := bot.New(messengertest.New(tgbotapi.User{ID: 1, IsBot: true, UserName: "bot"}), nil, bot.Config{})
+	members := []models.UserGroup{{UserID: 10, Name: "Ali"}, {UserID: 20, Name: "Sara"}, {UserID: 30, Name: "Reza"}}
+	selected := map[int64]bool{10: true, 30: true}
+
+	markup := b.AttendanceChecklistKeyboard(-100, 7, "f00d", members, selected)
+	if got := checklistSelection(&markup); !reflect.DeepEqual(got, selected) {
+		t.Errorf("checklistSelection = %v, want %v", got, selected)
+	}
+	if sessionID, nonce := checklistConfirm(&markup); sessionID != 7 || nonce != "f00d" {
+		t.Errorf("checklistConfirm = %d, %q, want 7, f00d", sessionID, nonce)
+	}
+
+	// A checklist opened without a session
+	markup = b.AttendanceChecklistKeyboard(-100, 0, "beef", members, nil)
+	if got := checklistSelection(&markup); len(got) != 0 {
+		t.Errorf("checklistSelection = %v, want nobody", got)
+	}
+	if sessionID, nonce := checklistConfirm(&markup); sessionID != 0 || nonce != "beef" {
+		t.Errorf("checklistConfirm = %d, %q, want 0, beef", sessionID, nonce)
+	}
+}
+
+func TestChecklistConfirmWithoutNonce(t *testing.T) {
+	// Checklists sent before confirms carried a nonce can't be confirmed
+	markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✔️ ثبت (1 نفر)", "att_confirm:-100:7"),
+	))
+	if sessionID, nonce := checklistConfirm(&markup); nonce != "" {
+		t.Errorf("checklistConfirm = %d, %q, want no nonce", sessionID, nonce)
+	}
+	if sessionID, nonce := checklistConfirm(nil); sessionID != 0 || nonce != "" {
+		t.Errorf("checklistConfirm(nil) = %d, %q", sessionID, nonce)
+	}
+}
+
+func TestShownSelectionLeavesOutUnlistedUsers(t *testing.T) {
+	members := []models.UserGroup{{UserID: 10, Name: "Ali"}, {UserID: 20, Name: "Sara"}}
+
+	// 30 said they are coming, then left the group
+	got := shownSelection(map[int64]bool{10: true, 30: true}, members)
+	if want := map[int64]bool{10: true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("shownSelection = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"futsal-bot/internal/bot"
+	"futsal-bot/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+const auditPageSize = 10
+
+var auditActionNames = map[models.AuditAction]string{
+	models.AuditMemberRegister:   "ثبت‌نام عضو",
+	models.AuditMemberUpdate:     "ویرایش عضو",
+	models.AuditMemberApprove:    "تایید عضویت",
+	models.AuditMemberReject:     "رد عضویت",
+	models.AuditMemberArchive:    "خروج از گروه",
+	models.AuditMemberRestore:    "بازگشت به گروه",
+	models.AuditPermissionChange: "تغییر سطح دسترسی",
+	models.AuditTierCreate:       "ساخت نقش",
+	models.AuditTierRename:       "تغییر نام نقش",
+	models.AuditTierRate:         "تغییر نرخ",
+	models.AuditTierRateCancel:   "لغو تغییر نرخ",
+	models.AuditTierWeight:       "تغییر ضریب نقش",
+	models.AuditTierDelete:       "حذف نقش",
+	models.AuditPricingMode:      "تغییر حالت قیمت‌گذاری",
+	models.AuditSessionCreate:    "ساخت جلسه",
+	models.AuditSessionCancel:    "لغو جلسه",
+	models.AuditSessionCost:      "تعیین هزینه زمین",
+	models.AuditAttendanceRecord: "ثبت حضور",
+	models.AuditAttendanceRevert: "لغو حضور",
+	models.AuditPaymentRecord:    "ثبت پرداخت",
+	models.AuditPaymentClaim:     "اعلام پرداخت",
+	models.AuditPaymentApprove:   "تایید پرداخت",
+	models.AuditPaymentReject:    "رد پرداخت",
+	models.AuditAdjustmentGrant:  "ثبت تخفیف",
+	models.AuditAdjustmentRevoke: "لغو تخفیف",
+}
+
+// auditFields lists the recorded values shown for an event, in display order.
+var auditFields = []struct{ key, label string }{
+	{"name", "نام"},
+	{"tier_id", "نقش"},
+	{"permission", "سطح دسترسی"},
+	{"status", "وضعیت"},
+	{"rate", "نرخ"},
+	{"effective_from", "از تاریخ"},
+	{"weight", "ضریب"},
+	{"pricing_mode", "حالت قیمت‌گذاری"},
+	{"amount", "مبلغ"},
+	{"method", "روش"},
+	{"note", "توضیح"},
+	{"session_id", "جلسه"},
+	{"starts_at", "زمان"},
+	{"venue", "مکان"},
+	{"capacity", "ظرفیت"},
+	{"venue_cost", "هزینه زمین"},
+	{"user_ids", "حاضرین"},
+	{"kind", "نوع تخفیف"},
+	{"value", "میزان"},
+	{"starts_on", "از"},
+	{"ends_on", "تا"},
+}
+
+var auditStatusNames = map[string]string{
+	"active":    "فعال",
+	"pending":   "در انتظار تایید",
+	"archived":  "بایگانی",
+	"approved":  "تایید شده",
+	"rejected":  "رد شده",
+	"scheduled": "برنامه‌ریزی شده",
+	"cancelled": "لغو شده",
+	"completed": "برگزار شده",
+}
+
+var adjustmentKindNames = map[models.AdjustmentKind]string{
+	models.AdjustmentPercent: "درصدی",
+	models.AdjustmentFixed:   "مبلغی",
+	models.AdjustmentExempt:  "معافیت",
+}
+
+// handleAuditCommand shows the latest page of a group's audit log, optionally
+// only the events about the member mentioned after the command.
+func handleAuditCommand(b *bot.Bot, message *tgbotapi.Message) {
+	_, group, ok := commandAccess(b, message, models.CapViewReports, "فقط ادمین‌ها و خزانه‌دار می‌توانند تاریخچه تغییرات را مشاهده کنند.")
+	if !ok {
+		return
+	}
+
+	var userID int64
+	if arg := strings.TrimPrefix(strings.TrimSpace(message.CommandArguments()), "@"); arg != "" {
+		user, err := b.DB.GetUserByUserName(arg)
+		if err != nil {
+			b.SendMessage(message.Chat.ID, fmt.Sprintf("کاربر @%s یافت نشد.", arg), nil)
+			return
+		}
+		userID = user.ID
+	}
+
+	text, keyboard, err := auditPage(b, group.ID, userID, 0)
+	if err != nil {
+		zap.L().Error("Error getting audit events", zap.Error(err), zap.Int64("group_id", group.ID))
+		b.SendMessage(message.Chat.ID, "خطا در دریافت اطلاعات.", nil)
+		return
+	}
+
+	if keyboard == nil {
+		b.SendMessage(message.Chat.ID, text, nil)
+		return
+	}
+	b.SendMessage(message.Chat.ID, text, *keyboard)
+}
+
+// handleAuditCallback turns the pages of an audit log message.
+func handleAuditCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 4 {
+		return
+	}
+
+	groupID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	offset, err := strconv.Atoi(parts[2])
+	if err != nil || offset < 0 {
+		return
+	}
+
+	userID, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return
+	}
+
+	if _, ok := requireCapability(b, callback, groupID, models.CapViewReports); !ok {
+		return
+	}
+
+	text, keyboard, err := auditPage(b, groupID, userID, offset)
+	if err != nil {
+		zap.L().Error("Error getting audit events", zap.Error(err), zap.Int64("group_id", groupID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در دریافت اطلاعات.")
+		return
+	}
+
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, keyboard)
+}
+
+// auditPage renders the audit events of a group starting at offset, with
+// buttons to the newer and older pages when there are any.
+func auditPage(b *bot.Bot, groupID, userID int64, offset int) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	// One extra event tells whether there is an older page.
+	events, err := b.DB.GetAuditEvents(groupID, userID, auditPageSize+1, offset)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(events) == 0 {
+		return "هیچ تغییری ثبت نشده است.", nil, nil
+	}
+
+	older := len(events) > auditPageSize
+	if older {
+		events = events[:auditPageSize]
+	}
+
+	tierNames := map[int64]string{}
+	if tiers, err := b.DB.GetPricingTiers(groupID); err == nil {
+		for _, t := range tiers {
+			tierNames[t.ID] = t.Name
+		}
+	}
+
+	lines := []string{fmt.Sprintf("📜 تاریخچه تغییرات (%d تا %d)", offset+1, offset+len(events))}
+	for _, e := range events {
+		lines = append(lines, "", formatAuditEvent(e, tierNames))
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	if offset > 0 {
+		newer := offset - auditPageSize
+		if newer < 0 {
+			newer = 0
+		}
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("◀️ جدیدتر", fmt.Sprintf("audit:%d:%d:%d", groupID, newer, userID)))
+	}
+	if older {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("قدیمی‌تر ▶️", fmt.Sprintf("audit:%d:%d:%d", groupID, offset+auditPageSize, userID)))
+	}
+
+	if len(row) == 0 {
+		return strings.Join(lines, "\n"), nil, nil
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
+	return strings.Join(lines, "\n"), &keyboard, nil
+}
+
+func formatAuditEvent(e models.AuditEvent, tierNames map[int64]string) string {
+	actor := "🤖 ربات"
+	if e.ActorID != nil {
+		actor = "👤 " + auditName(e.ActorName, *e.ActorID)
+	}
+
+	action := auditActionNames[e.Action]
+	if action == "" {
+		action = string(e.Action)
+	}
+	if e.EntityID != nil {
+		action += fmt.Sprintf(" #%d", *e.EntityID)
+	}
+	if e.UserID != nil {
+		action += " - " + auditName(e.UserName, *e.UserID)
+	}
+
+	lines := []string{
+		fmt.Sprintf("🕒 %s %s", e.CreatedAt.In(time.Local).Format(sessionTimeLayout), actor),
+		action,
+	}
+
+	var before, after map[string]interface{}
+	json.Unmarshal(e.Before, &before)
+	json.Unmarshal(e.After, &after)
+	for _, f := range auditFields {
+		old, hadOld := before[f.key]
+		cur, hasCur := after[f.key]
+		switch {
+		case hadOld && hasCur:
+			if fmt.Sprint(old) == fmt.Sprint(cur) {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("  %s: %s ← %s", f.label,
+				auditValue(f.key, old, tierNames), auditValue(f.key, cur, tierNames)))
+		case hasCur:
+			lines = append(lines, fmt.Sprintf("  %s: %s", f.label, auditValue(f.key, cur, tierNames)))
+		case hadOld:
+			lines = append(lines, fmt.Sprintf("  %s: %s ← -", f.label, auditValue(f.key, old, tierNames)))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func auditName(name string, id int64) string {
+	if name == "" {
+		return fmt.Sprintf("کاربر #%d", id)
+	}
+	return name
+}
+
+// auditValue renders a recorded value the way the rest of the bot shows it.
+func auditValue(key string, v interface{}, tierNames map[int64]string) string {
+	if v == nil {
+		return "-"
+	}
+
+	switch key {
+	case "tier_id":
+		if id, ok := v.(float64); ok {
+			if name, ok := tierNames[int64(id)]; ok {
+				return name
+			}
+			return fmt.Sprintf("#%.0f", id)
+		}
+	case "permission":
+		if name, ok := permissionNames[models.Permission(fmt.Sprint(v))]; ok {
+			return name
+		}
+	case "status":
+		if name, ok := auditStatusNames[fmt.Sprint(v)]; ok {
+			return name
+		}
+	case "method":
+		if name, ok := paymentMethodNames[models.PaymentMethod(fmt.Sprint(v))]; ok {
+			return name
+		}
+	case "kind":
+		if name, ok := adjustmentKindNames[models.AdjustmentKind(fmt.Sprint(v))]; ok {
+			return name
+		}
+	case "pricing_mode":
+		if fmt.Sprint(v) == string(models.PricingSplit) {
+			return "تقسیم هزینه زمین"
+		}
+		return "نرخ ثابت"
+	case "rate", "amount", "venue_cost":
+		if n, ok := v.(float64); ok {
+			return fmt.Sprintf("%.0f تومان", n)
+		}
+	case "session_id":
+		if n, ok := v.(float64); ok {
+			return fmt.Sprintf("#%.0f", n)
+		}
+	case "starts_at":
+		if t, err := time.Parse(time.RFC3339Nano, fmt.Sprint(v)); err == nil {
+			return t.In(time.Local).Format(sessionTimeLayout)
+		}
+	case "capacity":
+		if n, ok := v.(float64); ok && n == 0 {
+			return "نامحدود"
+		}
+	case "user_ids":
+		if ids, ok := v.([]interface{}); ok {
+			return fmt.Sprintf("%d نفر", len(ids))
+		}
+	case "note", "venue", "name":
+		if s := fmt.Sprint(v); s != "" {
+			return s
+		}
+		return "-"
+	}
+
+	if n, ok := v.(float64); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
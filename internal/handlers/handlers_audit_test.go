@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"futsal-bot/internal/models"
+)
+
+func TestFormatAuditEvent(t *testing.T) {
+	actor, user := int64(1), int64(2)
+	at := time.Date(2026, 3, 21, 18, 30, 0, 0, time.Local)
+
+	event := models.AuditEvent{
+		ActorID:   &actor,
+		ActorName: "Sara",
+		Action:    models.AuditMemberArchive,
+		UserID:    &user,
+		Before:    []byte(`{"name": "Ali", "status": "active", "permission": "admin", "tier_id": 5}`),
+		After:     []byte(`{"name": "Ali", "status": "archived", "permission": "member", "tier_id": 6}`),
+		CreatedAt: at,
+	}
+	got := formatAuditEvent(event, map[int64]string{5: "بزرگسال"})
+
+	want := strings.Join([]string{
+		"🕒 " + at.Format(sessionTimeLayout) + " 👤 Sara",
+		"خروج از گروه - کاربر #2",
+		"  نقش: بزرگسال ← #6",
+		"  سطح دسترسی: ادمین ← عضو",
+		"  وضعیت: فعال ← بایگانی",
+	}, "\n")
+	if got != want {
+		t.Errorf("formatAuditEvent =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatAuditEventAddedAndRemoved(t *testing.T) {
+	entity := int64(9)
+	event := models.AuditEvent{
+		Action:    models.AuditTierRateCancel,
+		EntityID:  &entity,
+		Before:    []byte(`{"rate": 150000, "effective_from": "2026-04-01"}`),
+		After:     []byte(`{"note": "x"}`),
+		CreatedAt: time.Date(2026, 3, 21, 18, 30, 0, 0, time.Local),
+	}
+	got := formatAuditEvent(event, nil)
+
+	for _, line := range []string{
+		"🤖 ربات",
+		"لغو تغییر نرخ #9",
+		"  نرخ: 150000 تومان ← -",
+		"  از تاریخ: 2026-04-01 ← -",
+		"  توضیح: x",
+	} {
+		if !strings.Contains(got, line) {
+			t.Errorf("formatAuditEvent doesn't contain %q:\n%s", line, got)
+		}
+	}
+}
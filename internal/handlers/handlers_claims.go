@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"futsal-bot/internal/bot"
+	"futsal-bot/internal/database"
+	"futsal-bot/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// Payment claims let members report a payment themselves. A claim stays
+// pending until one of the group admins approves or rejects it.
+
+func handleClaimCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 2 {
+		return
+	}
+
+	groupID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	user, err := b.DB.GetUserByTelegramID(callback.From.ID)
+	if err != nil {
+		b.SendMessage(callback.Message.Chat.ID, "خطا در دریافت اطلاعات کاربر.", nil)
+		return
+	}
+
+	if ug, err := b.DB.GetUserGroup(user.ID, groupID); err != nil || ug.Status != models.MembershipActive {
+		b.AnswerCallbackQuery(callback.ID, "شما عضو تایید شده این گروه نیستید.")
+		return
+	}
+
+	balance, err := b.DB.GetBalance(user.ID, groupID)
+	if err != nil {
+		zap.L().Error("Error getting balance", zap.Error(err), zap.Int64("user_id", user.ID), zap.Int64("group_id", groupID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در دریافت اطلاعات.")
+		return
+	}
+
+	b.SetState(callback.From.ID, callback.Message.Chat.ID, "awaiting_claim_amount", &models.ClaimFlow{
+		GroupID: groupID,
+		UserID:  user.ID,
+	})
+
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID,
+		balanceLine(balance.Balance)+"\n\nمبلغی که پرداخت کرده‌اید را به تومان وارد کنید:", nil)
+}
+
+func handleClaimAmountInput(b *bot.Bot, message *tgbotapi.Message, flow *models.ClaimFlow) {
+	amount, err := parseAmount(message.Text)
+	if err != nil {
+		b.SendMessage(message.Chat.ID, "لطفا یک مبلغ معتبر وارد کنید:", nil)
+		return
+	}
+
+	flow.Amount = amount
+	b.SetState(message.From.ID, message.Chat.ID, "awaiting_claim_receipt", flow)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⏭ بدون رسید", "claim_skip"),
+		),
+	)
+	b.SendMessage(message.Chat.ID, fmt.Sprintf("مبلغ: %.0f تومان\n\nدر صورت تمایل عکس رسید پرداخت را ارسال کنید:", amount), keyboard)
+}
+
+func handleClaimReceiptInput(b *bot.Bot, message *tgbotapi.Message, flow *models.ClaimFlow) {
+	if len(message.Photo) == 0 {
+		b.SendMessage(message.Chat.ID, "لطفا عکس رسید را ارسال کنید یا «بدون رسید» را بزنید.", nil)
+		return
+	}
+
+	// Telegram lists the sizes of a photo from smallest to largest
+	fileID := message.Photo[len(message.Photo)-1].FileID
+	submitPaymentClaim(b, message.From.ID, message.Chat.ID, flow, fileID)
+}
+
+func handleClaimSkipCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	flow, ok := flowAt[*models.ClaimFlow](b, callback, models.FlowClaim, "awaiting_claim_receipt")
+	if !ok {
+		return
+	}
+
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, "بدون رسید.", nil)
+	submitPaymentClaim(b, callback.From.ID, callback.Message.Chat.ID, flow, "")
+}
+
+func submitPaymentClaim(b *bot.Bot, telegramID, chatID int64, flow *models.ClaimFlow, receiptFileID string) {
+	groupID := flow.GroupID
+	userID := flow.UserID
+	amount := flow.Amount
+
+	b.ClearState(telegramID, chatID, models.FlowClaim)
+
+	ug, err := b.DB.GetUserGroup(userID, groupID)
+	if err != nil {
+		b.SendMessage(chatID, "شما در این گروه ثبت نام نکرده‌اید.", nil)
+		return
+	}
+
+	payment, err := b.DB.CreatePaymentClaim(groupID, userID, amount, receiptFileID)
+	if err != nil {
+		zap.L().Error("Error creating payment claim", zap.Error(err), zap.Int64("user_id", userID), zap.Int64("group_id", groupID))
+		b.SendMessage(chatID, "خطا در ثبت پرداخت. لطفا دوباره تلاش کنید.", nil)
+		return
+	}
+
+	b.SendMessage(chatID, fmt.Sprintf("✅ پرداخت %.0f تومانی شما ثبت شد و پس از تایید ادمین در حساب شما اعمال می‌شود.", amount), nil)
+
+	notifyClaimAdmins(b, payment, ug.Name)
+}
+
+// notifyClaimAdmins sends a pending claim to every admin of its group. The
+// first admin to review it wins; the others are told it was already handled.
+func notifyClaimAdmins(b *bot.Bot, payment *models.Payment, name string) {
+	text := claimText(payment, name)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ تایید", fmt.Sprintf("claim_ok:%d", payment.ID)),
+			tgbotapi.NewInlineKeyboardButtonData("❌ رد", fmt.Sprintf("claim_no:%d", payment.ID)),
+		),
+	)
+
+	for _, chatID := range staffChatIDs(b, payment.GroupID, models.CapManagePayments) {
+		if payment.ReceiptFileID != "" {
+			if err := b.SendPhoto(chatID, payment.ReceiptFileID, fmt.Sprintf("رسید پرداخت %s", name)); err != nil {
+				zap.L().Warn("Error sending receipt", zap.Error(err), zap.Int64("chat_id", chatID))
+			}
+		}
+		if err := b.SendMessage(chatID, text, keyboard); err != nil {
+			zap.L().Warn("Error sending payment claim", zap.Error(err), zap.Int64("chat_id", chatID))
+		}
+	}
+}
+
+func claimText(payment *models.Payment, name string) string {
+	text := fmt.Sprintf(
+		"🧾 درخواست تایید پرداخت\n\n"+
+			"کاربر: %s\n"+
+			"مبلغ: %.0f تومان",
+		name, payment.Amount,
+	)
+	if payment.ReceiptFileID == "" {
+		text += "\nرسید: ندارد"
+	}
+	return text
+}
+
+func handleClaimReviewCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string, approve bool) {
+	if len(parts) < 2 {
+		return
+	}
+
+	paymentID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	payment, err := b.DB.GetPayment(paymentID)
+	if err != nil {
+		b.AnswerCallbackQuery(callback.ID, "پرداخت پیدا نشد.")
+		return
+	}
+
+	admin, ok := requireCapability(b, callback, payment.GroupID, models.CapManagePayments)
+	if !ok {
+		return
+	}
+
+	name := fmt.Sprintf("%d", payment.UserID)
+	if ug, err := b.DB.GetUserGroup(payment.UserID, payment.GroupID); err == nil {
+		name = ug.Name
+	}
+
+	payment, err = b.DB.ReviewPaymentClaim(paymentID, admin.ID, approve)
+	if errors.Is(err, database.ErrPaymentReviewed) {
+		b.AnswerCallbackQuery(callback.ID, "این پرداخت قبلا بررسی شده است.")
+		b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID,
+			claimText(payment, name)+"\n\n"+claimStatusNames[payment.Status], nil)
+		return
+	}
+	if err != nil {
+		zap.L().Error("Error reviewing payment claim", zap.Error(err), zap.Int64("payment_id", paymentID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در بررسی پرداخت.")
+		return
+	}
+
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID,
+		fmt.Sprintf("%s\n\n%s توسط %s", claimText(payment, name), claimStatusNames[payment.Status], callback.From.FirstName), nil)
+
+	member, err := b.DB.GetUser(payment.UserID)
+	if err != nil {
+		return
+	}
+
+	text := fmt.Sprintf("❌ پرداخت %.0f تومانی شما توسط ادمین رد شد. در صورت اشتباه با ادمین گروه تماس بگیرید.", payment.Amount)
+	if approve {
+		text = fmt.Sprintf("✅ پرداخت %.0f تومانی شما تایید شد.", payment.Amount)
+		if balance, err := b.DB.GetBalance(payment.UserID, payment.GroupID); err == nil {
+			text += "\n\n" + balanceLine(balance.Balance)
+		}
+	}
+	b.SendMessage(member.TelegramID, text, nil)
+}
+
+var claimStatusNames = map[models.PaymentStatus]string{
+	models.PaymentPending:  "⏳ در انتظار تایید",
+	models.PaymentApproved: "✅ تایید شد",
+	models.PaymentRejected: "❌ رد شد",
+}
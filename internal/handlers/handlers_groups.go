@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"futsal-bot/internal/bot"
+	"futsal-bot/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// accessibleGroups returns the groups a user may open in the private menu:
+// the ones they are an active member of, and the others whose Telegram chat
+// they are in. Superadmins see every group.
+func accessibleGroups(b *bot.Bot, user *models.User, telegramID int64) ([]models.Group, error) {
+	allGroups, err := b.DB.GetAllGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	if b.IsSuperadmin(telegramID) {
+		return allGroups, nil
+	}
+
+	groupIDs, err := b.DB.GetUserGroups(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	active := make(map[int64]bool, len(groupIDs))
+	for _, id := range groupIDs {
+		active[id] = true
+	}
+
+	// Members who leave a chat are archived, so only the other groups need
+	// asking the platform about
+	var groups []models.Group
+	for _, g := range allGroups {
+		if active[g.ID] || inGroupChat(b, &g, telegramID) {
+			groups = append(groups, g)
+		}
+	}
+
+	return groups, nil
+}
+
+// canAccessGroup is accessibleGroups for a single group.
+func canAccessGroup(b *bot.Bot, user *models.User, telegramID int64, group *models.Group) bool {
+	if b.IsSuperadmin(telegramID) {
+		return true
+	}
+	ug, err := b.DB.GetUserGroup(user.ID, group.ID)
+	if err == nil && ug.Status == models.MembershipActive {
+		return true
+	}
+	return inGroupChat(b, group, telegramID)
+}
+
+// inGroupChat asks Telegram whether a user is in a group's chat. When the
+// chat can't be queried, for example because the bot was removed from it,
+// the user is treated as not in it.
+func inGroupChat(b *bot.Bot, group *models.Group, telegramID int64) bool {
+	member, err := b.WasChatMember(group.TelegramChatID, telegramID)
+	if err != nil {
+		zap.L().Warn("Error checking chat membership", zap.Error(err), zap.Int64("chat_id", group.TelegramChatID), zap.Int64("user_id", telegramID))
+		return false
+	}
+	return member
+}
+
+// requireCapability makes sure the presser of a private-menu button may do
+// something in the given group.
+func requireCapability(b *bot.Bot, callback *tgbotapi.CallbackQuery, groupID int64, c models.Capability) (*models.User, bool) {
+	access, err := b.Authorize(callback.From.ID, groupID)
+	if err != nil {
+		b.SendMessage(callback.Message.Chat.ID, "خطا در دریافت اطلاعات کاربر.", nil)
+		return nil, false
+	}
+
+	if !access.Can(c) {
+		b.AnswerCallbackQuery(callback.ID, "شما دسترسی لازم را ندارید.")
+		return nil, false
+	}
+
+	return access.User, true
+}
+
+// commandAccess resolves the group a command was sent in and makes sure the
+// sender may do something there, replying with denied when they may not.
+func commandAccess(b *bot.Bot, message *tgbotapi.Message, c models.Capability, denied string) (*models.User, *models.Group, bool) {
+	group, err := b.DB.GetGroupByTelegramChatID(message.Chat.ID)
+	if err != nil {
+		b.SendMessage(message.Chat.ID, "این گروه در سیستم ثبت نشده است.", nil)
+		return nil, nil, false
+	}
+
+	access, err := b.Authorize(message.From.ID, group.ID)
+	if err != nil {
+		b.SendMessage(message.Chat.ID, "خطا در دریافت اطلاعات کاربر.", nil)
+		return nil, nil, false
+	}
+
+	if !access.Can(c) {
+		b.SendMessage(message.Chat.ID, denied, nil)
+		return nil, nil, false
+	}
+
+	return access.User, group, true
+}
+
+// menuPermission is the permission the main menu of a group is built for.
+func menuPermission(b *bot.Bot, telegramID, groupID int64) models.Permission {
+	access, err := b.Authorize(telegramID, groupID)
+	if err != nil {
+		return models.PermissionNone
+	}
+	return access.Permission
+}
+
+func mainMenuText(group *models.Group) string {
+	return "👥 گروه: " + group.Title + "\n\nمنوی اصلی:"
+}
+
+// handleGroupsCallback shows the group picker.
+func handleGroupsCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	user, err := b.DB.GetUserByTelegramID(callback.From.ID)
+	if err != nil {
+		b.SendMessage(callback.Message.Chat.ID, "خطا در دریافت اطلاعات کاربر.", nil)
+		return
+	}
+
+	groups, err := accessibleGroups(b, user, callback.From.ID)
+	if err != nil {
+		zap.L().Error("Error getting groups", zap.Error(err), zap.Int64("user_id", user.ID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در دریافت گروه‌ها.")
+		return
+	}
+
+	if len(groups) == 0 {
+		b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, "شما عضو هیچ گروهی نیستید که ربات در آن فعال باشد.", nil)
+		return
+	}
+
+	var current int64
+	if user.CurrentGroupID != nil {
+		current = *user.CurrentGroupID
+	}
+
+	keyboard := b.GroupPickerKeyboard(groups, current)
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, "گروه مورد نظر را انتخاب کنید:", &keyboard)
+}
+
+// handleGroupCallback opens a group picked from the group picker and
+// remembers it for the next /start.
+func handleGroupCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 2 {
+		return
+	}
+
+	groupID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	user, err := b.DB.GetUserByTelegramID(callback.From.ID)
+	if err != nil {
+		b.SendMessage(callback.Message.Chat.ID, "خطا در دریافت اطلاعات کاربر.", nil)
+		return
+	}
+
+	group, err := b.DB.GetGroup(groupID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !canAccessGroup(b, user, callback.From.ID, group)) {
+		b.AnswerCallbackQuery(callback.ID, "شما به این گروه دسترسی ندارید.")
+		return
+	}
+	if err != nil {
+		zap.L().Error("Error getting group", zap.Error(err), zap.Int64("group_id", groupID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در دریافت اطلاعات.")
+		return
+	}
+
+	if err := b.DB.SetCurrentGroup(user.ID, groupID); err != nil {
+		zap.L().Error("Error setting current group", zap.Error(err), zap.Int64("user_id", user.ID))
+	}
+
+	keyboard := b.MainMenuKeyboard(user.ID, groupID, menuPermission(b, callback.From.ID, groupID))
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, mainMenuText(group), &keyboard)
+}
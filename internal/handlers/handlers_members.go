@@ -0,0 +1,435 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"futsal-bot/internal/bot"
+	"futsal-bot/internal/invite"
+	"futsal-bot/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// staffChatIDs returns the private chats of everyone who should hear about
+// requests in a group that need a capability: the members allowed to act on
+// them and the superadmins.
+func staffChatIDs(b *bot.Bot, groupID int64, c models.Capability) []int64 {
+	adminIDs, err := b.DB.GetGroupTelegramIDsWithPermissions(groupID, models.PermissionsWith(c))
+	if err != nil {
+		zap.L().Error("Error getting group staff", zap.Error(err), zap.Int64("group_id", groupID))
+	}
+
+	seen := map[int64]bool{}
+	var chatIDs []int64
+	for _, id := range append(append([]int64{}, b.SuperadminIDs...), adminIDs...) {
+		if id != 0 && !seen[id] {
+			seen[id] = true
+			chatIDs = append(chatIDs, id)
+		}
+	}
+	return chatIDs
+}
+
+// claimGroupOwnership makes whoever added the bot to a group its owner,
+// provided they administer the group chat and the group has no owner yet.
+// The owner is told which tier they are charged at, since they never picked
+// one.
+func claimGroupOwnership(b *bot.Bot, group *models.Group, from *tgbotapi.User) {
+	if from == nil || from.IsBot {
+		return
+	}
+
+	owners, err := b.DB.GetGroupTelegramIDsWithPermissions(group.ID, []models.Permission{models.PermissionOwner})
+	if err != nil {
+		zap.L().Error("Error getting group owners", zap.Error(err), zap.Int64("group_id", group.ID))
+		return
+	}
+	if len(owners) > 0 {
+		return
+	}
+
+	admin, err := b.IsChatAdmin(group.TelegramChatID, from.ID)
+	if err != nil {
+		zap.L().Error("Error checking chat admin", zap.Error(err), zap.Int64("chat_id", group.TelegramChatID), zap.Int64("telegram_id", from.ID))
+		return
+	}
+	if !admin {
+		zap.L().Info("Bot added by a non-admin, group left without owner", zap.Int64("group_id", group.ID), zap.Int64("telegram_id", from.ID))
+		return
+	}
+
+	user, err := b.DB.GetOrCreateUser(from.ID, from.UserName, from.FirstName, from.LastName, from.IsBot)
+	if err != nil {
+		zap.L().Error("Error getting/creating user", zap.Error(err), zap.Int64("user_id", from.ID))
+		return
+	}
+
+	name := strings.TrimSpace(from.FirstName + " " + from.LastName)
+	ug, err := b.DB.AddGroupOwner(user.ID, group.ID, name)
+	if err != nil {
+		zap.L().Error("Error adding group owner", zap.Error(err), zap.Int64("user_id", user.ID), zap.Int64("group_id", group.ID))
+		return
+	}
+
+	zap.L().Info("Group owner added", zap.Int64("user_id", user.ID), zap.Int64("group_id", group.ID))
+
+	if ug.TierID == nil {
+		return
+	}
+	tier, err := b.DB.GetPricingTier(*ug.TierID)
+	if err != nil {
+		zap.L().Error("Error getting pricing tier", zap.Error(err), zap.Int64("tier_id", *ug.TierID))
+		return
+	}
+	b.SendMessage(group.TelegramChatID,
+		fmt.Sprintf("%s مالک این گروه شد و با نقش «%s» ثبت شد. برای تغییر نقش، از «✏️ ویرایش مشخصات» در پیوی ربات استفاده کنید.", name, tier.Name),
+		nil)
+}
+
+// handleInviteStart opens registration for the group a /start invite token
+// points to. The token stands in for Telegram chat membership, so users can
+// join a group before they are in its chat.
+func handleInviteStart(b *bot.Bot, message *tgbotapi.Message, user *models.User, token string) {
+	chatID := message.Chat.ID
+
+	groupID, err := b.VerifyInvite(token)
+	if errors.Is(err, invite.ErrExpired) {
+		b.SendMessage(chatID, "⏰ این لینک دعوت منقضی شده است. از ادمین گروه لینک جدید بگیرید.", nil)
+		return
+	}
+	if err != nil {
+		b.SendMessage(chatID, "لینک دعوت نامعتبر است.", nil)
+		return
+	}
+
+	group, err := b.DB.GetGroup(groupID)
+	if err != nil {
+		b.SendMessage(chatID, "لینک دعوت نامعتبر است.", nil)
+		return
+	}
+
+	if err := b.DB.SetCurrentGroup(user.ID, group.ID); err != nil {
+		zap.L().Error("Error setting current group", zap.Error(err), zap.Int64("user_id", user.ID))
+	}
+
+	ug, err := b.DB.GetUserGroup(user.ID, group.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		zap.L().Error("Error getting user group", zap.Error(err), zap.Int64("user_id", user.ID), zap.Int64("group_id", group.ID))
+		b.SendMessage(chatID, "خطا در دریافت اطلاعات. لطفا دوباره تلاش کنید.", nil)
+		return
+	}
+
+	text := fmt.Sprintf("👋 شما به گروه %s دعوت شده‌اید.\n\nلطفا نام خود را وارد کنید:", group.Title)
+	if err == nil {
+		switch ug.Status {
+		case models.MembershipActive:
+			// Already a member: just open the group
+			keyboard := b.MainMenuKeyboard(user.ID, group.ID, menuPermission(b, message.From.ID, group.ID))
+			b.SendMessage(chatID, mainMenuText(group), keyboard)
+			return
+		case models.MembershipPending:
+			keyboard := b.MainMenuKeyboard(user.ID, group.ID, models.PermissionNone)
+			b.SendMessage(chatID, fmt.Sprintf("⏳ درخواست عضویت شما در گروه %s ثبت شده و در انتظار تایید ادمین است.", group.Title), keyboard)
+			return
+		case models.MembershipArchived:
+			// Members who left register again and wait for approval
+			text = fmt.Sprintf("👋 شما به گروه %s دعوت شده‌اید. برای بازگشت به گروه دوباره ثبت نام کنید.\n\nلطفا نام خود را وارد کنید:", group.Title)
+		}
+	}
+
+	b.SetState(message.From.ID, chatID, "awaiting_name", &models.RegistrationFlow{
+		GroupID: group.ID,
+	})
+
+	b.SendMessage(chatID, text, nil)
+}
+
+// notifyMembershipRequest asks the admins of a group to review a new
+// registration.
+func notifyMembershipRequest(b *bot.Bot, ug *models.UserGroup) {
+	title := ""
+	if group, err := b.DB.GetGroup(ug.GroupID); err == nil {
+		title = group.Title
+	}
+
+	text := fmt.Sprintf("📨 درخواست عضویت جدید\n\nگروه: %s\nنام: %s", title, ug.Name)
+	if user, err := b.DB.GetUser(ug.UserID); err == nil && user.Username != "" {
+		text += "\nنام کاربری: @" + user.Username
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ تایید", fmt.Sprintf("member_ok:%d:%d", ug.UserID, ug.GroupID)),
+			tgbotapi.NewInlineKeyboardButtonData("❌ رد", fmt.Sprintf("member_no:%d:%d", ug.UserID, ug.GroupID)),
+		),
+	)
+
+	for _, chatID := range staffChatIDs(b, ug.GroupID, models.CapManageMembers) {
+		if err := b.SendMessage(chatID, text, keyboard); err != nil {
+			zap.L().Warn("Error sending membership request", zap.Error(err), zap.Int64("chat_id", chatID))
+		}
+	}
+}
+
+func handleMemberReviewCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string, approve bool) {
+	if len(parts) < 3 {
+		return
+	}
+
+	targetUserID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	groupID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return
+	}
+
+	admin, ok := requireCapability(b, callback, groupID, models.CapManageMembers)
+	if !ok {
+		return
+	}
+
+	ug, err := b.DB.GetUserGroup(targetUserID, groupID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		zap.L().Error("Error getting user group", zap.Error(err), zap.Int64("user_id", targetUserID), zap.Int64("group_id", groupID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در دریافت اطلاعات.")
+		return
+	}
+
+	err = b.DB.ReviewUserGroup(targetUserID, groupID, approve, admin.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		b.AnswerCallbackQuery(callback.ID, "این درخواست قبلا بررسی شده است.")
+		b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, "این درخواست قبلا بررسی شده است.", nil)
+		return
+	}
+	if err != nil {
+		zap.L().Error("Error reviewing membership", zap.Error(err), zap.Int64("user_id", targetUserID), zap.Int64("group_id", groupID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در بررسی درخواست.")
+		return
+	}
+
+	group, err := b.DB.GetGroup(groupID)
+	if err != nil {
+		return
+	}
+
+	result := "❌ رد شد"
+	if approve {
+		result = "✅ تایید شد"
+	}
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID,
+		fmt.Sprintf("درخواست عضویت %s در گروه %s %s توسط %s", ug.Name, group.Title, result, callback.From.FirstName), nil)
+
+	member, err := b.DB.GetUser(targetUserID)
+	if err != nil {
+		return
+	}
+
+	if !approve {
+		b.SendMessage(member.TelegramID, fmt.Sprintf("❌ درخواست عضویت شما در گروه %s رد شد.", group.Title), nil)
+		return
+	}
+
+	keyboard := b.MainMenuKeyboard(member.ID, groupID, menuPermission(b, member.TelegramID, groupID))
+	b.SendMessage(member.TelegramID, fmt.Sprintf("✅ عضویت شما در گروه %s تایید شد.\n\n%s", group.Title, mainMenuText(group)), keyboard)
+}
+
+// handleMembersCallback lists pending registrations of a group for review.
+func handleMembersCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 2 {
+		return
+	}
+
+	groupID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	if _, ok := requireCapability(b, callback, groupID, models.CapManageMembers); !ok {
+		return
+	}
+
+	pending, err := b.DB.GetPendingUserGroups(groupID)
+	if err != nil {
+		zap.L().Error("Error getting pending members", zap.Error(err), zap.Int64("group_id", groupID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در دریافت اطلاعات.")
+		return
+	}
+
+	text := "📨 درخواست‌های عضویت\n\nهیچ درخواستی در انتظار تایید نیست."
+	if len(pending) > 0 {
+		text = fmt.Sprintf("📨 درخواست‌های عضویت\n\n%d درخواست در انتظار تایید است:", len(pending))
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, ug := range pending {
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("✅ "+ug.Name, fmt.Sprintf("member_ok:%d:%d", ug.UserID, groupID)),
+			tgbotapi.NewInlineKeyboardButtonData("❌ رد", fmt.Sprintf("member_no:%d:%d", ug.UserID, groupID)),
+		})
+	}
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("🔗 ساخت لینک دعوت", fmt.Sprintf("invite:%d", groupID)),
+	})
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", fmt.Sprintf("back:%d", groupID)),
+	})
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
+}
+
+func handleInviteCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 2 {
+		return
+	}
+
+	groupID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	if _, ok := requireCapability(b, callback, groupID, models.CapManageMembers); !ok {
+		return
+	}
+
+	link, expiresAt, ok := b.InviteLink(groupID)
+	if !ok {
+		b.AnswerCallbackQuery(callback.ID, "لینک دعوت فعال نیست. INVITE_SECRET را تنظیم کنید.")
+		return
+	}
+
+	text := fmt.Sprintf(
+		"🔗 لینک دعوت\n\n%s\n\n"+
+			"این لینک تا %s معتبر است. افرادی که با آن ثبت نام کنند پس از تایید ادمین عضو می‌شوند. "+
+			"برای دسترسی به منوی ربات باید عضو گروه تلگرامی هم باشند.",
+		link, expiresAt.In(time.Local).Format(sessionTimeLayout),
+	)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", fmt.Sprintf("members:%d", groupID)),
+		),
+	)
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
+}
+
+// handleMemberJoined puts the archived membership of a user who came back
+// to a group chat up for staff approval again.
+func handleMemberJoined(b *bot.Bot, chatID int64, member *tgbotapi.User) {
+	b.ForgetChatMember(chatID, member.ID)
+
+	group, err := b.DB.GetGroupByTelegramChatID(chatID)
+	if err != nil {
+		return
+	}
+
+	user, err := b.DB.GetUserByTelegramID(member.ID)
+	if err != nil {
+		return
+	}
+
+	ug, err := b.DB.ReopenUserGroup(user.ID, group.ID, 0)
+	if err != nil {
+		zap.L().Error("Error reopening membership", zap.Error(err), zap.Int64("user_id", user.ID), zap.Int64("group_id", group.ID))
+		return
+	}
+	if ug != nil {
+		zap.L().Info("Membership reopened for approval", zap.Int64("user_id", user.ID), zap.Int64("group_id", group.ID))
+		notifyMembershipRequest(b, ug)
+	}
+}
+
+// handleMemberLeft archives the membership of a user who left a group chat.
+func handleMemberLeft(b *bot.Bot, chatID int64, member *tgbotapi.User) {
+	b.ForgetChatMember(chatID, member.ID)
+
+	group, err := b.DB.GetGroupByTelegramChatID(chatID)
+	if err != nil {
+		return
+	}
+
+	user, err := b.DB.GetUserByTelegramID(member.ID)
+	if err != nil {
+		return
+	}
+
+	archived, err := b.DB.ArchiveUserGroup(user.ID, group.ID, 0)
+	if err != nil {
+		zap.L().Error("Error archiving membership", zap.Error(err), zap.Int64("user_id", user.ID), zap.Int64("group_id", group.ID))
+		return
+	}
+	if archived {
+		zap.L().Info("Membership archived", zap.Int64("user_id", user.ID), zap.Int64("group_id", group.ID))
+	}
+}
+
+// handleSyncCommand reconciles the memberships of a group with its chat.
+// Telegram doesn't let bots list the members of a chat, so every known
+// membership is checked one by one instead. Pending registrations are left
+// alone: invitees may register before they join the chat.
+func handleSyncCommand(b *bot.Bot, message *tgbotapi.Message) {
+	admin, group, ok := commandAccess(b, message, models.CapManageMembers, "فقط ادمین‌ها می‌توانند اعضا را همگام‌سازی کنند.")
+	if !ok {
+		return
+	}
+
+	memberships, err := b.DB.GetGroupMemberships(group.ID)
+	if err != nil {
+		zap.L().Error("Error getting memberships", zap.Error(err), zap.Int64("group_id", group.ID))
+		b.SendMessage(message.Chat.ID, "خطا در دریافت اطلاعات.", nil)
+		return
+	}
+
+	var archived, restored, failed []string
+	for _, ug := range memberships {
+		user, err := b.DB.GetUser(ug.UserID)
+		if err != nil {
+			failed = append(failed, ug.Name)
+			continue
+		}
+
+		inChat, err := b.IsChatMember(message.Chat.ID, user.TelegramID)
+		if err != nil {
+			zap.L().Warn("Error checking chat membership", zap.Error(err), zap.Int64("chat_id", message.Chat.ID), zap.Int64("user_id", user.TelegramID))
+			failed = append(failed, ug.Name)
+			continue
+		}
+
+		switch {
+		case inChat && ug.Status == models.MembershipArchived:
+			if reopened, err := b.DB.ReopenUserGroup(ug.UserID, group.ID, admin.ID); err == nil && reopened != nil {
+				restored = append(restored, ug.Name)
+				notifyMembershipRequest(b, reopened)
+			}
+		case !inChat && ug.Status == models.MembershipActive:
+			if ok, err := b.DB.ArchiveUserGroup(ug.UserID, group.ID, admin.ID); err == nil && ok {
+				archived = append(archived, ug.Name)
+			}
+		}
+	}
+
+	lines := []string{fmt.Sprintf("🔄 همگام‌سازی اعضا انجام شد (%d عضویت بررسی شد).", len(memberships))}
+	if len(archived) > 0 {
+		lines = append(lines, "", "📦 خارج شده از گروه (بایگانی شد): "+strings.Join(archived, "، "))
+	}
+	if len(restored) > 0 {
+		lines = append(lines, "", "♻️ بازگشته به گروه (در انتظار تایید): "+strings.Join(restored, "، "))
+	}
+	if len(failed) > 0 {
+		lines = append(lines, "", "⚠️ بررسی نشد: "+strings.Join(failed, "، "))
+	}
+	if len(archived)+len(restored)+len(failed) == 0 {
+		lines = append(lines, "", "همه عضویت‌ها با گروه هماهنگ است ✅")
+	}
+
+	b.SendMessage(message.Chat.ID, strings.Join(lines, "\n"), nil)
+}
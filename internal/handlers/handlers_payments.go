@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"futsal-bot/internal/bot"
+	"futsal-bot/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// maxPaymentHistory caps how many payments the history view lists.
+const maxPaymentHistory = 10
+
+var paymentMethodNames = map[models.PaymentMethod]string{
+	models.PaymentCash:     "نقدی",
+	models.PaymentCard:     "کارت‌خوان",
+	models.PaymentTransfer: "کارت به کارت",
+}
+
+// balanceLine describes a member balance as debt, credit or settled.
+func balanceLine(balance float64) string {
+	switch {
+	case balance > 0:
+		return fmt.Sprintf("بدهی باقیمانده: %.0f تومان", balance)
+	case balance < 0:
+		return fmt.Sprintf("طلب: %.0f تومان", -balance)
+	default:
+		return "حساب تسویه است ✅"
+	}
+}
+
+var errInvalidAmount = errors.New("invalid amount")
+
+// maxAmount is the largest amount in whole toman the DECIMAL(12, 2) amount
+// columns hold.
+const maxAmount = 9999999999
+
+// parseNumber reads a number typed by a user. Persian and Arabic digits and
+// thousands separators are accepted; NaN and infinities are not.
+func parseNumber(text string) (float64, error) {
+	normalized := strings.Map(func(r rune) rune {
+		switch {
+		case r >= '۰' && r <= '۹':
+			return '0' + (r - '۰')
+		case r >= '٠' && r <= '٩':
+			return '0' + (r - '٠')
+		case r == ',' || r == '٬' || r == '،' || r == ' ':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(text))
+
+	number, err := strconv.ParseFloat(normalized, 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, errInvalidAmount
+	}
+
+	return number, nil
+}
+
+// parseAmount reads a positive toman amount typed by a user.
+func parseAmount(text string) (float64, error) {
+	amount, err := parseNumber(text)
+	if err != nil || amount <= 0 || amount > maxAmount {
+		return 0, errInvalidAmount
+	}
+
+	return amount, nil
+}
+
+func handlePayCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 3 {
+		return
+	}
+
+	targetUserID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	groupID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return
+	}
+
+	admin, ok := requireCapability(b, callback, groupID, models.CapManagePayments)
+	if !ok {
+		return
+	}
+
+	balance, err := b.DB.GetBalance(targetUserID, groupID)
+	if err != nil {
+		zap.L().Error("Error getting balance", zap.Error(err), zap.Int64("user_id", targetUserID), zap.Int64("group_id", groupID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در دریافت اطلاعات.")
+		return
+	}
+
+	b.SetState(callback.From.ID, callback.Message.Chat.ID, "awaiting_payment_amount", &models.PaymentFlow{
+		GroupID:      groupID,
+		TargetUserID: targetUserID,
+		AdminID:      admin.ID,
+	})
+
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID,
+		balanceLine(balance.Balance)+"\n\nمبلغ پرداختی را به تومان وارد کنید:", nil)
+}
+
+func handlePaymentAmountInput(b *bot.Bot, message *tgbotapi.Message, flow *models.PaymentFlow) {
+	amount, err := parseAmount(message.Text)
+	if err != nil {
+		b.SendMessage(message.Chat.ID, "لطفا یک مبلغ معتبر وارد کنید:", nil)
+		return
+	}
+
+	flow.Amount = amount
+	b.SetState(message.From.ID, message.Chat.ID, "awaiting_payment_method", flow)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💵 "+paymentMethodNames[models.PaymentCash], "pay_method:cash"),
+			tgbotapi.NewInlineKeyboardButtonData("💳 "+paymentMethodNames[models.PaymentCard], "pay_method:card"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🏦 "+paymentMethodNames[models.PaymentTransfer], "pay_method:transfer"),
+			tgbotapi.NewInlineKeyboardButtonData("⏭ نامشخص", "pay_method:none"),
+		),
+	)
+	b.SendMessage(message.Chat.ID, fmt.Sprintf("مبلغ: %.0f تومان\n\nروش پرداخت را انتخاب کنید:", amount), keyboard)
+}
+
+func handlePayMethodCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 2 {
+		return
+	}
+
+	flow, ok := flowAt[*models.PaymentFlow](b, callback, models.FlowPayment, "awaiting_payment_method")
+	if !ok {
+		return
+	}
+
+	method := models.PaymentMethod(parts[1])
+	if _, ok := paymentMethodNames[method]; !ok {
+		method = ""
+	}
+
+	flow.Method = method
+	b.SetState(callback.From.ID, callback.Message.Chat.ID, "awaiting_payment_note", flow)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⏭ بدون توضیح", "pay_note_skip"),
+		),
+	)
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, "در صورت نیاز توضیحی برای این پرداخت بنویسید:", &keyboard)
+}
+
+func handlePaymentNoteInput(b *bot.Bot, message *tgbotapi.Message, flow *models.PaymentFlow) {
+	savePayment(b, message.From.ID, message.Chat.ID, flow, strings.TrimSpace(message.Text))
+}
+
+func handlePayNoteSkipCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	flow, ok := flowAt[*models.PaymentFlow](b, callback, models.FlowPayment, "awaiting_payment_note")
+	if !ok {
+		return
+	}
+
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, "بدون توضیح.", nil)
+	savePayment(b, callback.From.ID, callback.Message.Chat.ID, flow, "")
+}
+
+func savePayment(b *bot.Bot, telegramID, chatID int64, flow *models.PaymentFlow, note string) {
+	targetUserID := flow.TargetUserID
+	groupID := flow.GroupID
+	adminID := flow.AdminID
+	amount := flow.Amount
+	method := flow.Method
+
+	b.ClearState(telegramID, chatID, models.FlowPayment)
+
+	ug, err := b.DB.GetUserGroup(targetUserID, groupID)
+	if err != nil {
+		zap.L().Error("Error getting user group", zap.Error(err), zap.Int64("user_id", targetUserID), zap.Int64("group_id", groupID))
+		b.SendMessage(chatID, "خطا در دریافت اطلاعات کاربر.", nil)
+		return
+	}
+
+	_, err = b.DB.RecordPayment(groupID, targetUserID, amount, method, note, adminID)
+	if err != nil {
+		zap.L().Error("Error recording payment", zap.Error(err), zap.Int64("user_id", targetUserID), zap.Int64("group_id", groupID))
+		b.SendMessage(chatID, "خطا در ثبت پرداخت.", nil)
+		return
+	}
+
+	text := fmt.Sprintf(
+		"✅ پرداخت ثبت شد.\n\n"+
+			"کاربر: %s\n"+
+			"مبلغ: %.0f تومان",
+		ug.Name, amount,
+	)
+	if name, ok := paymentMethodNames[method]; ok {
+		text += "\nروش: " + name
+	}
+	if note != "" {
+		text += "\nتوضیحات: " + note
+	}
+
+	if balance, err := b.DB.GetBalance(targetUserID, groupID); err == nil {
+		text += "\n\n" + balanceLine(balance.Balance)
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", fmt.Sprintf("settle_user:%d:%d", targetUserID, groupID)),
+		),
+	)
+	b.SendMessage(chatID, text, keyboard)
+}
+
+func paymentLine(p models.Payment) string {
+	line := fmt.Sprintf("• %s — %.0f تومان", p.CreatedAt.In(time.Local).Format(sessionTimeLayout), p.Amount)
+	if name, ok := paymentMethodNames[p.Method]; ok {
+		line += " — " + name
+	}
+	if p.Status != models.PaymentApproved {
+		line += " — " + claimStatusNames[p.Status]
+	}
+	if p.Note != "" {
+		line += "\n   " + p.Note
+	}
+	return line
+}
+
+func handlePaymentsCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 3 {
+		return
+	}
+
+	targetUserID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	groupID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return
+	}
+
+	if _, ok := requireCapability(b, callback, groupID, models.CapManagePayments); !ok {
+		return
+	}
+
+	ug, err := b.DB.GetUserGroup(targetUserID, groupID)
+	if err != nil {
+		b.AnswerCallbackQuery(callback.ID, "کاربر در این گروه ثبت نشده است.")
+		return
+	}
+
+	payments, err := b.DB.GetPayments(targetUserID, groupID, maxPaymentHistory)
+	if err != nil {
+		zap.L().Error("Error getting payments", zap.Error(err), zap.Int64("user_id", targetUserID), zap.Int64("group_id", groupID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در دریافت اطلاعات.")
+		return
+	}
+
+	lines := []string{fmt.Sprintf("📜 تاریخچه پرداخت %s", ug.Name), ""}
+	if len(payments) == 0 {
+		lines = append(lines, "هیچ پرداختی ثبت نشده است.")
+	}
+	for _, p := range payments {
+		lines = append(lines, paymentLine(p))
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", fmt.Sprintf("settle_user:%d:%d", targetUserID, groupID)),
+		),
+	)
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, strings.Join(lines, "\n"), &keyboard)
+}
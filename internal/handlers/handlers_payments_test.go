@@ -0,0 +1,35 @@
+package handlers
+
+import "testing"
+
+func TestParseAmount(t *testing.T) {
+	valid := map[string]float64{
+		"150000":     150000,
+		"150,000":    150000,
+		"۱۵۰٬۰۰۰":    150000,
+		" 2500.5 ":   2500.5,
+		"9999999999": 9999999999,
+	}
+	for text, want := range valid {
+		if got, err := parseAmount(text); err != nil || got != want {
+			t.Errorf("parseAmount(%q) = %v, %v, want %v", text, got, err, want)
+		}
+	}
+
+	for _, text := range []string{"", "abc", "0", "-5", "NaN", "nan", "Inf", "-Inf", "1e400", "10000000000"} {
+		if got, err := parseAmount(text); err == nil {
+			t.Errorf("parseAmount(%q) = %v, want an error", text, got)
+		}
+	}
+}
+
+func TestParseNumberRejectsNonFinite(t *testing.T) {
+	for _, text := range []string{"NaN", "+Inf", "-inf", "infinity", "1e400"} {
+		if got, err := parseNumber(text); err == nil {
+			t.Errorf("parseNumber(%q) = %v, want an error", text, got)
+		}
+	}
+	if got, err := parseNumber("0.7"); err != nil || got != 0.7 {
+		t.Errorf("parseNumber(0.7) = %v, %v", got, err)
+	}
+}
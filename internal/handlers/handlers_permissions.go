@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"futsal-bot/internal/bot"
+	"futsal-bot/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+var permissionNames = map[models.Permission]string{
+	models.PermissionOwner:     "مالک",
+	models.PermissionAdmin:     "ادمین",
+	models.PermissionTreasurer: "خزانه‌دار",
+	models.PermissionMember:    "عضو",
+}
+
+// assignablePermissions returns the permissions a user may hand out. Only
+// superadmins make owners.
+func assignablePermissions(b *bot.Bot, telegramID int64) []models.Permission {
+	perms := []models.Permission{models.PermissionAdmin, models.PermissionTreasurer, models.PermissionMember}
+	if b.IsSuperadmin(telegramID) {
+		perms = append([]models.Permission{models.PermissionOwner}, perms...)
+	}
+	return perms
+}
+
+// permissionTarget resolves the member a permission button is about and makes
+// sure the presser may change their permission. Owners are only changed by
+// superadmins.
+func permissionTarget(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) (*models.User, *models.UserGroup, bool) {
+	if len(parts) < 3 {
+		return nil, nil, false
+	}
+
+	targetUserID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	groupID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	admin, ok := requireCapability(b, callback, groupID, models.CapManagePermissions)
+	if !ok {
+		return nil, nil, false
+	}
+
+	ug, err := b.DB.GetUserGroup(targetUserID, groupID)
+	if err != nil || ug.Status != models.MembershipActive {
+		b.AnswerCallbackQuery(callback.ID, "این کاربر عضو فعال گروه نیست.")
+		return nil, nil, false
+	}
+
+	if ug.Permission == models.PermissionOwner && !b.IsSuperadmin(callback.From.ID) {
+		b.AnswerCallbackQuery(callback.ID, "سطح دسترسی مالک گروه قابل تغییر نیست.")
+		return nil, nil, false
+	}
+
+	return admin, ug, true
+}
+
+func handlePermsCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 2 {
+		return
+	}
+
+	groupID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	if _, ok := requireCapability(b, callback, groupID, models.CapManagePermissions); !ok {
+		return
+	}
+
+	showPermissions(b, callback.Message.Chat.ID, callback.Message.MessageID, groupID)
+}
+
+func showPermissions(b *bot.Bot, chatID int64, messageID int, groupID int64) {
+	members, err := b.DB.GetUserGroupsByGroupID(groupID)
+	if err != nil {
+		zap.L().Error("Error getting group members", zap.Error(err), zap.Int64("group_id", groupID))
+		b.EditMessage(chatID, messageID, "خطا در دریافت اعضا.", nil)
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, ug := range members {
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("%s - %s", ug.Name, permissionNames[ug.Permission]),
+				fmt.Sprintf("perm:%d:%d", ug.UserID, groupID),
+			),
+		})
+	}
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", fmt.Sprintf("back:%d", groupID)),
+	})
+
+	text := "برای تغییر سطح دسترسی، یک عضو را انتخاب کنید:"
+	if len(members) == 0 {
+		text = "هیچ عضو فعالی در این گروه وجود ندارد."
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	b.EditMessage(chatID, messageID, text, &keyboard)
+}
+
+func handlePermCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	_, ug, ok := permissionTarget(b, callback, parts)
+	if !ok {
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, p := range assignablePermissions(b, callback.From.ID) {
+		label := permissionNames[p]
+		if p == ug.Permission {
+			label = "✅ " + label
+		}
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("perm_set:%d:%d:%s", ug.UserID, ug.GroupID, p)),
+		})
+	}
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", fmt.Sprintf("perms:%d", ug.GroupID)),
+	})
+
+	text := fmt.Sprintf("👤 %s\n\nسطح دسترسی فعلی: %s\nسطح دسترسی جدید را انتخاب کنید:", ug.Name, permissionNames[ug.Permission])
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
+}
+
+func handlePermSetCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 4 {
+		return
+	}
+
+	admin, ug, ok := permissionTarget(b, callback, parts)
+	if !ok {
+		return
+	}
+
+	permission := models.Permission(parts[3])
+	allowed := false
+	for _, p := range assignablePermissions(b, callback.From.ID) {
+		allowed = allowed || p == permission
+	}
+	if !allowed {
+		b.AnswerCallbackQuery(callback.ID, "سطح دسترسی نامعتبر است.")
+		return
+	}
+
+	old, err := b.DB.SetPermission(ug.UserID, ug.GroupID, permission, admin.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		b.AnswerCallbackQuery(callback.ID, "این کاربر عضو فعال گروه نیست.")
+		return
+	}
+	if err != nil {
+		zap.L().Error("Error setting permission", zap.Error(err), zap.Int64("user_id", ug.UserID), zap.Int64("group_id", ug.GroupID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در تغییر سطح دسترسی.")
+		return
+	}
+
+	if old != permission {
+		zap.L().Info("Permission changed",
+			zap.Int64("user_id", ug.UserID), zap.Int64("group_id", ug.GroupID),
+			zap.String("from", string(old)), zap.String("to", string(permission)), zap.Int64("changed_by", admin.ID))
+
+		if member, err := b.DB.GetUser(ug.UserID); err == nil {
+			groupTitle := ""
+			if group, err := b.DB.GetGroup(ug.GroupID); err == nil {
+				groupTitle = group.Title
+			}
+			b.SendMessage(member.TelegramID,
+				fmt.Sprintf("🔐 سطح دسترسی شما در گروه %s به «%s» تغییر کرد.", groupTitle, permissionNames[permission]), nil)
+		}
+	}
+
+	b.AnswerCallbackQuery(callback.ID, "سطح دسترسی تغییر کرد.")
+	showPermissions(b, callback.Message.Chat.ID, callback.Message.MessageID, ug.GroupID)
+}
+
+// handleAdminsCommand lists who manages a group.
+func handleAdminsCommand(b *bot.Bot, message *tgbotapi.Message) {
+	group, err := b.DB.GetGroupByTelegramChatID(message.Chat.ID)
+	if err != nil {
+		b.SendMessage(message.Chat.ID, "این گروه در سیستم ثبت نشده است.", nil)
+		return
+	}
+
+	members, err := b.DB.GetUserGroupsByGroupID(group.ID)
+	if err != nil {
+		zap.L().Error("Error getting group members", zap.Error(err), zap.Int64("group_id", group.ID))
+		b.SendMessage(message.Chat.ID, "خطا در دریافت اطلاعات.", nil)
+		return
+	}
+
+	names := map[models.Permission][]string{}
+	for _, ug := range members {
+		names[ug.Permission] = append(names[ug.Permission], ug.Name)
+	}
+
+	lines := []string{"👑 مدیران گروه:"}
+	for _, p := range []models.Permission{models.PermissionOwner, models.PermissionAdmin, models.PermissionTreasurer} {
+		if len(names[p]) > 0 {
+			lines = append(lines, fmt.Sprintf("%s: %s", permissionNames[p], strings.Join(names[p], "، ")))
+		}
+	}
+	if len(lines) == 1 {
+		lines = append(lines, "هنوز کسی برای مدیریت این گروه تعیین نشده است.")
+	}
+
+	b.SendMessage(message.Chat.ID, strings.Join(lines, "\n"), nil)
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"futsal-bot/internal/bot"
+	"futsal-bot/internal/database"
+	"futsal-bot/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+func rsvpText(session *models.Session, rsvps []models.RSVP) string {
+	byStatus := make(map[models.RSVPStatus][]string)
+	for _, r := range rsvps {
+		byStatus[r.Status] = append(byStatus[r.Status], r.Name)
+	}
+
+	in := len(byStatus[models.RSVPIn])
+	capacity := fmt.Sprintf("%d نفر", in)
+	if session.Capacity > 0 {
+		capacity = fmt.Sprintf("%d از %d نفر", in, session.Capacity)
+	}
+
+	lines := []string{
+		"📣 چه کسانی می‌آیند؟",
+		"",
+		"📅 " + formatSession(session),
+		"ظرفیت: " + capacity,
+	}
+
+	sections := []struct {
+		title  string
+		status models.RSVPStatus
+	}{
+		{"✅ می‌آیم", models.RSVPIn},
+		{"⏳ لیست انتظار", models.RSVPWaitlist},
+		{"❔ شاید", models.RSVPMaybe},
+		{"❌ نمی‌آیم", models.RSVPOut},
+	}
+	for _, section := range sections {
+		names := byStatus[section.status]
+		if len(names) == 0 {
+			continue
+		}
+		lines = append(lines, "", fmt.Sprintf("%s (%d):", section.title, len(names)))
+		for i, name := range names {
+			lines = append(lines, fmt.Sprintf("%d. %s", i+1, name))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// postSessionRSVP sends the poll of a session to its group chat and
+// remembers the message so it can be updated as members answer.
+func postSessionRSVP(b *bot.Bot, session *models.Session) error {
+	group, err := b.DB.GetGroup(session.GroupID)
+	if err != nil {
+		return fmt.Errorf("failed to get group: %w", err)
+	}
+
+	rsvps, err := b.DB.GetSessionRSVPs(session.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get rsvps: %w", err)
+	}
+
+	keyboard := b.RSVPKeyboard(session.ID)
+	messageID, err := b.SendMessageWithID(group.TelegramChatID, rsvpText(session, rsvps), keyboard)
+	if err != nil {
+		return fmt.Errorf("failed to send rsvp message: %w", err)
+	}
+
+	if err := b.DB.SetSessionRSVPMessage(session.ID, messageID); err != nil {
+		return fmt.Errorf("failed to save rsvp message: %w", err)
+	}
+	session.RSVPMessageID = &messageID
+
+	return nil
+}
+
+// refreshSessionRSVP re-renders the poll message of a session. Polls of
+// sessions that are no longer scheduled lose their buttons.
+func refreshSessionRSVP(b *bot.Bot, session *models.Session) {
+	if session.RSVPMessageID == nil {
+		return
+	}
+
+	group, err := b.DB.GetGroup(session.GroupID)
+	if err != nil {
+		zap.L().Error("Error getting group", zap.Error(err), zap.Int64("group_id", session.GroupID))
+		return
+	}
+
+	if session.Status == models.SessionCancelled {
+		b.EditMessage(group.TelegramChatID, *session.RSVPMessageID, "🚫 جلسه "+formatSession(session)+" لغو شد.", nil)
+		return
+	}
+
+	rsvps, err := b.DB.GetSessionRSVPs(session.ID)
+	if err != nil {
+		zap.L().Error("Error getting rsvps", zap.Error(err), zap.Int64("session_id", session.ID))
+		return
+	}
+
+	var keyboard *tgbotapi.InlineKeyboardMarkup
+	if session.Status == models.SessionScheduled {
+		k := b.RSVPKeyboard(session.ID)
+		keyboard = &k
+	}
+
+	b.EditMessage(group.TelegramChatID, *session.RSVPMessageID, rsvpText(session, rsvps), keyboard)
+}
+
+func handleRSVPCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 3 {
+		return
+	}
+
+	sessionID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	status := models.RSVPStatus(parts[2])
+	if status != models.RSVPIn && status != models.RSVPOut && status != models.RSVPMaybe {
+		return
+	}
+
+	session, err := b.DB.GetSession(sessionID)
+	if err != nil {
+		b.AnswerCallbackQuery(callback.ID, "جلسه یافت نشد.")
+		return
+	}
+
+	user, err := b.DB.GetUserByTelegramID(callback.From.ID)
+	if err != nil {
+		b.AnswerCallbackQuery(callback.ID, "ابتدا در پیوی ربات ثبت نام کنید.")
+		return
+	}
+
+	isMember, err := b.DB.IsUserMemberOfGroup(user.ID, session.GroupID)
+	if err != nil || !isMember {
+		b.AnswerCallbackQuery(callback.ID, "شما عضو این گروه نیستید.")
+		return
+	}
+
+	change, err := b.DB.SetRSVP(session.ID, user.ID, status)
+	if errors.Is(err, database.ErrSessionClosed) {
+		b.AnswerCallbackQuery(callback.ID, "این جلسه دیگر باز نیست.")
+		return
+	}
+	if err != nil {
+		zap.L().Error("Error saving rsvp", zap.Error(err), zap.Int64("session_id", session.ID), zap.Int64("user_id", user.ID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در ثبت پاسخ.")
+		return
+	}
+
+	if session.RSVPMessageID == nil && callback.Message != nil {
+		messageID := callback.Message.MessageID
+		session.RSVPMessageID = &messageID
+	}
+	refreshSessionRSVP(b, session)
+
+	for _, promotedID := range change.Promoted {
+		promoted, err := b.DB.GetUser(promotedID)
+		if err != nil {
+			continue
+		}
+		b.SendMessage(promoted.TelegramID,
+			"🎉 یک جا در جلسه "+formatSession(session)+" خالی شد و شما از لیست انتظار به لیست حاضرین منتقل شدید.", nil)
+	}
+
+	answers := map[models.RSVPStatus]string{
+		models.RSVPIn:       "✅ حضور شما ثبت شد.",
+		models.RSVPOut:      "❌ ثبت شد که نمی‌آیید.",
+		models.RSVPMaybe:    "❔ ثبت شد که شاید بیایید.",
+		models.RSVPWaitlist: "⏳ ظرفیت تکمیل است؛ در لیست انتظار قرار گرفتید.",
+	}
+	b.AnswerCallbackQuery(callback.ID, answers[change.Status])
+}
+
+func handleRSVPPostCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 2 {
+		return
+	}
+
+	sessionID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	session, err := b.DB.GetSession(sessionID)
+	if err != nil {
+		b.AnswerCallbackQuery(callback.ID, "جلسه یافت نشد.")
+		return
+	}
+
+	if _, ok := requireCapability(b, callback, session.GroupID, models.CapManageSessions); !ok {
+		return
+	}
+
+	if session.Status != models.SessionScheduled {
+		b.AnswerCallbackQuery(callback.ID, "این جلسه دیگر باز نیست.")
+		return
+	}
+
+	if err := postSessionRSVP(b, session); err != nil {
+		zap.L().Error("Error posting rsvp", zap.Error(err), zap.Int64("session_id", session.ID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در ارسال نظرسنجی به گروه.")
+		return
+	}
+
+	b.AnswerCallbackQuery(callback.ID, "نظرسنجی در گروه ارسال شد.")
+}
+
+// rsvpSelection returns the members who said they are coming to a session,
+// to pre-fill its attendance checklist.
+func rsvpSelection(b *bot.Bot, session *models.Session) map[int64]bool {
+	selected := make(map[int64]bool)
+	if session == nil {
+		return selected
+	}
+
+	rsvps, err := b.DB.GetSessionRSVPs(session.ID)
+	if err != nil {
+		zap.L().Error("Error getting rsvps", zap.Error(err), zap.Int64("session_id", session.ID))
+		return selected
+	}
+
+	for _, r := range rsvps {
+		if r.Status == models.RSVPIn {
+			selected[r.UserID] = true
+		}
+	}
+
+	return selected
+}
@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"futsal-bot/internal/bot"
+	"futsal-bot/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// sessionTimeLayout is the format admins enter session start times in.
+const sessionTimeLayout = "2006-01-02 15:04"
+
+func formatSession(s *models.Session) string {
+	text := s.StartsAt.In(time.Local).Format(sessionTimeLayout)
+	if s.Venue != "" {
+		text += " - " + s.Venue
+	}
+	return text
+}
+
+func sessionDetailsText(s *models.Session) string {
+	capacity := "نامحدود"
+	if s.Capacity > 0 {
+		capacity = fmt.Sprintf("%d نفر", s.Capacity)
+	}
+
+	statusNames := map[models.SessionStatus]string{
+		models.SessionScheduled: "برنامه‌ریزی شده",
+		models.SessionCancelled: "لغو شده",
+		models.SessionCompleted: "برگزار شده",
+	}
+
+	text := fmt.Sprintf(
+		"📅 جلسه %d\n\n"+
+			"زمان: %s\n"+
+			"مکان: %s\n"+
+			"ظرفیت: %s\n"+
+			"وضعیت: %s",
+		s.ID, s.StartsAt.In(time.Local).Format(sessionTimeLayout), s.Venue, capacity, statusNames[s.Status],
+	)
+	if s.VenueCost != nil {
+		text += fmt.Sprintf("\nهزینه زمین: %.0f تومان", *s.VenueCost)
+	}
+	return text
+}
+
+func handleSessionsCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 2 {
+		return
+	}
+
+	groupID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	if _, ok := requireCapability(b, callback, groupID, models.CapManageSessions); !ok {
+		return
+	}
+
+	showSessions(b, callback.Message.Chat.ID, callback.Message.MessageID, groupID)
+}
+
+func showSessions(b *bot.Bot, chatID int64, messageID int, groupID int64) {
+	sessions, err := b.DB.GetUpcomingSessions(groupID, time.Now().Add(-attendanceLookback))
+	if err != nil {
+		zap.L().Error("Error getting upcoming sessions", zap.Error(err), zap.Int64("group_id", groupID))
+		b.EditMessage(chatID, messageID, "خطا در دریافت جلسات.", nil)
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := range sessions {
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("📅 "+formatSession(&sessions[i]), fmt.Sprintf("session:%d", sessions[i].ID)),
+		})
+	}
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("➕ جلسه جدید", fmt.Sprintf("session_new:%d", groupID)),
+	})
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", fmt.Sprintf("back:%d", groupID)),
+	})
+
+	text := "جلسات پیش رو:"
+	if len(sessions) == 0 {
+		text = "هیچ جلسه‌ای برنامه‌ریزی نشده است."
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	b.EditMessage(chatID, messageID, text, &keyboard)
+}
+
+func handleSessionCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 2 {
+		return
+	}
+
+	sessionID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	session, err := b.DB.GetSession(sessionID)
+	if err != nil {
+		b.AnswerCallbackQuery(callback.ID, "جلسه یافت نشد.")
+		return
+	}
+
+	if _, ok := requireCapability(b, callback, session.GroupID, models.CapManageSessions); !ok {
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if session.Status == models.SessionScheduled {
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("📣 ارسال نظرسنجی حضور", fmt.Sprintf("rsvp_post:%d", session.ID)),
+		})
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("💰 هزینه زمین", fmt.Sprintf("session_cost:%d", session.ID)),
+		})
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("❌ لغو جلسه", fmt.Sprintf("session_cancel:%d", session.ID)),
+		})
+	}
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", fmt.Sprintf("sessions:%d", session.GroupID)),
+	})
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, sessionDetailsText(session), &keyboard)
+}
+
+func handleSessionCancelCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 2 {
+		return
+	}
+
+	sessionID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	session, err := b.DB.GetSession(sessionID)
+	if err != nil {
+		b.AnswerCallbackQuery(callback.ID, "جلسه یافت نشد.")
+		return
+	}
+
+	admin, ok := requireCapability(b, callback, session.GroupID, models.CapManageSessions)
+	if !ok {
+		return
+	}
+
+	err = b.DB.CancelSession(session.ID, admin.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		b.AnswerCallbackQuery(callback.ID, "این جلسه قابل لغو نیست.")
+		return
+	}
+	if err != nil {
+		zap.L().Error("Error cancelling session", zap.Error(err), zap.Int64("session_id", session.ID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در لغو جلسه.")
+		return
+	}
+
+	session.Status = models.SessionCancelled
+	refreshSessionRSVP(b, session)
+
+	b.AnswerCallbackQuery(callback.ID, "جلسه لغو شد.")
+	showSessions(b, callback.Message.Chat.ID, callback.Message.MessageID, session.GroupID)
+}
+
+func handleSessionNewCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 2 {
+		return
+	}
+
+	groupID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	user, ok := requireCapability(b, callback, groupID, models.CapManageSessions)
+	if !ok {
+		return
+	}
+
+	b.SetState(callback.From.ID, callback.Message.Chat.ID, "awaiting_session_start", &models.SessionFlow{
+		GroupID: groupID,
+		UserID:  user.ID,
+	})
+
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID,
+		"تاریخ و ساعت شروع جلسه را وارد کنید:\nمثال: "+time.Now().Add(24*time.Hour).Format("2006-01-02")+" 18:30", nil)
+}
+
+func handleSessionStartInput(b *bot.Bot, message *tgbotapi.Message, flow *models.SessionFlow) {
+	startsAt, err := time.ParseInLocation(sessionTimeLayout, strings.TrimSpace(message.Text), time.Local)
+	if err != nil {
+		b.SendMessage(message.Chat.ID, "فرمت زمان نامعتبر است. مثال: 2026-01-31 18:30", nil)
+		return
+	}
+
+	if startsAt.Before(time.Now().Add(-attendanceLookback)) {
+		b.SendMessage(message.Chat.ID, "زمان جلسه گذشته است. لطفا زمان دیگری وارد کنید:", nil)
+		return
+	}
+
+	flow.StartsAt = startsAt
+	b.SetState(message.From.ID, message.Chat.ID, "awaiting_session_venue", flow)
+
+	b.SendMessage(message.Chat.ID, "مکان (نام سالن) را وارد کنید:", nil)
+}
+
+func handleSessionVenueInput(b *bot.Bot, message *tgbotapi.Message, flow *models.SessionFlow) {
+	venue := strings.TrimSpace(message.Text)
+	if venue == "" {
+		b.SendMessage(message.Chat.ID, "لطفا یک مکان معتبر وارد کنید:", nil)
+		return
+	}
+
+	flow.Venue = venue
+	b.SetState(message.From.ID, message.Chat.ID, "awaiting_session_capacity", flow)
+
+	b.SendMessage(message.Chat.ID, "ظرفیت جلسه را وارد کنید (0 برای نامحدود):", nil)
+}
+
+func handleSessionCapacityInput(b *bot.Bot, message *tgbotapi.Message, flow *models.SessionFlow) {
+	capacity, err := strconv.Atoi(strings.TrimSpace(message.Text))
+	if err != nil || capacity < 0 {
+		b.SendMessage(message.Chat.ID, "لطفا یک عدد معتبر وارد کنید:", nil)
+		return
+	}
+
+	flow.Capacity = capacity
+
+	// Groups that split the venue cost need it before anyone can be charged
+	if group, err := b.DB.GetGroup(flow.GroupID); err == nil && group.PricingMode == models.PricingSplit {
+		b.SetState(message.From.ID, message.Chat.ID, "awaiting_session_cost", flow)
+		b.SendMessage(message.Chat.ID, "هزینه کل زمین برای این جلسه را به تومان وارد کنید:", nil)
+		return
+	}
+
+	createSession(b, message, flow, nil)
+}
+
+// handleSessionCostInput takes the venue cost of a new session, or of the
+// existing one the flow is about.
+func handleSessionCostInput(b *bot.Bot, message *tgbotapi.Message, flow *models.SessionFlow) {
+	cost, err := parseNumber(message.Text)
+	if err != nil || cost < 0 || cost > maxAmount {
+		b.SendMessage(message.Chat.ID, "لطفا یک عدد معتبر وارد کنید:", nil)
+		return
+	}
+
+	if flow.SessionID == 0 {
+		createSession(b, message, flow, &cost)
+		return
+	}
+
+	b.ClearState(message.From.ID, message.Chat.ID, models.FlowSession)
+
+	err = b.DB.SetSessionVenueCost(flow.SessionID, cost, flow.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		b.SendMessage(message.Chat.ID, "هزینه زمین فقط برای جلسات برنامه‌ریزی شده قابل تغییر است.", nil)
+		return
+	}
+	if err != nil {
+		zap.L().Error("Error setting venue cost", zap.Error(err), zap.Int64("session_id", flow.SessionID))
+		b.SendMessage(message.Chat.ID, "خطا در ثبت هزینه زمین.", nil)
+		return
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📅 جلسات", fmt.Sprintf("sessions:%d", flow.GroupID)),
+		),
+	)
+	b.SendMessage(message.Chat.ID, fmt.Sprintf("✅ هزینه زمین %.0f تومان ثبت شد.", cost), keyboard)
+}
+
+func handleSessionCostCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 2 {
+		return
+	}
+
+	sessionID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	session, err := b.DB.GetSession(sessionID)
+	if err != nil {
+		b.AnswerCallbackQuery(callback.ID, "جلسه یافت نشد.")
+		return
+	}
+
+	user, ok := requireCapability(b, callback, session.GroupID, models.CapManageSessions)
+	if !ok {
+		return
+	}
+
+	b.SetState(callback.From.ID, callback.Message.Chat.ID, "awaiting_session_cost", &models.SessionFlow{
+		GroupID:   session.GroupID,
+		UserID:    user.ID,
+		SessionID: session.ID,
+	})
+
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID,
+		fmt.Sprintf("هزینه کل زمین برای جلسه %s را به تومان وارد کنید:", formatSession(session)), nil)
+}
+
+func createSession(b *bot.Bot, message *tgbotapi.Message, flow *models.SessionFlow, venueCost *float64) {
+	groupID := flow.GroupID
+
+	b.ClearState(message.From.ID, message.Chat.ID, models.FlowSession)
+
+	session, err := b.DB.CreateSession(groupID, flow.StartsAt, flow.Venue, flow.Capacity, venueCost, flow.UserID)
+	if err != nil {
+		zap.L().Error("Error creating session", zap.Error(err), zap.Int64("group_id", groupID))
+		b.SendMessage(message.Chat.ID, "خطا در ثبت جلسه.", nil)
+		return
+	}
+
+	text := "✅ جلسه ثبت شد.\n\n" + sessionDetailsText(session)
+	if err := postSessionRSVP(b, session); err != nil {
+		zap.L().Error("Error posting rsvp", zap.Error(err), zap.Int64("session_id", session.ID))
+		text += "\n\n⚠️ ارسال نظرسنجی حضور به گروه ناموفق بود. از صفحه جلسه دوباره تلاش کنید."
+	} else {
+		text += "\n\n📣 نظرسنجی حضور در گروه ارسال شد."
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📅 جلسات", fmt.Sprintf("sessions:%d", groupID)),
+		),
+	)
+	b.SendMessage(message.Chat.ID, text, keyboard)
+}
@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"futsal-bot/internal/bot"
+	"futsal-bot/internal/database"
+	"futsal-bot/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// rateDateLayout is the format admins enter the day a new rate applies from in.
+const rateDateLayout = "2006-01-02"
+
+// maxRateHistory caps how many past rates a tier shows.
+const maxRateHistory = 5
+
+func formatWeight(w float64) string {
+	return strconv.FormatFloat(w, 'f', -1, 64)
+}
+
+func startOfToday() time.Time {
+	now := time.Now().In(time.Local)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// memberTier returns the name shown for a member's pricing tier, the rate
+// they pay per session and their weight when venue costs are split. Members
+// without a tier pay no rate and count as weight 1.
+func memberTier(b *bot.Bot, ug *models.UserGroup) (string, float64, float64) {
+	if ug.TierID != nil {
+		if tier, err := b.DB.GetPricingTier(*ug.TierID); err == nil {
+			return tier.Name, tier.RatePerSession, tier.Weight
+		}
+	}
+	return "-", 0, 1
+}
+
+// tierForAdmin is adminTier for the pricing tier named by parts[1].
+func tierForAdmin(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) (*models.User, *models.PricingTier, bool) {
+	if len(parts) < 2 {
+		return nil, nil, false
+	}
+
+	tierID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	return adminTier(b, callback, tierID)
+}
+
+// adminTier loads a pricing tier and makes sure the presser of the button may
+// manage the rates of its group.
+func adminTier(b *bot.Bot, callback *tgbotapi.CallbackQuery, tierID int64) (*models.User, *models.PricingTier, bool) {
+	tier, err := b.DB.GetPricingTier(tierID)
+	if err != nil {
+		b.AnswerCallbackQuery(callback.ID, "این نقش دیگر وجود ندارد.")
+		return nil, nil, false
+	}
+
+	admin, ok := requireCapability(b, callback, tier.GroupID, models.CapManageRates)
+	if !ok {
+		return nil, nil, false
+	}
+
+	return admin, tier, true
+}
+
+func handleSetRatesCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 2 {
+		return
+	}
+
+	groupID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	if _, ok := requireCapability(b, callback, groupID, models.CapManageRates); !ok {
+		return
+	}
+
+	showTiers(b, callback.Message.Chat.ID, callback.Message.MessageID, groupID, "")
+}
+
+// showTiers lists the pricing tiers of a group, editing the given message or
+// sending a new one when messageID is zero.
+func showTiers(b *bot.Bot, chatID int64, messageID int, groupID int64, header string) {
+	tiers, err := b.DB.GetPricingTiers(groupID)
+	if err != nil {
+		zap.L().Error("Error getting pricing tiers", zap.Error(err), zap.Int64("group_id", groupID))
+		b.SendMessage(chatID, "خطا در دریافت نقش‌ها.", nil)
+		return
+	}
+
+	group, err := b.DB.GetGroup(groupID)
+	if err != nil {
+		zap.L().Error("Error getting group", zap.Error(err), zap.Int64("group_id", groupID))
+		b.SendMessage(chatID, "خطا در دریافت اطلاعات گروه.", nil)
+		return
+	}
+
+	text := "💵 قیمت‌گذاری: نرخ ثابت برای هر نقش\n\nبرای تنظیم نرخ یا ویرایش، یک نقش را انتخاب کنید:"
+	if group.PricingMode == models.PricingSplit {
+		text = "💵 قیمت‌گذاری: تقسیم هزینه زمین هر جلسه بین حاضرین به نسبت ضریب نقش‌ها\n\nبرای تنظیم ضریب یا ویرایش، یک نقش را انتخاب کنید:"
+	}
+	if len(tiers) == 0 {
+		text = "هیچ نقشی تعریف نشده است. یک نقش جدید بسازید:"
+	}
+	if header != "" {
+		text = header + "\n\n" + text
+	}
+
+	keyboard := b.RateSettingKeyboard(groupID, group.PricingMode, tiers)
+	if messageID == 0 {
+		b.SendMessage(chatID, text, keyboard)
+		return
+	}
+	b.EditMessage(chatID, messageID, text, &keyboard)
+}
+
+func handleTierCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	_, tier, ok := tierForAdmin(b, callback, parts)
+	if !ok {
+		return
+	}
+
+	showTier(b, callback.Message.Chat.ID, callback.Message.MessageID, tier)
+}
+
+// rateHistory splits the rates of a tier, oldest first, into the ones that
+// took effect by today, the current one last and at most maxRateHistory of
+// them, and the ones scheduled for later days.
+func rateHistory(rates []models.TierRate, today time.Time) (past, scheduled []models.TierRate) {
+	day := today.Format(rateDateLayout)
+	for _, r := range rates {
+		if r.EffectiveFrom.Format(rateDateLayout) > day {
+			scheduled = append(scheduled, r)
+		} else {
+			past = append(past, r)
+		}
+	}
+	if len(past) > maxRateHistory {
+		past = past[len(past)-maxRateHistory:]
+	}
+	return past, scheduled
+}
+
+// showTier shows a tier with its current rate, the rates it had before and
+// the changes scheduled for it.
+func showTier(b *bot.Bot, chatID int64, messageID int, tier *models.PricingTier) {
+	rates, err := b.DB.GetTierRates(tier.ID)
+	if err != nil {
+		zap.L().Error("Error getting tier rates", zap.Error(err), zap.Int64("tier_id", tier.ID))
+	}
+
+	past, scheduled := rateHistory(rates, startOfToday())
+
+	lines := []string{fmt.Sprintf("👤 %s\n\nنرخ هر جلسه: %.0f تومان\nضریب سهم از هزینه زمین: %s",
+		tier.Name, tier.RatePerSession, formatWeight(tier.Weight))}
+	if len(scheduled) > 0 {
+		lines = append(lines, "", "⏳ تغییرات برنامه‌ریزی شده:")
+		for _, r := range scheduled {
+			lines = append(lines, fmt.Sprintf("• از %s: %.0f تومان", r.EffectiveFrom.Format(rateDateLayout), r.Rate))
+		}
+	}
+	if len(past) > 1 {
+		lines = append(lines, "", "📜 تاریخچه نرخ:")
+		for i := len(past) - 1; i >= 0; i-- {
+			lines = append(lines, fmt.Sprintf("• از %s: %.0f تومان", past[i].EffectiveFrom.Format(rateDateLayout), past[i].Rate))
+		}
+	}
+
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💵 تنظیم نرخ", fmt.Sprintf("setrate:%d", tier.ID)),
+			tgbotapi.NewInlineKeyboardButtonData("⚖️ تنظیم ضریب", fmt.Sprintf("tier_weight:%d", tier.ID)),
+		),
+	}
+	for _, r := range scheduled {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("❌ لغو نرخ %s", r.EffectiveFrom.Format(rateDateLayout)),
+				fmt.Sprintf("tier_rate_del:%d", r.ID),
+			),
+		))
+	}
+	rows = append(rows,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✏️ تغییر نام", fmt.Sprintf("tier_rename:%d", tier.ID)),
+			tgbotapi.NewInlineKeyboardButtonData("🗑 حذف", fmt.Sprintf("tier_del:%d", tier.ID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", fmt.Sprintf("set_rates:%d", tier.GroupID)),
+		),
+	)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	b.EditMessage(chatID, messageID, strings.Join(lines, "\n"), &keyboard)
+}
+
+// handleTierRateCancelCallback drops a rate change that hasn't started yet.
+func handleTierRateCancelCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 2 {
+		return
+	}
+
+	rateID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	rate, err := b.DB.GetTierRate(rateID)
+	if err != nil {
+		b.AnswerCallbackQuery(callback.ID, "این تغییر نرخ دیگر وجود ندارد.")
+		return
+	}
+
+	admin, tier, ok := adminTier(b, callback, rate.TierID)
+	if !ok {
+		return
+	}
+
+	err = b.DB.CancelTierRate(rateID, admin.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		b.AnswerCallbackQuery(callback.ID, "این نرخ اعمال شده و قابل لغو نیست.")
+		return
+	}
+	if err != nil {
+		zap.L().Error("Error cancelling tier rate", zap.Error(err), zap.Int64("rate_id", rateID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در لغو تغییر نرخ.")
+		return
+	}
+
+	b.AnswerCallbackQuery(callback.ID, "تغییر نرخ لغو شد.")
+	showTier(b, callback.Message.Chat.ID, callback.Message.MessageID, tier)
+}
+
+func handleSetRateCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	admin, tier, ok := tierForAdmin(b, callback, parts)
+	if !ok {
+		return
+	}
+
+	b.SetState(callback.From.ID, callback.Message.Chat.ID, "awaiting_rate", &models.RateFlow{
+		GroupID: tier.GroupID,
+		TierID:  tier.ID,
+		AdminID: admin.ID,
+	})
+
+	text := fmt.Sprintf("لطفا نرخ هر جلسه برای %s را به تومان وارد کنید:", tier.Name)
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, nil)
+}
+
+func handleTierNewCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 2 {
+		return
+	}
+
+	groupID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	admin, ok := requireCapability(b, callback, groupID, models.CapManageRates)
+	if !ok {
+		return
+	}
+
+	b.SetState(callback.From.ID, callback.Message.Chat.ID, "awaiting_tier_name", &models.TierFlow{
+		GroupID: groupID,
+		AdminID: admin.ID,
+	})
+
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID,
+		"نام نقش جدید را وارد کنید:\nمثال: مهمان", nil)
+}
+
+func handleTierRenameCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	admin, tier, ok := tierForAdmin(b, callback, parts)
+	if !ok {
+		return
+	}
+
+	b.SetState(callback.From.ID, callback.Message.Chat.ID, "awaiting_tier_name", &models.TierFlow{
+		GroupID: tier.GroupID,
+		TierID:  tier.ID,
+		AdminID: admin.ID,
+	})
+
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID,
+		fmt.Sprintf("نام جدید نقش %s را وارد کنید:", tier.Name), nil)
+}
+
+// handleTierNameInput names a new tier and goes on to ask for its rate, or
+// renames an existing one.
+func handleTierNameInput(b *bot.Bot, message *tgbotapi.Message, flow *models.TierFlow) {
+	name := strings.TrimSpace(message.Text)
+	if name == "" || len([]rune(name)) > 64 {
+		b.SendMessage(message.Chat.ID, "لطفا یک نام معتبر وارد کنید:", nil)
+		return
+	}
+
+	tiers, err := b.DB.GetPricingTiers(flow.GroupID)
+	if err != nil {
+		zap.L().Error("Error getting pricing tiers", zap.Error(err), zap.Int64("group_id", flow.GroupID))
+		b.SendMessage(message.Chat.ID, "خطا در دریافت نقش‌ها. لطفا دوباره تلاش کنید.", nil)
+		return
+	}
+	for _, t := range tiers {
+		if t.Name == name && t.ID != flow.TierID {
+			b.SendMessage(message.Chat.ID, "نقشی با این نام وجود دارد. نام دیگری وارد کنید:", nil)
+			return
+		}
+	}
+
+	b.ClearState(message.From.ID, message.Chat.ID, models.FlowTier)
+
+	if flow.TierID != 0 {
+		if err := b.DB.RenamePricingTier(flow.TierID, name, flow.AdminID); err != nil {
+			zap.L().Error("Error renaming pricing tier", zap.Error(err), zap.Int64("tier_id", flow.TierID))
+			b.SendMessage(message.Chat.ID, "خطا در تغییر نام نقش.", nil)
+			return
+		}
+		showTiers(b, message.Chat.ID, 0, flow.GroupID, "✅ نام نقش تغییر کرد.")
+		return
+	}
+
+	tier, err := b.DB.CreatePricingTier(flow.GroupID, name, flow.AdminID)
+	if err != nil {
+		zap.L().Error("Error creating pricing tier", zap.Error(err), zap.Int64("group_id", flow.GroupID))
+		b.SendMessage(message.Chat.ID, "خطا در ساخت نقش.", nil)
+		return
+	}
+
+	b.SetState(message.From.ID, message.Chat.ID, "awaiting_rate", &models.RateFlow{
+		GroupID: tier.GroupID,
+		TierID:  tier.ID,
+		AdminID: flow.AdminID,
+	})
+
+	b.SendMessage(message.Chat.ID,
+		fmt.Sprintf("✅ نقش %s ساخته شد.\n\nلطفا نرخ هر جلسه برای %s را به تومان وارد کنید:", tier.Name, tier.Name), nil)
+}
+
+func handleTierDeleteCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	admin, tier, ok := tierForAdmin(b, callback, parts)
+	if !ok {
+		return
+	}
+
+	err := b.DB.DeletePricingTier(tier.ID, admin.ID)
+	if errors.Is(err, database.ErrTierInUse) {
+		b.AnswerCallbackQuery(callback.ID, "اعضایی با این نقش وجود دارند و نمی‌توان آن را حذف کرد.")
+		return
+	}
+	if err != nil {
+		zap.L().Error("Error deleting pricing tier", zap.Error(err), zap.Int64("tier_id", tier.ID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در حذف نقش.")
+		return
+	}
+
+	b.AnswerCallbackQuery(callback.ID, "نقش حذف شد.")
+	showTiers(b, callback.Message.Chat.ID, callback.Message.MessageID, tier.GroupID, "")
+}
+
+func handlePricingModeCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	if len(parts) < 3 {
+		return
+	}
+
+	groupID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	mode := models.PricingMode(parts[2])
+	if mode != models.PricingFixed && mode != models.PricingSplit {
+		return
+	}
+
+	admin, ok := requireCapability(b, callback, groupID, models.CapManageRates)
+	if !ok {
+		return
+	}
+
+	if err := b.DB.SetPricingMode(groupID, mode, admin.ID); err != nil {
+		zap.L().Error("Error setting pricing mode", zap.Error(err), zap.Int64("group_id", groupID))
+		b.AnswerCallbackQuery(callback.ID, "خطا در تغییر حالت قیمت‌گذاری.")
+		return
+	}
+
+	b.AnswerCallbackQuery(callback.ID, "حالت قیمت‌گذاری تغییر کرد.")
+	showTiers(b, callback.Message.Chat.ID, callback.Message.MessageID, groupID, "")
+}
+
+func handleTierWeightCallback(b *bot.Bot, callback *tgbotapi.CallbackQuery, parts []string) {
+	admin, tier, ok := tierForAdmin(b, callback, parts)
+	if !ok {
+		return
+	}
+
+	b.SetState(callback.From.ID, callback.Message.Chat.ID, "awaiting_weight", &models.WeightFlow{
+		GroupID: tier.GroupID,
+		TierID:  tier.ID,
+		AdminID: admin.ID,
+	})
+
+	b.EditMessage(callback.Message.Chat.ID, callback.Message.MessageID,
+		fmt.Sprintf("ضریب سهم %s از هزینه زمین را وارد کنید:\nمثال: 0.7 یعنی ۷۰٪ سهم یک عضو با ضریب 1", tier.Name), nil)
+}
+
+func handleWeightInput(b *bot.Bot, message *tgbotapi.Message, flow *models.WeightFlow) {
+	weight, err := parseNumber(message.Text)
+	if err != nil || weight < 0 || weight > 100 {
+		b.SendMessage(message.Chat.ID, "لطفا یک عدد معتبر وارد کنید:", nil)
+		return
+	}
+
+	b.ClearState(message.From.ID, message.Chat.ID, models.FlowWeight)
+
+	tier, err := b.DB.GetPricingTier(flow.TierID)
+	if err != nil {
+		b.SendMessage(message.Chat.ID, "این نقش دیگر وجود ندارد.", nil)
+		return
+	}
+
+	if err := b.DB.SetTierWeight(tier.ID, weight, flow.AdminID); err != nil {
+		zap.L().Error("Error setting tier weight", zap.Error(err), zap.Int64("tier_id", tier.ID))
+		b.SendMessage(message.Chat.ID, "خطا در ثبت ضریب. لطفا دوباره تلاش کنید.", nil)
+		return
+	}
+
+	showTiers(b, message.Chat.ID, 0, flow.GroupID, fmt.Sprintf("✅ ضریب %s به %s تنظیم شد.", tier.Name, formatWeight(weight)))
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"futsal-bot/internal/models"
+)
+
+func TestRateHistory(t *testing.T) {
+	today := time.Date(2026, 3, 21, 0, 0, 0, 0, time.Local)
+	rate := func(id int64, days int) models.TierRate {
+		return models.TierRate{ID: id, EffectiveFrom: today.AddDate(0, 0, days)}
+	}
+
+	ids := func(rates []models.TierRate) []int64 {
+		var ids []int64
+		for _, r := range rates {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name          string
+		rates         []models.TierRate
+		wantPast      []int64
+		wantScheduled []int64
+	}{
+		{"no rates", nil, nil, nil},
+		{"starting today is current", []models.TierRate{rate(1, -30), rate(2, 0)}, []int64{1, 2}, nil},
+		{"tomorrow is scheduled", []models.TierRate{rate(1, -30), rate(2, 1), rate(3, 60)}, []int64{1}, []int64{2, 3}},
+		{"only the latest past rates", []models.TierRate{
+			rate(1, -70), rate(2, -60), rate(3, -50), rate(4, -40), rate(5, -30), rate(6, -20), rate(7, 10),
+		}, []int64{2, 3, 4, 5, 6}, []int64{7}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			past, scheduled := rateHistory(tt.rates, today)
+			if got := ids(past); !reflect.DeepEqual(got, tt.wantPast) {
+				t.Errorf("past = %v, want %v", got, tt.wantPast)
+			}
+			if got := ids(scheduled); !reflect.DeepEqual(got, tt.wantScheduled) {
+				t.Errorf("scheduled = %v, want %v", got, tt.wantScheduled)
+			}
+		})
+	}
+}
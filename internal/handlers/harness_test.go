@@ -0,0 +1,238 @@
+package handlers_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"futsal-bot/internal/bot"
+	"futsal-bot/internal/database/databasetest"
+	"futsal-bot/internal/handlers"
+	"futsal-bot/internal/messenger/messengertest"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// harness drives the bot the way the platform would: it builds updates for
+// scripted user actions, hands them to handlers.HandleUpdate one at a time
+// and reads the replies off a fake messenger. Each harness has a database
+// of its own.
+type harness struct {
+	t    *testing.T
+	bot  *bot.Bot
+	fake *messengertest.Fake
+
+	updateID   int
+	callbackID int
+}
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+
+	db := databasetest.New(t)
+	fake := messengertest.New(tgbotapi.User{ID: 1, IsBot: true, FirstName: "Futsal", UserName: "futsal_test_bot"})
+
+	return &harness{
+		t:    t,
+		fake: fake,
+		bot: bot.New(fake, db, bot.Config{
+			RevertWindow: time.Hour,
+			States:       bot.NewMemoryStateStore(),
+			StateTTL:     time.Hour,
+			InviteSecret: []byte("test-invite-secret"),
+			InviteTTL:    time.Hour,
+		}),
+	}
+}
+
+func newUser(id int64, userName, firstName string) *tgbotapi.User {
+	return &tgbotapi.User{ID: id, UserName: userName, FirstName: firstName}
+}
+
+func newGroupChat(id int64, title string) *tgbotapi.Chat {
+	return &tgbotapi.Chat{ID: id, Type: "group", Title: title}
+}
+
+// privateChat is the chat between the bot and a user, which shares the
+// user's ID.
+func privateChat(user *tgbotapi.User) *tgbotapi.Chat {
+	return &tgbotapi.Chat{ID: user.ID, Type: "private", FirstName: user.FirstName, UserName: user.UserName}
+}
+
+func (h *harness) dispatch(update tgbotapi.Update) {
+	h.t.Helper()
+	h.updateID++
+	update.UpdateID = h.updateID
+	handlers.HandleUpdate(h.bot, update)
+}
+
+// message builds a message from a user, marked up as a command when it
+// starts with a slash.
+func (h *harness) message(chat *tgbotapi.Chat, from *tgbotapi.User, text string) *tgbotapi.Message {
+	message := &tgbotapi.Message{
+		MessageID: h.updateID + 1,
+		From:      from,
+		Chat:      chat,
+		Date:      int(time.Now().Unix()),
+		Text:      text,
+	}
+	if strings.HasPrefix(text, "/") {
+		command, _, _ := strings.Cut(text, " ")
+		message.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}}
+	}
+	return message
+}
+
+// addBot has the creator of a group chat add the bot to it. The user and
+// members are in the chat from then on.
+func (h *harness) addBot(chat *tgbotapi.Chat, from *tgbotapi.User, members ...*tgbotapi.User) {
+	h.t.Helper()
+	h.addBotAs(chat, from, "creator", members...)
+}
+
+// addBotAs has a user with the given chat status, e.g. "member" or
+// "administrator", add the bot to a group chat.
+func (h *harness) addBotAs(chat *tgbotapi.Chat, from *tgbotapi.User, status string, members ...*tgbotapi.User) {
+	h.t.Helper()
+
+	h.fake.SetChatMember(chat.ID, from.ID, status)
+	for _, member := range members {
+		h.fake.SetChatMember(chat.ID, member.ID, "member")
+	}
+
+	self := h.fake.Self()
+	message := h.message(chat, from, "")
+	message.NewChatMembers = []tgbotapi.User{self}
+	h.dispatch(tgbotapi.Update{Message: message})
+}
+
+// sendPrivate has a user write to the bot in private.
+func (h *harness) sendPrivate(from *tgbotapi.User, text string) {
+	h.t.Helper()
+	h.dispatch(tgbotapi.Update{Message: h.message(privateChat(from), from, text)})
+}
+
+// sendGroup has a user write in a group chat.
+func (h *harness) sendGroup(chat *tgbotapi.Chat, from *tgbotapi.User, text string) {
+	h.t.Helper()
+	h.dispatch(tgbotapi.Update{Message: h.message(chat, from, text)})
+}
+
+// press has a user press the button starting with label on a message the
+// bot sent, then returns what the user sees last in that chat.
+func (h *harness) press(from *tgbotapi.User, on messengertest.Message, label string) messengertest.Message {
+	h.t.Helper()
+
+	data, err := on.Button(label)
+	if err != nil {
+		h.t.Fatalf("pressing %q: %v\nmessage text:\n%s", label, err, on.Text)
+	}
+
+	h.callbackID++
+	h.dispatch(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:   fmt.Sprint(h.callbackID),
+		From: from,
+		Message: &tgbotapi.Message{
+			MessageID:   on.MessageID,
+			Chat:        &tgbotapi.Chat{ID: on.ChatID},
+			Text:        on.Text,
+			ReplyMarkup: on.Keyboard,
+		},
+		Data: data,
+	}})
+
+	return h.last(on.ChatID)
+}
+
+// last returns the message of a chat that was sent or edited last.
+func (h *harness) last(chatID int64) messengertest.Message {
+	h.t.Helper()
+
+	message, ok := h.fake.Last(chatID)
+	if !ok {
+		h.t.Fatalf("no message in chat %d", chatID)
+	}
+	return message
+}
+
+// expect fails the test unless the last message of a chat contains every
+// one of want, and returns that message.
+func (h *harness) expect(chatID int64, want ...string) messengertest.Message {
+	h.t.Helper()
+
+	message := h.last(chatID)
+	for _, w := range want {
+		if !strings.Contains(message.Text, w) {
+			h.t.Fatalf("last message in chat %d doesn't contain %q:\n%s", chatID, w, message.Text)
+		}
+	}
+	return message
+}
+
+// setup has Sara, the creator of the group chat Futsal, add the bot to it,
+// and Ali register in the given tier and get approved by her. It returns the
+// chat, Sara and Ali.
+func (h *harness) setup(tier string) (group *tgbotapi.Chat, admin, player *tgbotapi.User) {
+	h.t.Helper()
+
+	group = newGroupChat(-1001, "Futsal")
+	admin = newUser(100, "sara", "Sara")
+	player = newUser(200, "ali", "Ali")
+
+	h.addBot(group, admin, player)
+	h.register(player, "Ali", tier)
+	h.expect(player.ID, "درخواست عضویت شما ثبت شد")
+	h.approve(admin)
+	h.expect(player.ID, "عضویت شما در گروه Futsal تایید شد")
+
+	return group, admin, player
+}
+
+// register walks a user through /start, the name prompt and the role
+// picker of the only group they are in.
+func (h *harness) register(user *tgbotapi.User, name, role string) {
+	h.t.Helper()
+
+	h.sendPrivate(user, "/start")
+	h.press(user, h.last(user.ID), "📝 ثبت نام")
+	h.sendPrivate(user, name)
+	h.press(user, h.last(user.ID), "👤 "+role)
+}
+
+// approve has an admin accept the membership request waiting in their chat.
+func (h *harness) approve(admin *tgbotapi.User) {
+	h.t.Helper()
+
+	h.expect(admin.ID, "درخواست عضویت جدید")
+	h.press(admin, h.last(admin.ID), "✅ تایید")
+}
+
+// setRate has an admin set a tier's rate from today on through the menu.
+func (h *harness) setRate(admin *tgbotapi.User, tier string, rate int) {
+	h.t.Helper()
+
+	h.sendPrivate(admin, "/start")
+	menu := h.press(admin, h.last(admin.ID), "💵 تعیین نرخ")
+	page := h.press(admin, menu, tier+" - ")
+	h.press(admin, page, "💵 تنظیم نرخ")
+	h.sendPrivate(admin, fmt.Sprint(rate))
+	h.press(admin, h.last(admin.ID), "📅 از امروز")
+	h.expect(admin.ID, fmt.Sprintf("✅ نرخ برای %s به %d تومان تنظیم شد.", tier, rate))
+}
+
+// invite has an admin make an invite link through the menu and returns the
+// token a deep link to it carries.
+func (h *harness) invite(admin *tgbotapi.User) string {
+	h.t.Helper()
+
+	h.sendPrivate(admin, "/start")
+	members := h.press(admin, h.last(admin.ID), "📨 دعوت و درخواست‌های عضویت")
+	link := h.press(admin, members, "🔗 ساخت لینک دعوت")
+
+	_, rest, ok := strings.Cut(link.Text, "?start=")
+	if !ok {
+		h.t.Fatalf("no invite link in message:\n%s", link.Text)
+	}
+	return strings.Fields(rest)[0]
+}
@@ -0,0 +1,89 @@
+package invite
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+var (
+	secret = []byte("s3cret")
+	now    = time.Date(2026, 3, 21, 18, 30, 0, 0, time.UTC)
+)
+
+func TestRoundTrip(t *testing.T) {
+	token := Sign(secret, 42, now.Add(time.Hour))
+	if len(token) > 64 {
+		t.Errorf("token is %d characters, /start allows 64", len(token))
+	}
+
+	groupID, err := Verify(secret, token, now)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if groupID != 42 {
+		t.Errorf("group %d, want 42", groupID)
+	}
+
+	// Negative IDs survive the trip too
+	groupID, err = Verify(secret, Sign(secret, -7, now.Add(time.Hour)), now)
+	if err != nil || groupID != -7 {
+		t.Errorf("negative group: got %d, %v", groupID, err)
+	}
+}
+
+func TestTampered(t *testing.T) {
+	raw, err := base64.RawURLEncoding.DecodeString(Sign(secret, 42, now.Add(time.Hour)))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	for name, i := range map[string]int{
+		"group":  7,
+		"expiry": 15,
+		"mac":    len(raw) - 1,
+	} {
+		tampered := append([]byte(nil), raw...)
+		tampered[i] ^= 1
+		token := base64.RawURLEncoding.EncodeToString(tampered)
+		if _, err := Verify(secret, token, now); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s tampered: got %v, want ErrInvalid", name, err)
+		}
+	}
+}
+
+func TestWrongSecret(t *testing.T) {
+	token := Sign(secret, 42, now.Add(time.Hour))
+	if _, err := Verify([]byte("other"), token, now); !errors.Is(err, ErrInvalid) {
+		t.Errorf("got %v, want ErrInvalid", err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	expiresAt := now.Add(time.Hour)
+	token := Sign(secret, 42, expiresAt)
+
+	if _, err := Verify(secret, token, expiresAt); err != nil {
+		t.Errorf("at expiry: %v", err)
+	}
+	if _, err := Verify(secret, token, expiresAt.Add(time.Second)); !errors.Is(err, ErrExpired) {
+		t.Errorf("after expiry: got %v, want ErrExpired", err)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	token := Sign(secret, 42, now.Add(time.Hour))
+
+	for name, token := range map[string]string{
+		"empty":     "",
+		"not b64":   "not*base64!",
+		"padded":    token + "==",
+		"truncated": token[:len(token)-2],
+		"extended":  token + "AA",
+	} {
+		if _, err := Verify(secret, token, now); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: got %v, want ErrInvalid", name, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package messenger
+
+import (
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// botAPI is a Messenger over a Bot API compatible platform.
+type botAPI struct {
+	api      *tgbotapi.BotAPI
+	platform Platform
+	deepLink string
+	caps     Capabilities
+}
+
+func (m *botAPI) Platform() Platform         { return m.platform }
+func (m *botAPI) Capabilities() Capabilities { return m.caps }
+func (m *botAPI) Self() tgbotapi.User        { return m.api.Self }
+
+func (m *botAPI) DeepLink(payload string) string {
+	return fmt.Sprintf(m.deepLink, m.api.Self.UserName, payload)
+}
+
+func (m *botAPI) SendText(chatID int64, text string, opts SendOptions) (int, error) {
+	msg := tgbotapi.NewMessage(chatID, text)
+	if opts.Markdown {
+		if m.caps.Markdown {
+			msg.ParseMode = tgbotapi.ModeMarkdown
+		} else {
+			msg.Text = StripMarkdown(text)
+		}
+	}
+	if opts.ReplyMarkup != nil {
+		msg.ReplyMarkup = opts.ReplyMarkup
+	}
+
+	sent, err := m.api.Send(msg)
+	return sent.MessageID, err
+}
+
+func (m *botAPI) EditText(chatID int64, messageID int, text string, markup *tgbotapi.InlineKeyboardMarkup) error {
+	msg := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	msg.ReplyMarkup = markup
+
+	_, err := m.api.Send(msg)
+	return err
+}
+
+func (m *botAPI) SendPhoto(chatID int64, fileID, caption string) error {
+	photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileID(fileID))
+	photo.Caption = caption
+
+	_, err := m.api.Send(photo)
+	return err
+}
+
+func (m *botAPI) AnswerCallback(callbackID, text string) error {
+	_, err := m.api.Request(tgbotapi.NewCallback(callbackID, text))
+	return err
+}
+
+func (m *botAPI) GetChatMember(chatID, userID int64) (tgbotapi.ChatMember, error) {
+	return m.api.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID},
+	})
+}
+
+func (m *botAPI) Updates(timeout int) tgbotapi.UpdatesChannel {
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = timeout
+	return m.api.GetUpdatesChan(u)
+}
+
+func (m *botAPI) StopUpdates() {
+	m.api.StopReceivingUpdates()
+}
+
+func (m *botAPI) SetWebhook(url, secret string) error {
+	params := tgbotapi.Params{"url": url}
+	if m.caps.WebhookSecret {
+		params.AddNonEmpty("secret_token", secret)
+	}
+	_, err := m.api.MakeRequest("setWebhook", params)
+	return err
+}
+
+func (m *botAPI) DeleteWebhook() error {
+	_, err := m.api.Request(tgbotapi.DeleteWebhookConfig{})
+	return err
+}
+
+// StripMarkdown turns legacy Markdown into the plain text it renders as:
+// markers are dropped and escaped characters kept.
+func StripMarkdown(text string) string {
+	var b strings.Builder
+	escaped := false
+	for _, r := range text {
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '*' || r == '_' || r == '`':
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,21 @@
+package messenger
+
+import "testing"
+
+func TestStripMarkdown(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"💰 *صورتحساب*", "💰 صورتحساب"},
+		{"_italic_ and `code`", "italic and code"},
+		{`snake\_case \*star\*`, "snake_case *star*"},
+		{`trailing\`, "trailing"},
+	}
+
+	for _, tt := range tests {
+		if got := StripMarkdown(tt.in); got != tt.want {
+			t.Errorf("StripMarkdown(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
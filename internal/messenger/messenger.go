@@ -0,0 +1,108 @@
+// Package messenger hides which messaging platform the bot runs on. Telegram
+// and Bale share the Bot API wire format, so updates and keyboards use the
+// tgbotapi types on both; what differs is the endpoint, the deep links and
+// the features each platform supports.
+package messenger
+
+import (
+	"fmt"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type Platform string
+
+const (
+	Telegram Platform = "telegram"
+	Bale     Platform = "bale"
+)
+
+// Capabilities are the optional features of a platform. Messages that use a
+// missing feature are sent in a simpler form instead of failing.
+type Capabilities struct {
+	// Markdown is whether messages may be formatted with legacy Markdown.
+	// Without it the markup is stripped and the text sent plain.
+	Markdown bool
+	// WebhookSecret is whether setWebhook accepts a secret token that is
+	// sent back in a header with every update.
+	WebhookSecret bool
+}
+
+// platformDefaults are the API endpoint, the start deep link format and the
+// capabilities of each platform.
+var platformDefaults = map[Platform]struct {
+	endpoint string
+	deepLink string
+	caps     Capabilities
+}{
+	Telegram: {tgbotapi.APIEndpoint, "https://t.me/%s?start=%s", Capabilities{Markdown: true, WebhookSecret: true}},
+	Bale:     {"https://tapi.bale.ai/bot%s/%s", "https://ble.ir/%s?start=%s", Capabilities{Markdown: true}},
+}
+
+// SendOptions are the optional parts of an outgoing message.
+type SendOptions struct {
+	// Markdown formats the text with legacy Markdown where supported.
+	Markdown bool
+	// ReplyMarkup is a keyboard to attach, e.g. tgbotapi.InlineKeyboardMarkup.
+	ReplyMarkup interface{}
+}
+
+// Messenger is what the bot needs from a messaging platform.
+type Messenger interface {
+	Platform() Platform
+	Capabilities() Capabilities
+	// Self is the bot's own account.
+	Self() tgbotapi.User
+	// DeepLink is a link that opens a private chat with the bot and sends
+	// /start with payload.
+	DeepLink(payload string) string
+
+	SendText(chatID int64, text string, opts SendOptions) (messageID int, err error)
+	EditText(chatID int64, messageID int, text string, markup *tgbotapi.InlineKeyboardMarkup) error
+	// SendPhoto sends a photo already stored on the platform's servers.
+	SendPhoto(chatID int64, fileID, caption string) error
+	AnswerCallback(callbackID, text string) error
+	GetChatMember(chatID, userID int64) (tgbotapi.ChatMember, error)
+
+	// Updates starts long polling; StopUpdates ends it.
+	Updates(timeout int) tgbotapi.UpdatesChannel
+	StopUpdates()
+	// SetWebhook has updates posted to url instead, carrying secret in the
+	// secret token header when the platform supports it.
+	SetWebhook(url, secret string) error
+	DeleteWebhook() error
+}
+
+// Config selects and configures a platform.
+type Config struct {
+	Platform Platform
+	Token    string
+	// Endpoint overrides the platform's Bot API endpoint, a format with the
+	// token and the method, e.g. "https://api.telegram.org/bot%s/%s".
+	Endpoint string
+}
+
+// New connects to the platform with the bot's token.
+func New(cfg Config) (Messenger, error) {
+	defaults, ok := platformDefaults[cfg.Platform]
+	if !ok {
+		return nil, fmt.Errorf("unknown platform %q", cfg.Platform)
+	}
+
+	endpoint := defaults.endpoint
+	if cfg.Endpoint != "" {
+		endpoint = cfg.Endpoint
+	}
+
+	api, err := tgbotapi.NewBotAPIWithAPIEndpoint(cfg.Token, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &botAPI{
+		api:      api,
+		platform: cfg.Platform,
+		deepLink: defaults.deepLink,
+		caps:     defaults.caps,
+	}, nil
+}
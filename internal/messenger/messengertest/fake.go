@@ -0,0 +1,285 @@
+// Package messengertest provides an in-memory Messenger for tests. It keeps
+// the messages the bot sends and edits per chat, so a test can read what a
+// user would see and press the buttons on it.
+package messengertest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"futsal-bot/internal/messenger"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Message is a message the bot sent, as it reads after the latest edit.
+type Message struct {
+	ChatID    int64
+	MessageID int
+	Text      string
+	// Markdown is whether the text was sent formatted.
+	Markdown bool
+	Keyboard *tgbotapi.InlineKeyboardMarkup
+	// PhotoID is the file of a photo, whose caption is in Text.
+	PhotoID string
+	// Edits is how many times the message was edited.
+	Edits int
+
+	// touched orders messages by when they were last sent or edited.
+	touched int
+}
+
+// Labels lists the texts of the message's inline buttons.
+func (m Message) Labels() []string {
+	var labels []string
+	if m.Keyboard != nil {
+		for _, row := range m.Keyboard.InlineKeyboard {
+			for _, button := range row {
+				labels = append(labels, button.Text)
+			}
+		}
+	}
+	return labels
+}
+
+// Button returns the callback data of the one button whose text starts with
+// label.
+func (m Message) Button(label string) (string, error) {
+	var matches []tgbotapi.InlineKeyboardButton
+	if m.Keyboard != nil {
+		for _, row := range m.Keyboard.InlineKeyboard {
+			for _, button := range row {
+				if strings.HasPrefix(button.Text, label) {
+					matches = append(matches, button)
+				}
+			}
+		}
+	}
+
+	switch {
+	case len(matches) == 0:
+		return "", fmt.Errorf("no button %q in message %d, buttons: %q", label, m.MessageID, m.Labels())
+	case len(matches) > 1:
+		return "", fmt.Errorf("%d buttons match %q in message %d, buttons: %q", len(matches), label, m.MessageID, m.Labels())
+	case matches[0].CallbackData == nil:
+		return "", fmt.Errorf("button %q in message %d has no callback data", label, m.MessageID)
+	}
+	return *matches[0].CallbackData, nil
+}
+
+// Answer is a reply to a button press.
+type Answer struct {
+	CallbackID string
+	Text       string
+}
+
+type chatUser struct {
+	chatID, userID int64
+}
+
+// Fake is a Messenger that records what the bot sends instead of sending
+// it. It is safe for concurrent use.
+type Fake struct {
+	self     tgbotapi.User
+	platform messenger.Platform
+
+	mu       sync.Mutex
+	caps     messenger.Capabilities
+	touches  int
+	messages []*Message
+	members  map[chatUser]string
+	answers  []Answer
+	webhook  string
+
+	updates  chan tgbotapi.Update
+	stopOnce sync.Once
+}
+
+// New returns a fake Telegram with every capability, where self is the
+// bot's account.
+func New(self tgbotapi.User) *Fake {
+	return &Fake{
+		self:     self,
+		platform: messenger.Telegram,
+		caps:     messenger.Capabilities{Markdown: true, WebhookSecret: true},
+		members:  make(map[chatUser]string),
+		updates:  make(chan tgbotapi.Update, 100),
+	}
+}
+
+// SetCapabilities changes the features the fake platform supports.
+func (f *Fake) SetCapabilities(caps messenger.Capabilities) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.caps = caps
+}
+
+// SetChatMember sets a user's status in a chat, e.g. "member", "creator"
+// or "left". Users never set have left.
+func (f *Fake) SetChatMember(chatID, userID int64, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.members[chatUser{chatID, userID}] = status
+}
+
+// Messages returns the messages sent to a chat in the order they were sent.
+func (f *Fake) Messages(chatID int64) []Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var messages []Message
+	for _, m := range f.messages {
+		if m.ChatID == chatID {
+			messages = append(messages, *m)
+		}
+	}
+	return messages
+}
+
+// Last returns the message of a chat that was sent or edited last, which is
+// usually the one its user is looking at.
+func (f *Fake) Last(chatID int64) (Message, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var last *Message
+	for _, m := range f.messages {
+		if m.ChatID == chatID && (last == nil || m.touched > last.touched) {
+			last = m
+		}
+	}
+	if last == nil {
+		return Message{}, false
+	}
+	return *last, true
+}
+
+// Answers returns the replies to button presses in the order they were given.
+func (f *Fake) Answers() []Answer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Answer(nil), f.answers...)
+}
+
+// Webhook returns the URL updates are posted to, empty when polling.
+func (f *Fake) Webhook() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.webhook
+}
+
+// Push queues an update for the channel returned by Updates.
+func (f *Fake) Push(update tgbotapi.Update) {
+	f.updates <- update
+}
+
+func (f *Fake) Platform() messenger.Platform { return f.platform }
+func (f *Fake) Self() tgbotapi.User          { return f.self }
+
+func (f *Fake) Capabilities() messenger.Capabilities {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.caps
+}
+
+func (f *Fake) DeepLink(payload string) string {
+	return fmt.Sprintf("https://t.me/%s?start=%s", f.self.UserName, payload)
+}
+
+func (f *Fake) SendText(chatID int64, text string, opts messenger.SendOptions) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	markdown := opts.Markdown
+	if markdown && !f.caps.Markdown {
+		text, markdown = messenger.StripMarkdown(text), false
+	}
+
+	var keyboard *tgbotapi.InlineKeyboardMarkup
+	switch markup := opts.ReplyMarkup.(type) {
+	case tgbotapi.InlineKeyboardMarkup:
+		keyboard = &markup
+	case *tgbotapi.InlineKeyboardMarkup:
+		keyboard = markup
+	}
+
+	m := f.add(chatID)
+	m.Text, m.Markdown, m.Keyboard = text, markdown, keyboard
+	return m.MessageID, nil
+}
+
+func (f *Fake) EditText(chatID int64, messageID int, text string, markup *tgbotapi.InlineKeyboardMarkup) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, m := range f.messages {
+		if m.ChatID == chatID && m.MessageID == messageID {
+			f.touches++
+			m.Text, m.Markdown, m.Keyboard = text, false, markup
+			m.Edits++
+			m.touched = f.touches
+			return nil
+		}
+	}
+	return fmt.Errorf("Bad Request: message %d to edit not found in chat %d", messageID, chatID)
+}
+
+func (f *Fake) SendPhoto(chatID int64, fileID, caption string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	m := f.add(chatID)
+	m.PhotoID, m.Text = fileID, caption
+	return nil
+}
+
+func (f *Fake) AnswerCallback(callbackID, text string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.answers = append(f.answers, Answer{CallbackID: callbackID, Text: text})
+	return nil
+}
+
+func (f *Fake) GetChatMember(chatID, userID int64) (tgbotapi.ChatMember, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status, ok := f.members[chatUser{chatID, userID}]
+	if !ok {
+		status = "left"
+	}
+	return tgbotapi.ChatMember{User: &tgbotapi.User{ID: userID}, Status: status}, nil
+}
+
+func (f *Fake) Updates(timeout int) tgbotapi.UpdatesChannel {
+	return f.updates
+}
+
+func (f *Fake) StopUpdates() {
+	f.stopOnce.Do(func() { close(f.updates) })
+}
+
+func (f *Fake) SetWebhook(url, secret string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.webhook = url
+	return nil
+}
+
+func (f *Fake) DeleteWebhook() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.webhook = ""
+	return nil
+}
+
+// add records a new message in a chat. Message IDs count up across chats.
+func (f *Fake) add(chatID int64) *Message {
+	f.touches++
+	m := &Message{ChatID: chatID, MessageID: len(f.messages) + 1, touched: f.touches}
+	f.messages = append(f.messages, m)
+	return m
+}
+
+var _ messenger.Messenger = (*Fake)(nil)
@@ -0,0 +1,67 @@
+package messengertest
+
+import (
+	"testing"
+
+	"futsal-bot/internal/messenger"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestFakeRecordsSendsAndEdits(t *testing.T) {
+	f := New(tgbotapi.User{ID: 1, IsBot: true, UserName: "bot"})
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("بزرگسال - 0 تومان", "tier:2"),
+		tgbotapi.NewInlineKeyboardButtonData("نیمه بزرگسال - 0 تومان", "tier:3"),
+	))
+	first, _ := f.SendText(10, "menu", messenger.SendOptions{ReplyMarkup: keyboard})
+	f.SendText(10, "second", messenger.SendOptions{})
+	f.SendText(20, "elsewhere", messenger.SendOptions{})
+
+	if err := f.EditText(10, first, "edited", &keyboard); err != nil {
+		t.Fatalf("EditText: %v", err)
+	}
+	if err := f.EditText(20, first, "wrong chat", nil); err == nil {
+		t.Error("editing a message of another chat succeeded")
+	}
+
+	last, ok := f.Last(10)
+	if !ok || last.MessageID != first || last.Text != "edited" || last.Edits != 1 {
+		t.Fatalf("Last(10) = %+v, want the edited first message", last)
+	}
+	if n := len(f.Messages(10)); n != 2 {
+		t.Errorf("chat 10 has %d messages, want 2", n)
+	}
+
+	if data, err := last.Button("بزرگسال"); err != nil || data != "tier:2" {
+		t.Errorf("Button(بزرگسال) = %q, %v, want tier:2", data, err)
+	}
+	if _, err := last.Button("نقش"); err == nil {
+		t.Error("Button found a label that isn't there")
+	}
+}
+
+func TestFakeStripsMarkdownWithoutSupport(t *testing.T) {
+	f := New(tgbotapi.User{ID: 1, IsBot: true, UserName: "bot"})
+	f.SetCapabilities(messenger.Capabilities{})
+
+	f.SendText(10, "*bold*", messenger.SendOptions{Markdown: true})
+
+	last, _ := f.Last(10)
+	if last.Text != "bold" || last.Markdown {
+		t.Errorf("got %q markdown=%v, want plain bold", last.Text, last.Markdown)
+	}
+}
+
+func TestFakeChatMembers(t *testing.T) {
+	f := New(tgbotapi.User{ID: 1, IsBot: true, UserName: "bot"})
+	f.SetChatMember(-100, 7, "member")
+
+	if m, _ := f.GetChatMember(-100, 7); m.Status != "member" {
+		t.Errorf("status = %q, want member", m.Status)
+	}
+	if m, _ := f.GetChatMember(-100, 8); m.Status != "left" {
+		t.Errorf("status of a stranger = %q, want left", m.Status)
+	}
+}
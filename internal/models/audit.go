@@ -0,0 +1,54 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type AuditAction string
+
+const (
+	AuditMemberRegister   AuditAction = "member.register"
+	AuditMemberUpdate     AuditAction = "member.update"
+	AuditMemberApprove    AuditAction = "member.approve"
+	AuditMemberReject     AuditAction = "member.reject"
+	AuditMemberArchive    AuditAction = "member.archive"
+	AuditMemberRestore    AuditAction = "member.restore"
+	AuditPermissionChange AuditAction = "permission.change"
+	AuditTierCreate       AuditAction = "tier.create"
+	AuditTierRename       AuditAction = "tier.rename"
+	AuditTierRate         AuditAction = "tier.rate"
+	AuditTierRateCancel   AuditAction = "tier.rate_cancel"
+	AuditTierWeight       AuditAction = "tier.weight"
+	AuditTierDelete       AuditAction = "tier.delete"
+	AuditPricingMode      AuditAction = "group.pricing_mode"
+	AuditSessionCreate    AuditAction = "session.create"
+	AuditSessionCancel    AuditAction = "session.cancel"
+	AuditSessionCost      AuditAction = "session.cost"
+	AuditAttendanceRecord AuditAction = "attendance.record"
+	AuditAttendanceRevert AuditAction = "attendance.revert"
+	AuditPaymentRecord    AuditAction = "payment.record"
+	AuditPaymentClaim     AuditAction = "payment.claim"
+	AuditPaymentApprove   AuditAction = "payment.approve"
+	AuditPaymentReject    AuditAction = "payment.reject"
+	AuditAdjustmentGrant  AuditAction = "adjustment.grant"
+	AuditAdjustmentRevoke AuditAction = "adjustment.revoke"
+)
+
+// AuditEvent records one financial or administrative change in a group: who
+// made it, which member it concerns, and the values before and after. A nil
+// ActorID means the bot made the change on its own, for example when a
+// member left the chat.
+type AuditEvent struct {
+	ID        int64           `db:"id"`
+	GroupID   int64           `db:"group_id"`
+	ActorID   *int64          `db:"actor_id"`
+	ActorName string          `db:"actor_name"`
+	Action    AuditAction     `db:"action"`
+	UserID    *int64          `db:"user_id"`
+	UserName  string          `db:"user_name"`
+	EntityID  *int64          `db:"entity_id"`
+	Before    json.RawMessage `db:"before"`
+	After     json.RawMessage `db:"after"`
+	CreatedAt time.Time       `db:"created_at"`
+}
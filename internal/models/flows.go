@@ -0,0 +1,123 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Flow names
+const (
+	FlowRegistration = "registration"
+	FlowRate         = "rate"
+	FlowPayment      = "payment"
+	FlowClaim        = "claim"
+	FlowSession      = "session"
+	FlowTier         = "tier"
+	FlowWeight       = "weight"
+	FlowDiscount     = "discount"
+)
+
+// FlowData is what a multi-step flow collects before it is saved. Each flow
+// has its own struct, so handlers never type-assert loose map values.
+type FlowData interface {
+	FlowName() string
+}
+
+// RegistrationFlow registers a user in a group or edits their profile.
+type RegistrationFlow struct {
+	GroupID int64  `json:"group_id"`
+	Edit    bool   `json:"edit"`
+	UserID  int64  `json:"user_id"`
+	Name    string `json:"name"`
+}
+
+// RateFlow sets the per-session rate of a pricing tier from a given day.
+type RateFlow struct {
+	GroupID int64   `json:"group_id"`
+	TierID  int64   `json:"tier_id"`
+	AdminID int64   `json:"admin_id"`
+	Rate    float64 `json:"rate"`
+}
+
+// TierFlow names a new pricing tier, or renames one when TierID is set.
+type TierFlow struct {
+	GroupID int64 `json:"group_id"`
+	TierID  int64 `json:"tier_id"`
+	AdminID int64 `json:"admin_id"`
+}
+
+// PaymentFlow records a payment taken by an admin.
+type PaymentFlow struct {
+	GroupID      int64         `json:"group_id"`
+	TargetUserID int64         `json:"target_user_id"`
+	AdminID      int64         `json:"admin_id"`
+	Amount       float64       `json:"amount"`
+	Method       PaymentMethod `json:"method"`
+}
+
+// ClaimFlow reports a payment made by the member themselves.
+type ClaimFlow struct {
+	GroupID int64   `json:"group_id"`
+	UserID  int64   `json:"user_id"`
+	Amount  float64 `json:"amount"`
+}
+
+// SessionFlow schedules a new session, or sets the venue cost of the one
+// named by SessionID.
+type SessionFlow struct {
+	GroupID   int64     `json:"group_id"`
+	UserID    int64     `json:"user_id"`
+	SessionID int64     `json:"session_id"`
+	StartsAt  time.Time `json:"starts_at"`
+	Venue     string    `json:"venue"`
+	Capacity  int       `json:"capacity"`
+}
+
+// WeightFlow sets the share of the venue cost a pricing tier pays.
+type WeightFlow struct {
+	GroupID int64 `json:"group_id"`
+	TierID  int64 `json:"tier_id"`
+	AdminID int64 `json:"admin_id"`
+}
+
+// DiscountFlow grants a member a discount or exemption. Value is a percent
+// or an amount in toman depending on Kind.
+type DiscountFlow struct {
+	GroupID int64          `json:"group_id"`
+	UserID  int64          `json:"user_id"`
+	AdminID int64          `json:"admin_id"`
+	Kind    AdjustmentKind `json:"kind"`
+	Value   float64        `json:"value"`
+}
+
+func (*RegistrationFlow) FlowName() string { return FlowRegistration }
+func (*RateFlow) FlowName() string         { return FlowRate }
+func (*PaymentFlow) FlowName() string      { return FlowPayment }
+func (*ClaimFlow) FlowName() string        { return FlowClaim }
+func (*SessionFlow) FlowName() string      { return FlowSession }
+func (*TierFlow) FlowName() string         { return FlowTier }
+func (*WeightFlow) FlowName() string       { return FlowWeight }
+func (*DiscountFlow) FlowName() string     { return FlowDiscount }
+
+// NewFlowData returns empty data for a flow name, ready to be decoded into.
+func NewFlowData(flow string) (FlowData, error) {
+	switch flow {
+	case FlowRegistration:
+		return &RegistrationFlow{}, nil
+	case FlowRate:
+		return &RateFlow{}, nil
+	case FlowPayment:
+		return &PaymentFlow{}, nil
+	case FlowClaim:
+		return &ClaimFlow{}, nil
+	case FlowSession:
+		return &SessionFlow{}, nil
+	case FlowTier:
+		return &TierFlow{}, nil
+	case FlowWeight:
+		return &WeightFlow{}, nil
+	case FlowDiscount:
+		return &DiscountFlow{}, nil
+	}
+	return nil, fmt.Errorf("unknown flow %q", flow)
+}
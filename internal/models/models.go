@@ -2,58 +2,211 @@ package models
 
 import "time"
 
-type UserRole string
+// DefaultTier is a pricing tier every new group starts with.
+type DefaultTier struct {
+	Name   string
+	Weight float64
+}
+
+// DefaultPricingTiers are the tiers a new group starts with.
+var DefaultPricingTiers = []DefaultTier{
+	{Name: "دانشجو", Weight: 0.7},
+	{Name: "بزرگسال", Weight: 1},
+	{Name: "نیمه بزرگسال", Weight: 0.85},
+}
+
+// PricingMode is how a group charges its members for a session.
+type PricingMode string
 
 const (
-	RoleAdmin     UserRole = "admin"
-	RoleStudent   UserRole = "student"
-	RoleAdult     UserRole = "adult"
-	RoleHalfAdult UserRole = "half_adult"
+	// PricingFixed charges every attendee the rate of their tier.
+	PricingFixed PricingMode = "fixed"
+	// PricingSplit splits the venue cost of the session among its attendees
+	// in proportion to the weights of their tiers.
+	PricingSplit PricingMode = "split"
 )
 
-type User struct {
-	ID         int64     `db:"id"`
-	TelegramID int64     `db:"telegram_id"`
-	Username   string    `db:"username"`
-	FirstName  string    `db:"first_name"`
-	LastName   string    `db:"last_name"`
-	IsBot      bool      `db:"is_bot"`
-	CreatedAt  time.Time `db:"created_at"`
-	UpdatedAt  time.Time `db:"updated_at"`
-}
+type MembershipStatus string
 
-type Group struct {
+const (
+	MembershipPending  MembershipStatus = "pending"
+	MembershipActive   MembershipStatus = "active"
+	MembershipArchived MembershipStatus = "archived"
+)
+
+type SessionStatus string
+
+const (
+	SessionScheduled SessionStatus = "scheduled"
+	SessionCancelled SessionStatus = "cancelled"
+	SessionCompleted SessionStatus = "completed"
+)
+
+type RSVPStatus string
+
+const (
+	RSVPIn       RSVPStatus = "in"
+	RSVPOut      RSVPStatus = "out"
+	RSVPMaybe    RSVPStatus = "maybe"
+	RSVPWaitlist RSVPStatus = "waitlist"
+)
+
+type LedgerAccount string
+
+const (
+	AccountMember   LedgerAccount = "member"
+	AccountRevenue  LedgerAccount = "revenue"
+	AccountCash     LedgerAccount = "cash"
+	AccountDiscount LedgerAccount = "discount"
+)
+
+type AdjustmentKind string
+
+const (
+	AdjustmentPercent AdjustmentKind = "percent"
+	AdjustmentFixed   AdjustmentKind = "fixed"
+	AdjustmentExempt  AdjustmentKind = "exempt"
+)
+
+type LedgerKind string
+
+const (
+	LedgerCharge         LedgerKind = "charge"
+	LedgerPayment        LedgerKind = "payment"
+	LedgerReversal       LedgerKind = "reversal"
+	LedgerOpeningBalance LedgerKind = "opening_balance"
+)
+
+type PaymentMethod string
+
+const (
+	PaymentCash     PaymentMethod = "cash"
+	PaymentCard     PaymentMethod = "card"
+	PaymentTransfer PaymentMethod = "transfer"
+)
+
+type PaymentStatus string
+
+const (
+	PaymentPending  PaymentStatus = "pending"
+	PaymentApproved PaymentStatus = "approved"
+	PaymentRejected PaymentStatus = "rejected"
+)
+
+type User struct {
 	ID             int64     `db:"id"`
-	TelegramChatID int64     `db:"telegram_chat_id"`
-	Title          string    `db:"title"`
-	Type           string    `db:"type"`
+	TelegramID     int64     `db:"telegram_id"`
+	Username       string    `db:"username"`
+	FirstName      string    `db:"first_name"`
+	LastName       string    `db:"last_name"`
+	IsBot          bool      `db:"is_bot"`
+	CurrentGroupID *int64    `db:"current_group_id"`
 	CreatedAt      time.Time `db:"created_at"`
 	UpdatedAt      time.Time `db:"updated_at"`
 }
 
+type Group struct {
+	ID             int64       `db:"id"`
+	TelegramChatID int64       `db:"telegram_chat_id"`
+	Title          string      `db:"title"`
+	Type           string      `db:"type"`
+	PricingMode    PricingMode `db:"pricing_mode"`
+	CreatedAt      time.Time   `db:"created_at"`
+	UpdatedAt      time.Time   `db:"updated_at"`
+}
+
 type UserGroup struct {
-	ID           int64     `db:"id"`
-	UserID       int64     `db:"user_id"`
-	GroupID      int64     `db:"group_id"`
-	Role         UserRole  `db:"role"`
-	Name         string    `db:"name"`
-	SessionsOwed int       `db:"sessions_owed"`
-	CreatedAt    time.Time `db:"created_at"`
-	UpdatedAt    time.Time `db:"updated_at"`
+	ID         int64            `db:"id"`
+	UserID     int64            `db:"user_id"`
+	GroupID    int64            `db:"group_id"`
+	Permission Permission       `db:"permission"`
+	TierID     *int64           `db:"tier_id"`
+	Name       string           `db:"name"`
+	Status     MembershipStatus `db:"status"`
+	CreatedAt  time.Time        `db:"created_at"`
+	UpdatedAt  time.Time        `db:"updated_at"`
 }
 
-type Rate struct {
+// PricingTier is a price category of a group, such as student or guest.
+// RatePerSession is the rate in effect today. Weight is the share of the
+// venue cost a member of the tier pays relative to others when the group
+// splits venue costs.
+type PricingTier struct {
 	ID             int64     `db:"id"`
 	GroupID        int64     `db:"group_id"`
-	Role           UserRole  `db:"role"`
+	Name           string    `db:"name"`
 	RatePerSession float64   `db:"rate_per_session"`
+	Weight         float64   `db:"weight"`
+	Position       int       `db:"position"`
 	CreatedAt      time.Time `db:"created_at"`
 	UpdatedAt      time.Time `db:"updated_at"`
 }
 
+// TierRate is one version of a tier's rate. It applies to sessions held on
+// or after EffectiveFrom until the next version takes over; sessions before
+// the first version use the first version.
+type TierRate struct {
+	ID            int64     `db:"id"`
+	TierID        int64     `db:"tier_id"`
+	Rate          float64   `db:"rate"`
+	EffectiveFrom time.Time `db:"effective_from"`
+	CreatedBy     *int64    `db:"created_by"`
+	CreatedAt     time.Time `db:"created_at"`
+}
+
+// Adjustment is a discount or exemption granted to a member. With SessionID
+// set it applies to that session only, otherwise to every session held
+// between StartsOn and EndsOn; nil ends are open.
+type Adjustment struct {
+	ID        int64          `db:"id"`
+	GroupID   int64          `db:"group_id"`
+	UserID    int64          `db:"user_id"`
+	Kind      AdjustmentKind `db:"kind"`
+	Value     float64        `db:"value"`
+	SessionID *int64         `db:"session_id"`
+	StartsOn  *time.Time     `db:"starts_on"`
+	EndsOn    *time.Time     `db:"ends_on"`
+	CreatedBy *int64         `db:"created_by"`
+	CreatedAt time.Time      `db:"created_at"`
+	RevokedAt *time.Time     `db:"revoked_at"`
+}
+
+type Session struct {
+	ID            int64         `db:"id"`
+	GroupID       int64         `db:"group_id"`
+	StartsAt      time.Time     `db:"starts_at"`
+	Venue         string        `db:"venue"`
+	Capacity      int           `db:"capacity"`
+	VenueCost     *float64      `db:"venue_cost"`
+	Status        SessionStatus `db:"status"`
+	CreatedBy     *int64        `db:"created_by"`
+	RSVPMessageID *int          `db:"rsvp_message_id"`
+	CreatedAt     time.Time     `db:"created_at"`
+	UpdatedAt     time.Time     `db:"updated_at"`
+}
+
+// RSVP is a member's answer to a session poll. Name is the member's
+// registered name in the session's group.
+type RSVP struct {
+	SessionID int64      `db:"session_id"`
+	UserID    int64      `db:"user_id"`
+	Name      string     `db:"name"`
+	Status    RSVPStatus `db:"status"`
+	UpdatedAt time.Time  `db:"updated_at"`
+}
+
+// RSVPChange is the outcome of answering a session poll. Status may differ
+// from the requested one when a full session puts the member on the
+// waitlist; Promoted lists the waitlisted users that got a freed spot.
+type RSVPChange struct {
+	Status   RSVPStatus
+	Promoted []int64
+}
+
 type AttendanceRecord struct {
 	ID         int64      `db:"id"`
 	GroupID    int64      `db:"group_id"`
+	SessionID  *int64     `db:"session_id"`
 	AdminID    int64      `db:"admin_id"`
 	UserIDs    []int64    `db:"user_ids"`
 	CreatedAt  time.Time  `db:"created_at"`
@@ -61,9 +214,94 @@ type AttendanceRecord struct {
 	IsReverted bool       `db:"is_reverted"`
 }
 
+// LedgerTransaction is one financial event. Its postings always sum to zero.
+type LedgerTransaction struct {
+	ID                 int64      `db:"id"`
+	GroupID            int64      `db:"group_id"`
+	Kind               LedgerKind `db:"kind"`
+	AttendanceRecordID *int64     `db:"attendance_record_id"`
+	SessionID          *int64     `db:"session_id"`
+	PaymentID          *int64     `db:"payment_id"`
+	ReversesID         *int64     `db:"reverses_id"`
+	Description        string     `db:"description"`
+	CreatedBy          *int64     `db:"created_by"`
+	OccurredAt         time.Time  `db:"occurred_at"`
+	CreatedAt          time.Time  `db:"created_at"`
+}
+
+// LedgerPosting moves Amount into an account. On a member account a positive
+// amount is debt and Sessions counts the sessions it was charged for. Weight
+// is set on charges split from a venue cost.
+type LedgerPosting struct {
+	ID            int64         `db:"id"`
+	TransactionID int64         `db:"transaction_id"`
+	Account       LedgerAccount `db:"account"`
+	UserID        *int64        `db:"user_id"`
+	Amount        float64       `db:"amount"`
+	Sessions      int           `db:"sessions"`
+	Weight        *float64      `db:"weight"`
+	AdjustmentID  *int64        `db:"adjustment_id"`
+}
+
+// Payment is money a member paid to the group. Method is empty when it
+// was not recorded. Payments claimed by members stay pending, and off the
+// ledger, until an admin reviews them.
+type Payment struct {
+	ID            int64         `db:"id"`
+	GroupID       int64         `db:"group_id"`
+	UserID        int64         `db:"user_id"`
+	Amount        float64       `db:"amount"`
+	Method        PaymentMethod `db:"method"`
+	Note          string        `db:"note"`
+	Status        PaymentStatus `db:"status"`
+	ReceiptFileID string        `db:"receipt_file_id"`
+	RecordedBy    *int64        `db:"recorded_by"`
+	ReviewedBy    *int64        `db:"reviewed_by"`
+	ReviewedAt    *time.Time    `db:"reviewed_at"`
+	CreatedAt     time.Time     `db:"created_at"`
+}
+
+// Balance summarises a member's account in a group. Charged is the session
+// fees before Discounts are taken off. Balance is what the member owes; a
+// negative balance is credit.
+type Balance struct {
+	UserID    int64
+	Sessions  int
+	Charged   float64
+	Discounts float64
+	Paid      float64
+	Balance   float64
+}
+
+// Charge is a session fee that is still (partly) unpaid. Amount is the
+// unpaid part of the fee and Discount what was taken off it.
+type Charge struct {
+	TransactionID int64
+	SessionID     *int64
+	Date          time.Time
+	Venue         string
+	Amount        float64
+	Discount      float64
+}
+
+// AttendanceResult is the per-user breakdown of an attendance registration.
+// Record is nil when nothing was written.
+type AttendanceResult struct {
+	Record     *AttendanceRecord
+	Credited   []UserGroup
+	Unknown    []string
+	NotMembers []string
+	Duplicates []string
+}
+
+// UserState is one in-progress flow of a user in a chat. A user can have
+// several flows open at once, one per flow name; State is the step the flow
+// is waiting on.
 type UserState struct {
 	UserID      int64
+	ChatID      int64
+	Flow        string
 	State       string
-	TempData    map[string]interface{}
+	Data        FlowData
 	LastUpdated time.Time
 }
@@ -0,0 +1,69 @@
+package models
+
+// Permission is what a member may manage in a group. It is independent of
+// the pricing tier they pay at.
+type Permission string
+
+const (
+	PermissionNone      Permission = ""
+	PermissionOwner     Permission = "owner"
+	PermissionAdmin     Permission = "admin"
+	PermissionTreasurer Permission = "treasurer"
+	PermissionMember    Permission = "member"
+)
+
+// Capability is a single thing a permission allows.
+type Capability int
+
+const (
+	CapManageRates       Capability = iota // pricing tiers and their rates
+	CapManageMembers                       // invites, registrations and chat sync
+	CapManageSessions                      // sessions, RSVP polls and attendance
+	CapManagePayments                      // recording payments and reviewing claims
+	CapViewReports                         // group balances and reports
+	CapManagePermissions                   // promoting and demoting members
+)
+
+var permissionCapabilities = map[Permission][]Capability{
+	PermissionOwner: {
+		CapManageRates, CapManageMembers, CapManageSessions,
+		CapManagePayments, CapViewReports, CapManagePermissions,
+	},
+	PermissionAdmin: {
+		CapManageRates, CapManageMembers, CapManageSessions,
+		CapManagePayments, CapViewReports, CapManagePermissions,
+	},
+	PermissionTreasurer: {CapManagePayments, CapViewReports},
+}
+
+// Can reports whether the permission allows a capability.
+func (p Permission) Can(c Capability) bool {
+	for _, have := range permissionCapabilities[p] {
+		if have == c {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionsWith returns every permission that allows a capability.
+func PermissionsWith(c Capability) []Permission {
+	var perms []Permission
+	for _, p := range []Permission{PermissionOwner, PermissionAdmin, PermissionTreasurer, PermissionMember} {
+		if p.Can(c) {
+			perms = append(perms, p)
+		}
+	}
+	return perms
+}
+
+// Access is what a user may do in a group.
+type Access struct {
+	User       *User
+	GroupID    int64
+	Permission Permission
+}
+
+func (a *Access) Can(c Capability) bool {
+	return a.Permission.Can(c)
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPermissionCan(t *testing.T) {
+	all := []Capability{
+		CapManageRates, CapManageMembers, CapManageSessions,
+		CapManagePayments, CapViewReports, CapManagePermissions,
+	}
+
+	tests := []struct {
+		permission Permission
+		want       []Capability
+	}{
+		{PermissionOwner, all},
+		{PermissionAdmin, all},
+		{PermissionTreasurer, []Capability{CapManagePayments, CapViewReports}},
+		{PermissionMember, nil},
+		{PermissionNone, nil},
+		{Permission("superuser"), nil},
+	}
+
+	for _, tt := range tests {
+		var got []Capability
+		for _, c := range all {
+			if tt.permission.Can(c) {
+				got = append(got, c)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q can %v, want %v", tt.permission, got, tt.want)
+		}
+	}
+}
+
+func TestPermissionsWith(t *testing.T) {
+	if got, want := PermissionsWith(CapViewReports), []Permission{PermissionOwner, PermissionAdmin, PermissionTreasurer}; !reflect.DeepEqual(got, want) {
+		t.Errorf("PermissionsWith(reports) = %v, want %v", got, want)
+	}
+	if got, want := PermissionsWith(CapManageSessions), []Permission{PermissionOwner, PermissionAdmin}; !reflect.DeepEqual(got, want) {
+		t.Errorf("PermissionsWith(sessions) = %v, want %v", got, want)
+	}
+}
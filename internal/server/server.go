@@ -0,0 +1,158 @@
+// Package server is the bot's HTTP server: it receives webhook updates and
+// answers the health checks of the reverse proxy and orchestrator.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// SecretTokenHeader carries the secret given to setWebhook on every delivery.
+const SecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// readyTimeout bounds how long a readiness check may take.
+const readyTimeout = 2 * time.Second
+
+// Config holds the settings of a Server.
+type Config struct {
+	// Addr is the address to listen on, e.g. ":8080".
+	Addr string
+	// WebhookPath is where updates are posted; empty serves health checks only.
+	WebhookPath string
+	// SecretToken must match the secret token header of every update. Empty
+	// skips the check, for platforms that can't send the header and keep
+	// the secret in WebhookPath instead.
+	SecretToken string
+	// Ready reports whether the bot can serve updates, e.g. by pinging the
+	// database. Nil means always ready.
+	Ready func(ctx context.Context) error
+}
+
+type Server struct {
+	http     *http.Server
+	updates  chan tgbotapi.Update
+	secret   []byte
+	ready    func(ctx context.Context) error
+	stopping chan struct{}
+	stopOnce sync.Once
+}
+
+func New(cfg Config) *Server {
+	s := &Server{
+		updates:  make(chan tgbotapi.Update, 100),
+		secret:   []byte(cfg.SecretToken),
+		ready:    cfg.Ready,
+		stopping: make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/readyz", s.handleReady)
+	if cfg.WebhookPath != "" {
+		mux.HandleFunc(cfg.WebhookPath, s.handleWebhook)
+	}
+
+	s.http = &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return s
+}
+
+// Updates is the channel webhook updates are delivered on.
+func (s *Server) Updates() tgbotapi.UpdatesChannel {
+	return s.updates
+}
+
+// ListenAndServe serves until the server fails or is shut down.
+func (s *Server) ListenAndServe() error {
+	zap.L().Info("HTTP server listening", zap.String("addr", s.http.Addr))
+	return s.http.ListenAndServe()
+}
+
+// Shutdown stops taking updates and waits for the requests in flight to
+// finish until ctx is done. Updates not yet queued are turned away so the
+// sender delivers them again once the bot is back.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stopping) })
+	return s.http.Shutdown(ctx)
+}
+
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-s.stopping:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := []byte(r.Header.Get(SecretTokenHeader))
+	if len(s.secret) > 0 && subtle.ConstantTimeCompare(token, s.secret) != 1 {
+		zap.L().Warn("Rejected webhook request with a wrong secret token", zap.String("remote_addr", r.RemoteAddr))
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var update tgbotapi.Update
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		zap.L().Warn("Invalid webhook update", zap.Error(err))
+		http.Error(w, "invalid update", http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case s.updates <- update:
+		w.WriteHeader(http.StatusOK)
+	case <-s.stopping:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	case <-r.Context().Done():
+		// The sender gave up and will deliver the update again
+	}
+}
+
+// handleHealth reports that the process is up.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, "ok")
+}
+
+// handleReady reports whether the bot can serve updates right now.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-s.stopping:
+		writeStatus(w, http.StatusServiceUnavailable, "shutting down")
+		return
+	default:
+	}
+
+	if s.ready != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+		if err := s.ready(ctx); err != nil {
+			zap.L().Warn("Readiness check failed", zap.Error(err))
+			writeStatus(w, http.StatusServiceUnavailable, "unavailable")
+			return
+		}
+	}
+	writeStatus(w, http.StatusOK, "ok")
+}
+
+func writeStatus(w http.ResponseWriter, code int, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
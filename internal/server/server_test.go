@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+	s := New(Config{WebhookPath: "/webhook", SecretToken: "s3cret"})
+	handler := s.http.Handler
+
+	post := func(secret, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+		if secret != "" {
+			req.Header.Set(SecretTokenHeader, secret)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post("wrong", `{"update_id": 1}`); code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: status %d, want 401", code)
+	}
+	if code := post("s3cret", `not json`); code != http.StatusBadRequest {
+		t.Errorf("invalid body: status %d, want 400", code)
+	}
+	if code := post("s3cret", `{"update_id": 7}`); code != http.StatusOK {
+		t.Fatalf("valid update: status %d, want 200", code)
+	}
+	if update := <-s.Updates(); update.UpdateID != 7 {
+		t.Errorf("queued update %d, want 7", update.UpdateID)
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if code := post("s3cret", `{"update_id": 8}`); code != http.StatusServiceUnavailable {
+		t.Errorf("update while stopping: status %d, want 503", code)
+	}
+}
+
+func TestReady(t *testing.T) {
+	var ready error
+	s := New(Config{Ready: func(ctx context.Context) error { return ready }})
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		s.http.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if code := get("/readyz"); code != http.StatusOK {
+		t.Errorf("ready: status %d, want 200", code)
+	}
+	ready = errors.New("database is down")
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("not ready: status %d, want 503", code)
+	}
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Errorf("health while not ready: status %d, want 200", code)
+	}
+}
@@ -0,0 +1,147 @@
+// Package worker processes bot updates concurrently while keeping the updates
+// of each chat in the order they arrived.
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// ErrClosed is returned when submitting to a pool that is shutting down.
+var ErrClosed = errors.New("worker pool is closed")
+
+// Pool runs updates on a fixed set of workers. Updates with the same key
+// always go to the same worker, so a chat's updates, and in private chats a
+// user's, are handled one at a time in order while other chats proceed.
+// Each worker has a bounded queue; Submit blocks while it is full.
+type Pool struct {
+	queues []chan tgbotapi.Update
+	handle func(tgbotapi.Update)
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewPool starts workers that each queue up to queueSize updates and hand
+// them to handle.
+func NewPool(workers, queueSize int, handle func(tgbotapi.Update)) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+
+	p := &Pool{
+		queues: make([]chan tgbotapi.Update, workers),
+		handle: handle,
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan tgbotapi.Update, queueSize)
+		p.wg.Add(1)
+		go p.work(i)
+	}
+
+	return p
+}
+
+// Key is what orders an update: the chat it happened in, or the user who
+// sent it when there is no chat, e.g. an inline button on an old message.
+func Key(update tgbotapi.Update) int64 {
+	// FromChat assumes a callback always comes with its message
+	if cb := update.CallbackQuery; cb != nil {
+		if cb.Message != nil && cb.Message.Chat != nil {
+			return cb.Message.Chat.ID
+		}
+	} else if chat := update.FromChat(); chat != nil {
+		return chat.ID
+	}
+	if user := update.SentFrom(); user != nil {
+		return user.ID
+	}
+	return 0
+}
+
+// Submit queues an update behind the earlier updates of its chat. It waits
+// for room while the chat's worker is backed up, which slows intake down
+// instead of piling updates up in memory, and gives up when ctx is done.
+func (p *Pool) Submit(ctx context.Context, update tgbotapi.Update) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrClosed
+	}
+
+	queue := p.queues[uint64(Key(update))%uint64(len(p.queues))]
+	select {
+	case queue <- update:
+		return nil
+	default:
+	}
+
+	zap.L().Warn("Update queue is full, waiting", zap.Int("update_id", update.UpdateID), zap.Int("queue_size", cap(queue)))
+	select {
+	case queue <- update:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops taking updates and waits for the queued ones to be handled.
+// It returns ctx.Err() if they are not done by the time ctx is; the workers
+// keep going in the background.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, queue := range p.queues {
+			close(queue)
+		}
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		pending := 0
+		for _, queue := range p.queues {
+			pending += len(queue)
+		}
+		return fmt.Errorf("%d updates not handled: %w", pending, ctx.Err())
+	}
+}
+
+func (p *Pool) work(i int) {
+	defer p.wg.Done()
+	for update := range p.queues[i] {
+		p.run(update)
+	}
+}
+
+// run handles one update. A panicking handler loses its update, not the
+// worker and the chats queued behind it.
+func (p *Pool) run(update tgbotapi.Update) {
+	defer func() {
+		if r := recover(); r != nil {
+			zap.L().Error("Panic while handling update",
+				zap.Any("panic", r), zap.Int("update_id", update.UpdateID), zap.ByteString("stack", debug.Stack()))
+		}
+	}()
+	p.handle(update)
+}
@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func chatUpdate(id int, chatID int64) tgbotapi.Update {
+	return tgbotapi.Update{UpdateID: id, Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}}}
+}
+
+func TestKey(t *testing.T) {
+	user := &tgbotapi.User{ID: 42}
+	tests := []struct {
+		name   string
+		update tgbotapi.Update
+		want   int64
+	}{
+		{"message", chatUpdate(1, -100), -100},
+		{"callback", tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{From: user, Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: -100}}}}, -100},
+		{"callback without message", tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{From: user}}, 42},
+		{"empty", tgbotapi.Update{}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := Key(tt.update); got != tt.want {
+			t.Errorf("%s: Key = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPoolKeepsChatOrder(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[int64][]int{}
+	pool := NewPool(4, 10, func(u tgbotapi.Update) {
+		if u.UpdateID%7 == 0 {
+			panic("handler failed")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		seen[u.Message.Chat.ID] = append(seen[u.Message.Chat.ID], u.UpdateID)
+	})
+
+	ctx := context.Background()
+	for i := 1; i <= 200; i++ {
+		if err := pool.Submit(ctx, chatUpdate(i, int64(i%5))); err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := pool.Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	total := 0
+	for chatID, ids := range seen {
+		total += len(ids)
+		for i := 1; i < len(ids); i++ {
+			if ids[i] < ids[i-1] {
+				t.Fatalf("chat %d handled out of order: %v", chatID, ids)
+			}
+		}
+	}
+	if want := 200 - 200/7; total != want {
+		t.Errorf("handled %d updates, want %d", total, want)
+	}
+
+	if err := pool.Submit(ctx, chatUpdate(201, 1)); !errors.Is(err, ErrClosed) {
+		t.Errorf("Submit after Shutdown = %v, want ErrClosed", err)
+	}
+}
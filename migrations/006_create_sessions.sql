@@ -0,0 +1,26 @@
+-- +goose Up
+CREATE TYPE session_status AS ENUM ('scheduled', 'cancelled', 'completed');
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id BIGSERIAL PRIMARY KEY,
+    group_id BIGINT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    venue VARCHAR(255) NOT NULL DEFAULT '',
+    capacity INTEGER NOT NULL DEFAULT 0,
+    status session_status NOT NULL DEFAULT 'scheduled',
+    created_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_sessions_group_id ON sessions(group_id);
+CREATE INDEX idx_sessions_starts_at ON sessions(starts_at);
+
+ALTER TABLE attendance_records ADD COLUMN session_id BIGINT REFERENCES sessions(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_attendance_records_session_id ON attendance_records(session_id);
+
+-- +goose Down
+ALTER TABLE attendance_records DROP COLUMN IF EXISTS session_id;
+DROP TABLE IF EXISTS sessions;
+DROP TYPE IF EXISTS session_status;
@@ -0,0 +1,21 @@
+-- +goose Up
+CREATE TYPE rsvp_status AS ENUM ('in', 'out', 'maybe', 'waitlist');
+
+CREATE TABLE IF NOT EXISTS session_rsvps (
+    id BIGSERIAL PRIMARY KEY,
+    session_id BIGINT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status rsvp_status NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(session_id, user_id)
+);
+
+CREATE INDEX idx_session_rsvps_session_id ON session_rsvps(session_id);
+
+ALTER TABLE sessions ADD COLUMN rsvp_message_id BIGINT;
+
+-- +goose Down
+ALTER TABLE sessions DROP COLUMN IF EXISTS rsvp_message_id;
+DROP TABLE IF EXISTS session_rsvps;
+DROP TYPE IF EXISTS rsvp_status;
@@ -0,0 +1,77 @@
+-- +goose Up
+-- Every financial event is a transaction made of postings that sum to zero.
+-- Accounts: 'member' (what a member owes the group, positive = debt),
+-- 'revenue' (session fees earned) and 'cash' (money received).
+CREATE TABLE IF NOT EXISTS ledger_transactions (
+    id BIGSERIAL PRIMARY KEY,
+    group_id BIGINT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    kind VARCHAR(32) NOT NULL,
+    attendance_record_id BIGINT REFERENCES attendance_records(id) ON DELETE SET NULL,
+    session_id BIGINT REFERENCES sessions(id) ON DELETE SET NULL,
+    reverses_id BIGINT REFERENCES ledger_transactions(id),
+    description TEXT NOT NULL DEFAULT '',
+    created_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_ledger_transactions_group_id ON ledger_transactions(group_id);
+CREATE INDEX idx_ledger_transactions_attendance_record_id ON ledger_transactions(attendance_record_id);
+CREATE UNIQUE INDEX idx_ledger_transactions_reverses_id ON ledger_transactions(reverses_id);
+
+CREATE TABLE IF NOT EXISTS ledger_postings (
+    id BIGSERIAL PRIMARY KEY,
+    transaction_id BIGINT NOT NULL REFERENCES ledger_transactions(id) ON DELETE CASCADE,
+    account VARCHAR(32) NOT NULL,
+    user_id BIGINT REFERENCES users(id) ON DELETE CASCADE,
+    amount DECIMAL(12, 2) NOT NULL,
+    sessions INTEGER NOT NULL DEFAULT 0,
+    CHECK (account <> 'member' OR user_id IS NOT NULL)
+);
+
+CREATE INDEX idx_ledger_postings_transaction_id ON ledger_postings(transaction_id);
+CREATE INDEX idx_ledger_postings_user_id ON ledger_postings(user_id);
+
+-- Carry the existing counters over as opening balances at today's rates
+-- +goose StatementBegin
+DO $$
+DECLARE
+    ug RECORD;
+    txn_id BIGINT;
+    amount DECIMAL(12, 2);
+BEGIN
+    FOR ug IN
+        SELECT u.user_id, u.group_id, u.sessions_owed, COALESCE(r.rate_per_session, 0) AS rate
+        FROM user_groups u
+        LEFT JOIN rates r ON r.group_id = u.group_id AND r.role = u.role
+        WHERE u.sessions_owed <> 0
+    LOOP
+        amount := ug.sessions_owed * ug.rate;
+        INSERT INTO ledger_transactions (group_id, kind, description)
+        VALUES (ug.group_id, 'opening_balance', 'sessions_owed before ledger')
+        RETURNING id INTO txn_id;
+        INSERT INTO ledger_postings (transaction_id, account, user_id, amount, sessions)
+        VALUES (txn_id, 'member', ug.user_id, amount, ug.sessions_owed),
+               (txn_id, 'revenue', NULL, -amount, 0);
+    END LOOP;
+END $$;
+-- +goose StatementEnd
+
+ALTER TABLE user_groups DROP COLUMN sessions_owed;
+
+-- +goose Down
+ALTER TABLE user_groups ADD COLUMN sessions_owed INTEGER DEFAULT 0;
+
+UPDATE user_groups ug
+SET sessions_owed = s.sessions
+FROM (
+    SELECT t.group_id, p.user_id, SUM(p.sessions) AS sessions
+    FROM ledger_postings p
+    JOIN ledger_transactions t ON t.id = p.transaction_id
+    WHERE p.account = 'member'
+    GROUP BY t.group_id, p.user_id
+) s
+WHERE ug.group_id = s.group_id AND ug.user_id = s.user_id;
+
+DROP TABLE IF EXISTS ledger_postings;
+DROP TABLE IF EXISTS ledger_transactions;
@@ -0,0 +1,42 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS payments (
+    id BIGSERIAL PRIMARY KEY,
+    group_id BIGINT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    amount DECIMAL(12, 2) NOT NULL CHECK (amount > 0),
+    method VARCHAR(16) NOT NULL DEFAULT '',
+    note TEXT NOT NULL DEFAULT '',
+    recorded_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_payments_group_id_user_id ON payments(group_id, user_id);
+
+ALTER TABLE ledger_transactions ADD COLUMN payment_id BIGINT REFERENCES payments(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_ledger_transactions_payment_id ON ledger_transactions(payment_id);
+
+-- Give the payments already in the ledger their own record
+-- +goose StatementBegin
+DO $$
+DECLARE
+    t RECORD;
+    new_payment_id BIGINT;
+BEGIN
+    FOR t IN
+        SELECT lt.id, lt.group_id, lt.description, lt.created_by, lt.created_at, p.user_id, -p.amount AS amount
+        FROM ledger_transactions lt
+        JOIN ledger_postings p ON p.transaction_id = lt.id AND p.account = 'member'
+        WHERE lt.kind = 'payment' AND p.amount < 0
+    LOOP
+        INSERT INTO payments (group_id, user_id, amount, note, recorded_by, created_at)
+        VALUES (t.group_id, t.user_id, t.amount, t.description, t.created_by, t.created_at)
+        RETURNING id INTO new_payment_id;
+        UPDATE ledger_transactions SET payment_id = new_payment_id WHERE id = t.id;
+    END LOOP;
+END $$;
+-- +goose StatementEnd
+
+-- +goose Down
+ALTER TABLE ledger_transactions DROP COLUMN IF EXISTS payment_id;
+DROP TABLE IF EXISTS payments;
@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TYPE payment_status AS ENUM ('pending', 'approved', 'rejected');
+
+ALTER TABLE payments ADD COLUMN status payment_status NOT NULL DEFAULT 'approved';
+ALTER TABLE payments ADD COLUMN receipt_file_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN reviewed_by BIGINT REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE payments ADD COLUMN reviewed_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_payments_pending ON payments(group_id) WHERE status = 'pending';
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_payments_pending;
+ALTER TABLE payments DROP COLUMN IF EXISTS reviewed_at;
+ALTER TABLE payments DROP COLUMN IF EXISTS reviewed_by;
+ALTER TABLE payments DROP COLUMN IF EXISTS receipt_file_id;
+ALTER TABLE payments DROP COLUMN IF EXISTS status;
+DROP TYPE IF EXISTS payment_status;
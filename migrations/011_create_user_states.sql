@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS user_states (
+    telegram_id BIGINT PRIMARY KEY,
+    state VARCHAR(64) NOT NULL,
+    temp_data JSONB NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_user_states_updated_at ON user_states(updated_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_states;
@@ -0,0 +1,29 @@
+-- +goose Up
+-- States are keyed by (user, chat, flow) from now on. In-progress flows are
+-- short-lived and stored in a different shape, so they are not carried over.
+DROP TABLE IF EXISTS user_states;
+
+CREATE TABLE user_states (
+    telegram_id BIGINT NOT NULL,
+    chat_id BIGINT NOT NULL,
+    flow VARCHAR(32) NOT NULL,
+    state VARCHAR(64) NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (telegram_id, chat_id, flow)
+);
+
+CREATE INDEX idx_user_states_chat ON user_states(telegram_id, chat_id, updated_at);
+CREATE INDEX idx_user_states_updated_at ON user_states(updated_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_states;
+
+CREATE TABLE user_states (
+    telegram_id BIGINT PRIMARY KEY,
+    state VARCHAR(64) NOT NULL,
+    temp_data JSONB NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_user_states_updated_at ON user_states(updated_at);
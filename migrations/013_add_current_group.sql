@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN current_group_id BIGINT REFERENCES groups(id) ON DELETE SET NULL;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN IF EXISTS current_group_id;
@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TYPE membership_status AS ENUM ('pending', 'active', 'archived');
+
+-- Memberships created before approvals existed stay active
+ALTER TABLE user_groups ADD COLUMN status membership_status NOT NULL DEFAULT 'active';
+
+CREATE INDEX idx_user_groups_pending ON user_groups(group_id) WHERE status = 'pending';
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_user_groups_pending;
+ALTER TABLE user_groups DROP COLUMN IF EXISTS status;
+DROP TYPE IF EXISTS membership_status;
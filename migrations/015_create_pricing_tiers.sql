@@ -0,0 +1,96 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS pricing_tiers (
+    id BIGSERIAL PRIMARY KEY,
+    group_id BIGINT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    rate_per_session DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(group_id, name)
+);
+
+CREATE INDEX idx_pricing_tiers_group_id ON pricing_tiers(group_id);
+
+-- Every group starts with the three tiers that used to be roles, at the
+-- rates that were set for them
+INSERT INTO pricing_tiers (group_id, name, rate_per_session, position)
+SELECT g.id, t.name, COALESCE(r.rate_per_session, 0), t.position
+FROM groups g
+CROSS JOIN (VALUES
+    ('student'::user_role, 'دانشجو', 1),
+    ('adult'::user_role, 'بزرگسال', 2),
+    ('half_adult'::user_role, 'نیمه بزرگسال', 3)
+) AS t(role, name, position)
+LEFT JOIN rates r ON r.group_id = g.id AND r.role = t.role;
+
+ALTER TABLE user_groups ADD COLUMN tier_id BIGINT REFERENCES pricing_tiers(id) ON DELETE RESTRICT;
+
+UPDATE user_groups ug
+SET tier_id = pt.id
+FROM pricing_tiers pt
+WHERE pt.group_id = ug.group_id
+  AND pt.name = CASE ug.role
+      WHEN 'student' THEN 'دانشجو'
+      WHEN 'adult' THEN 'بزرگسال'
+      WHEN 'half_adult' THEN 'نیمه بزرگسال'
+  END;
+
+CREATE INDEX idx_user_groups_tier_id ON user_groups(tier_id);
+
+-- The role now only says whether a member is an admin; pricing is the tier
+ALTER TABLE user_groups ALTER COLUMN role TYPE VARCHAR(16)
+    USING (CASE WHEN role = 'admin' THEN 'admin' ELSE 'member' END);
+
+DROP TABLE IF EXISTS rates;
+DROP TYPE IF EXISTS user_role;
+
+-- +goose Down
+CREATE TYPE user_role AS ENUM ('admin', 'student', 'adult', 'half_adult');
+
+CREATE TABLE IF NOT EXISTS rates (
+    id BIGSERIAL PRIMARY KEY,
+    group_id BIGINT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    role user_role NOT NULL,
+    rate_per_session DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(group_id, role)
+);
+
+CREATE INDEX idx_rates_group_id ON rates(group_id);
+CREATE INDEX idx_rates_role ON rates(role);
+
+-- Only the three default tiers map back to roles; members of other tiers
+-- become adults
+INSERT INTO rates (group_id, role, rate_per_session)
+SELECT group_id, t.role, rate_per_session
+FROM pricing_tiers pt
+JOIN (VALUES
+    ('student'::user_role, 'دانشجو'),
+    ('adult'::user_role, 'بزرگسال'),
+    ('half_adult'::user_role, 'نیمه بزرگسال')
+) AS t(role, name) ON t.name = pt.name;
+
+ALTER TABLE user_groups ADD COLUMN old_role user_role;
+
+UPDATE user_groups ug
+SET old_role = (CASE
+    WHEN ug.role = 'admin' THEN 'admin'
+    ELSE COALESCE((
+        SELECT CASE pt.name
+            WHEN 'دانشجو' THEN 'student'
+            WHEN 'بزرگسال' THEN 'adult'
+            WHEN 'نیمه بزرگسال' THEN 'half_adult'
+        END
+        FROM pricing_tiers pt WHERE pt.id = ug.tier_id
+    ), 'adult')
+END)::user_role;
+
+ALTER TABLE user_groups DROP COLUMN role;
+ALTER TABLE user_groups RENAME COLUMN old_role TO role;
+ALTER TABLE user_groups ALTER COLUMN role SET NOT NULL;
+CREATE INDEX idx_user_groups_role ON user_groups(role);
+
+ALTER TABLE user_groups DROP COLUMN IF EXISTS tier_id;
+DROP TABLE IF EXISTS pricing_tiers;
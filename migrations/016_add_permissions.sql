@@ -0,0 +1,39 @@
+-- +goose Up
+CREATE TYPE group_permission AS ENUM ('owner', 'admin', 'treasurer', 'member');
+
+ALTER TABLE user_groups RENAME COLUMN role TO permission;
+ALTER TABLE user_groups ALTER COLUMN permission TYPE group_permission USING permission::group_permission;
+ALTER TABLE user_groups ALTER COLUMN permission SET DEFAULT 'member';
+ALTER INDEX idx_user_groups_role RENAME TO idx_user_groups_permission;
+
+-- The longest-standing admin of each group becomes its owner
+UPDATE user_groups
+SET permission = 'owner'
+WHERE id IN (
+    SELECT DISTINCT ON (group_id) id
+    FROM user_groups
+    WHERE permission = 'admin' AND status = 'active'
+    ORDER BY group_id, created_at, id
+);
+
+-- Admins, and so owners, used to pick the admin role instead of a tier and
+-- were never charged; put them on the full-fare tier of their group, or else
+-- its first one, so they pay when they play. They can pick another by
+-- editing their profile.
+UPDATE user_groups ug
+SET tier_id = (
+    SELECT pt.id FROM pricing_tiers pt
+    WHERE pt.group_id = ug.group_id
+    ORDER BY pt.name = 'بزرگسال' DESC, pt.position, pt.id
+    LIMIT 1
+)
+WHERE ug.tier_id IS NULL;
+
+-- +goose Down
+ALTER TABLE user_groups ALTER COLUMN permission DROP DEFAULT;
+ALTER TABLE user_groups ALTER COLUMN permission TYPE VARCHAR(16)
+    USING (CASE WHEN permission IN ('owner', 'admin') THEN 'admin' ELSE 'member' END);
+ALTER INDEX idx_user_groups_permission RENAME TO idx_user_groups_role;
+ALTER TABLE user_groups RENAME COLUMN permission TO role;
+
+DROP TYPE IF EXISTS group_permission;
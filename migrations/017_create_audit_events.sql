@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    group_id BIGINT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    actor_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    action VARCHAR(64) NOT NULL,
+    user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    entity_id BIGINT,
+    before JSONB,
+    after JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_audit_events_group_id ON audit_events(group_id, created_at DESC, id DESC);
+CREATE INDEX idx_audit_events_user_id ON audit_events(user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS audit_events;
@@ -0,0 +1,31 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS tier_rates (
+    id BIGSERIAL PRIMARY KEY,
+    tier_id BIGINT NOT NULL REFERENCES pricing_tiers(id) ON DELETE CASCADE,
+    rate DECIMAL(10, 2) NOT NULL,
+    effective_from DATE NOT NULL,
+    created_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(tier_id, effective_from)
+);
+
+-- The current rate of every tier becomes its first version. It has always
+-- applied, so it starts at the epoch rather than on the day of the upgrade,
+-- which would leave earlier sessions without a rate.
+INSERT INTO tier_rates (tier_id, rate, effective_from)
+SELECT id, rate_per_session, DATE '1970-01-01'
+FROM pricing_tiers;
+
+ALTER TABLE pricing_tiers DROP COLUMN rate_per_session;
+
+-- +goose Down
+ALTER TABLE pricing_tiers ADD COLUMN rate_per_session DECIMAL(10, 2) NOT NULL DEFAULT 0;
+
+UPDATE pricing_tiers pt
+SET rate_per_session = COALESCE(
+    (SELECT rate FROM tier_rates WHERE tier_id = pt.id AND effective_from <= CURRENT_DATE ORDER BY effective_from DESC LIMIT 1),
+    (SELECT rate FROM tier_rates WHERE tier_id = pt.id ORDER BY effective_from LIMIT 1),
+    0
+);
+
+DROP TABLE IF EXISTS tier_rates;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- A group either charges every attendee the rate of their tier ('fixed') or
+-- splits the venue cost of each session among its attendees by tier weight
+-- ('split')
+ALTER TABLE groups ADD COLUMN pricing_mode VARCHAR(16) NOT NULL DEFAULT 'fixed'
+    CHECK (pricing_mode IN ('fixed', 'split'));
+
+ALTER TABLE pricing_tiers ADD COLUMN weight DECIMAL(6, 3) NOT NULL DEFAULT 1 CHECK (weight >= 0);
+UPDATE pricing_tiers SET weight = 0.7 WHERE name = 'دانشجو';
+UPDATE pricing_tiers SET weight = 0.85 WHERE name = 'نیمه بزرگسال';
+
+ALTER TABLE sessions ADD COLUMN venue_cost DECIMAL(12, 2) CHECK (venue_cost >= 0);
+
+-- The weight a split charge was computed with, so the split can be reproduced
+ALTER TABLE ledger_postings ADD COLUMN weight DECIMAL(6, 3);
+
+-- +goose Down
+ALTER TABLE ledger_postings DROP COLUMN IF EXISTS weight;
+ALTER TABLE sessions DROP COLUMN IF EXISTS venue_cost;
+ALTER TABLE pricing_tiers DROP COLUMN IF EXISTS weight;
+ALTER TABLE groups DROP COLUMN IF EXISTS pricing_mode;
@@ -0,0 +1,33 @@
+-- +goose Up
+-- Discounts and exemptions an admin grants a member. 'percent' and 'fixed'
+-- take value off each session fee, 'exempt' waives it entirely. An
+-- adjustment tied to a session applies to that session only; otherwise it
+-- applies to every session between starts_on and ends_on (open ends mean
+-- no limit) until it is revoked.
+CREATE TABLE IF NOT EXISTS member_adjustments (
+    id BIGSERIAL PRIMARY KEY,
+    group_id BIGINT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(16) NOT NULL CHECK (kind IN ('percent', 'fixed', 'exempt')),
+    value DECIMAL(12, 2) NOT NULL DEFAULT 0 CHECK (value >= 0),
+    session_id BIGINT REFERENCES sessions(id) ON DELETE CASCADE,
+    starts_on DATE,
+    ends_on DATE,
+    created_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    revoked_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    CHECK (kind <> 'percent' OR value <= 100),
+    CHECK (ends_on IS NULL OR starts_on IS NULL OR ends_on >= starts_on)
+);
+
+CREATE INDEX idx_member_adjustments_group_user ON member_adjustments(group_id, user_id);
+
+-- A discount is a member posting that names the adjustment it came from,
+-- balanced against the 'discount' account, so it is reported apart from the
+-- session fee it reduces
+ALTER TABLE ledger_postings ADD COLUMN adjustment_id BIGINT REFERENCES member_adjustments(id) ON DELETE SET NULL;
+
+-- +goose Down
+ALTER TABLE ledger_postings DROP COLUMN IF EXISTS adjustment_id;
+DROP TABLE IF EXISTS member_adjustments;
@@ -0,0 +1,25 @@
+-- +goose Up
+-- A session's attendance is recorded once; recording it again while the
+-- first record stands would charge its attendees twice. Duplicates recorded
+-- before this migration keep their charges but no longer claim the session,
+-- so they can still be reverted on their own.
+UPDATE attendance_records ar
+SET session_id = NULL
+WHERE ar.session_id IS NOT NULL AND NOT ar.is_reverted
+  AND EXISTS (
+      SELECT 1 FROM attendance_records first
+      WHERE first.session_id = ar.session_id AND NOT first.is_reverted AND first.id < ar.id
+  );
+
+CREATE UNIQUE INDEX idx_attendance_records_open_session ON attendance_records(session_id) WHERE NOT is_reverted;
+
+-- An attendance checklist carries a nonce that is spent when it is
+-- confirmed, so pressing its confirm button twice records it once
+ALTER TABLE attendance_records ADD COLUMN nonce VARCHAR(32);
+
+CREATE UNIQUE INDEX idx_attendance_records_nonce ON attendance_records(nonce);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_attendance_records_nonce;
+ALTER TABLE attendance_records DROP COLUMN IF EXISTS nonce;
+DROP INDEX IF EXISTS idx_attendance_records_open_session;
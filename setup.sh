@@ -48,7 +48,7 @@ fi
 echo ""
 echo -e "${YELLOW}Please configure the following in .env file:${NC}"
 echo "1. BOT_TOKEN - Get it from @BotFather on Telegram"
-echo "2. DEFAULT_ADMIN_ID - Your Telegram user ID (get from @userinfobot)"
+echo "2. SUPERADMIN_IDS - Your Telegram user ID (get from @userinfobot), comma-separated for several"
 echo "3. DB_PASSWORD - A secure password for PostgreSQL"
 echo ""
 
@@ -68,8 +68,8 @@ if [ -z "$BOT_TOKEN" ] || [ "$BOT_TOKEN" = "your_telegram_bot_token_here" ]; the
     exit 1
 fi
 
-if [ -z "$DEFAULT_ADMIN_ID" ] || [ "$DEFAULT_ADMIN_ID" = "your_telegram_user_id_here" ]; then
-    echo -e "${RED}Error: DEFAULT_ADMIN_ID is not configured in .env${NC}"
+if { [ -z "$SUPERADMIN_IDS" ] || [ "$SUPERADMIN_IDS" = "your_telegram_user_id_here" ]; } && [ -z "$DEFAULT_ADMIN_ID" ]; then
+    echo -e "${RED}Error: SUPERADMIN_IDS is not configured in .env${NC}"
     exit 1
 fi
 